@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/kube"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "Generate a shell completion script",
+	Long: `Generate a shell completion script for kpfm.
+
+  bash: source <(kpfm completion bash)
+  zsh:  kpfm completion zsh > "${fpath[1]}/_kpfm"
+  fish: kpfm completion fish > ~/.config/fish/completions/kpfm.fish`,
+	ValidArgs:             []string{"bash", "zsh", "fish"},
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		default:
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		}
+	},
+}
+
+func init() {
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(completionCmd)
+}
+
+// completeContextNames completes kube context names from both the kubeconfig and the kpfm config.
+func completeContextNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	seen := map[string]bool{}
+	if names, err := kube.ListContexts(); err == nil {
+		for _, name := range names {
+			seen[name] = true
+		}
+	}
+	if contexts, err := config.Read(configPath); err == nil {
+		for _, ctx := range contexts.Contexts {
+			seen[ctx.Name] = true
+		}
+	}
+	return sortedKeys(seen), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeConnectionNames completes the connection, service and pod names configured in the
+// kpfm config.
+func completeConnectionNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	contexts, err := config.Read(configPath)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	seen := map[string]bool{}
+	for _, ctx := range contexts.Contexts {
+		for _, conn := range ctx.Connections {
+			if conn.Name != "" {
+				seen[conn.Name] = true
+			}
+			if conn.ServiceName != "" {
+				seen[conn.ServiceName] = true
+			}
+			if conn.PodName != "" {
+				seen[conn.PodName] = true
+			}
+		}
+	}
+	return sortedKeys(seen), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTags completes the tags used by any connection in the kpfm config.
+func completeTags(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	contexts, err := config.Read(configPath)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	seen := map[string]bool{}
+	for _, ctx := range contexts.Contexts {
+		for _, conn := range ctx.Connections {
+			for _, tag := range conn.Tags {
+				seen[tag] = true
+			}
+		}
+	}
+	return sortedKeys(seen), cobra.ShellCompDirectiveNoFileComp
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
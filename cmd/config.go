@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+)
+
+var configMigrateDryRun bool
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the kpfm config file",
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the config file to the current layout",
+	Long: "Upgrade the config file to the layout of this kpfm and set its Version. kpfm reads\n" +
+		"older layouts as is, upgrading them in memory; this writes the upgrade to the file,\n" +
+		"keeping its comments and anchors and a backup of the previous version.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, steps, err := config.Migrate(configPath)
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			fmt.Fprintf(os.Stderr, "%s is already at version %d\n", configPath, config.CurrentVersion)
+			return nil
+		}
+		if configMigrateDryRun {
+			_, err := os.Stdout.Write(data)
+			return err
+		}
+		if err := config.WriteFile(configPath, data); err != nil {
+			return err
+		}
+		for _, step := range steps {
+			fmt.Printf("Migrated %s\n", step)
+		}
+		fmt.Printf("%s is now at version %d, the previous file is in %s\n", configPath, config.CurrentVersion, config.BackupDir())
+		return nil
+	},
+}
+
+func init() {
+	configMigrateCmd.Flags().BoolVar(&configMigrateDryRun, "dry-run", false, "print the upgraded config instead of writing it")
+	configCmd.AddCommand(configMigrateCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/manager"
+	"github.com/rparaujo/kpfm/pkg/model"
+	"github.com/rparaujo/kpfm/pkg/notify"
+)
+
+// contextSwitchOptions returns the manager options of how the forwards follow the current
+// context: the ContextSwitch block's debounce, confirmation and polling, overridden by
+// the --check-interval and --watch-kubeconfig flags. interactive tells whether the
+// terminal may be prompted.
+func contextSwitchOptions(c *model.ContextSwitch, interactive bool) (manager.Options, error) {
+	var opts manager.Options
+	if c != nil {
+		opts.ContextDebounce = time.Duration(c.Debounce)
+		opts.CheckInterval = time.Duration(c.CheckInterval)
+		opts.WatchKubeconfig = c.Watch
+		confirm, err := contextSwitchConfirm(c.Confirm, interactive)
+		if err != nil {
+			return manager.Options{}, err
+		}
+		opts.ConfirmContextSwitch = confirm
+	}
+	if checkInterval > 0 {
+		opts.CheckInterval = checkInterval
+	}
+	if watchKubeconfig {
+		opts.WatchKubeconfig = true
+	}
+	return opts, nil
+}
+
+// contextSwitchConfirm returns the function asking before a context switch for the
+// ContextSwitch.Confirm setting, nil when the switch isn't asked about.
+func contextSwitchConfirm(confirm string, interactive bool) (func(from, to string) bool, error) {
+	switch strings.ToLower(confirm) {
+	case "":
+		return nil, nil
+	case "terminal":
+		if !interactive || !stdinIsTerminal() {
+			log.Printf("ContextSwitch.Confirm is terminal but there is no terminal to ask on; following context changes without asking")
+			return nil, nil
+		}
+		return confirmOnTerminal(), nil
+	case "desktop":
+		return confirmOnDesktop, nil
+	}
+	return nil, fmt.Errorf("invalid ContextSwitch.Confirm %q: must be terminal or desktop", confirm)
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmOnTerminal asks on stdin, reading the answers with a goroutine of its own so a
+// pending read doesn't outlive the prompt that started it.
+func confirmOnTerminal() func(from, to string) bool {
+	answers := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			answers <- scanner.Text()
+		}
+		close(answers)
+	}()
+	return func(from, to string) bool {
+		fmt.Printf("Kube context changed from %s to %s. Restart the forwards on %s? [y/N] ", from, to, to)
+		answer, ok := <-answers
+		if !ok {
+			return false
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
+
+// confirmOnDesktop asks with a desktop dialog, following the change when none can be shown.
+func confirmOnDesktop(from, to string) bool {
+	ok, err := notify.Confirm("kpfm", fmt.Sprintf("Kube context changed from %s to %s. Restart the forwards on %s?", from, to, to))
+	if err != nil {
+		log.Printf("Cannot show confirmation dialog: %v", err)
+		return true
+	}
+	return ok
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+var (
+	discoverNamespace string
+	discoverSelector  string
+	discoverContext   string
+)
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Scaffold a Contexts config block from the services in the cluster",
+	Long: "List the services of the current kube context and print a ready-to-use Contexts YAML block,\n" +
+		"mapping every TCP service port to a suggested free local port.",
+	Args: cobra.NoArgs,
+	RunE: runDiscover,
+}
+
+func init() {
+	discoverCmd.Flags().StringVarP(&discoverNamespace, "namespace", "n", "", "only discover services in this namespace (default all namespaces)")
+	discoverCmd.Flags().StringVarP(&discoverSelector, "selector", "l", "", "label selector to filter services")
+	discoverCmd.Flags().StringVar(&discoverContext, "context", "", "kube context to discover (default the current context)")
+	_ = discoverCmd.RegisterFlagCompletionFunc("context", completeContextNames)
+	rootCmd.AddCommand(discoverCmd)
+}
+
+func runDiscover(cmd *cobra.Command, args []string) error {
+	contextName := discoverContext
+	if contextName == "" {
+		current, err := kube.GetCurrentContext()
+		if err != nil {
+			return fmt.Errorf("error getting current context: %v", err)
+		}
+		contextName = current
+	}
+
+	_, clientset, err := kube.NewClientset(discoverContext)
+	if err != nil {
+		return err
+	}
+
+	services, err := kube.ListServices(cmd.Context(), clientset, discoverNamespace, discoverSelector)
+	if err != nil {
+		return fmt.Errorf("error listing services: %v", err)
+	}
+
+	taken := map[int]bool{}
+	discovered := model.Context{Name: contextName}
+	for _, svc := range services {
+		// Services without a selector, ExternalName ones included, go through a relay pod.
+		relay := len(svc.Spec.Selector) == 0
+		mappings, err := kube.PortMappings(cmd.Context(), clientset, &svc)
+		if err != nil {
+			return fmt.Errorf("error reading the ports of %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+		for _, port := range mappings {
+			if port.Protocol != "" && port.Protocol != corev1.ProtocolTCP {
+				continue
+			}
+			// A relay reaches the service itself, anything else the port of its pods.
+			remotePort := int(port.Port)
+			if !relay {
+				remotePort = int(port.RemotePort())
+			}
+			if remotePort == 0 {
+				fmt.Fprintf(os.Stderr, "# skipping %s/%s:%d: target port %s not declared by its pods\n", svc.Namespace, svc.Name, port.Port, port.TargetPort.String())
+				continue
+			}
+			localPort := kube.SuggestLocalPort(int(port.Port), taken)
+			if localPort == 0 {
+				fmt.Fprintf(os.Stderr, "# skipping %s/%s:%d: no free local port\n", svc.Namespace, svc.Name, port.Port)
+				continue
+			}
+			taken[localPort] = true
+			discovered.Connections = append(discovered.Connections, model.Connection{
+				ServiceName:       svc.Name,
+				RemoteServicePort: remotePort,
+				Namespace:         svc.Namespace,
+				LocalPort:         localPort,
+				Relay:             relay,
+			})
+		}
+	}
+
+	out, err := yaml.Marshal(model.Contexts{Contexts: []model.Context{discovered}})
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
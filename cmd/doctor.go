@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+var doctorContext string
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the config, kubeconfig, cluster access and local ports",
+	Long: "Check that the kubeconfig loads, the cluster is reachable, the auth plugin is installed,\n" +
+		"every connection resolves to a pod you may port-forward to, its local port is free and the\n" +
+		"config makes sense, printing a pass/fail report.",
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	doctorCmd.Flags().StringVar(&doctorContext, "context", "", "kube context to check (default the current context)")
+	_ = doctorCmd.RegisterFlagCompletionFunc("context", completeContextNames)
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// doctorReport prints check results and counts the failed ones.
+type doctorReport struct {
+	failed int
+}
+
+func (r *doctorReport) pass(format string, args ...interface{}) {
+	fmt.Printf("[PASS] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) warn(format string, args ...interface{}) {
+	fmt.Printf("[WARN] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) fail(format string, args ...interface{}) {
+	fmt.Printf("[FAIL] %s\n", fmt.Sprintf(format, args...))
+	r.failed++
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	r := &doctorReport{}
+
+	contexts, err := config.Read(configPath)
+	if err != nil {
+		r.fail("config %s: %v", configPath, err)
+		return fmt.Errorf("%d check(s) failed", r.failed)
+	}
+	r.pass("config %s loads", configPath)
+	kube.SetJumpHosts(contexts)
+	kube.SetAuth(contexts)
+	kube.SetRequestTimeout(time.Duration(contexts.RequestTimeout))
+	defer kube.CloseJumpHosts()
+
+	contextName := doctorContext
+	if contextName == "" {
+		contextName, err = kube.GetCurrentContext()
+		if err != nil {
+			r.fail("kubeconfig: %v", err)
+			return fmt.Errorf("%d check(s) failed", r.failed)
+		}
+	}
+	r.pass("kubeconfig loads, context %s", contextName)
+
+	var connections []model.Connection
+	found := false
+	for _, ctx := range contexts.Contexts {
+		if ctx.Name == contextName {
+			found = true
+			connections = append(connections, ctx.Connections...)
+		}
+	}
+	if !found {
+		r.fail("context %s has no Contexts entry in the config", contextName)
+	} else if len(connections) == 0 {
+		r.warn("context %s has no connections", contextName)
+	}
+	if err := contexts.CheckLocalPorts(contextName, nil); err != nil {
+		r.fail("%v", err)
+	}
+	if err := contexts.CheckNames(contextName, nil); err != nil {
+		r.fail("%v", err)
+	}
+	if err := contexts.CheckDependencies(contextName); err != nil {
+		r.fail("%v", err)
+	}
+	for _, connection := range connections {
+		if problem := connectionProblem(connection); problem != "" {
+			r.fail("%s/%s: %s", connection.Namespace, connection.Target(), problem)
+		}
+	}
+
+	if plugin, err := kube.ExecPlugin(doctorContext); err != nil {
+		r.fail("auth plugin: %v", err)
+	} else if plugin != "" {
+		if path, err := exec.LookPath(plugin); err != nil {
+			r.fail("auth plugin %s is not installed or not in PATH", plugin)
+		} else {
+			r.pass("auth plugin %s found at %s", plugin, path)
+		}
+	}
+
+	_, clientset, err := kube.NewClientset(doctorContext)
+	if err != nil {
+		r.fail("cannot build a client: %v", err)
+		return fmt.Errorf("%d check(s) failed", r.failed)
+	}
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		r.fail("cluster unreachable: %v", err)
+		return fmt.Errorf("%d check(s) failed", r.failed)
+	}
+	r.pass("cluster reachable, Kubernetes %s", version.GitVersion)
+
+	// Ports held by a running instance are expected to be busy.
+	_, statusErr := control.NewClient(config.SocketPath(configPath)).Status()
+	running := statusErr == nil
+	if running {
+		r.warn("kpfm is running, local port checks skipped")
+	}
+
+	for _, connection := range connections {
+		if connection.AllServices || connectionProblem(connection) != "" {
+			continue
+		}
+		name := fmt.Sprintf("%s/%s", connection.Namespace, connection.Target())
+		cs := clientset
+		if connection.Context != "" {
+			if _, cs, err = kube.NewClientset(connection.Context); err != nil {
+				r.fail("%s: context %s: %v", name, connection.Context, err)
+				continue
+			}
+		}
+		podName, err := kube.ResolvePodName(cmd.Context(), cs, connection)
+		if err != nil {
+			r.fail("%s: cannot resolve pod: %v", name, err)
+			continue
+		}
+		subresource, allowed, err := kube.CanForward(cmd.Context(), cs, connection, podName)
+		switch {
+		case err != nil:
+			r.fail("%s: cannot check RBAC: %v", name, err)
+			continue
+		case !allowed:
+			r.fail("%s: not allowed to create pods/%s on %s", name, subresource, podName)
+			continue
+		}
+		if !running && connection.LocalPort != 0 {
+			check := kube.CheckLocalPort
+			if connection.IsUDP() {
+				check = kube.CheckLocalUDPPort
+			}
+			if err := check(connection.BindAddresses()[0], connection.LocalPort); err != nil {
+				r.fail("%s: local port %d unavailable: %v", name, connection.LocalPort, err)
+				continue
+			}
+		}
+		if connection.Context != "" {
+			r.pass("%s: pod %s on context %s, %s allowed", name, podName, connection.Context, subresource)
+			continue
+		}
+		r.pass("%s: pod %s, %s allowed", name, podName, subresource)
+	}
+
+	if r.failed > 0 {
+		return fmt.Errorf("%d check(s) failed", r.failed)
+	}
+	fmt.Println("All checks passed.")
+	return nil
+}
+
+// connectionProblem returns what is wrong with a connection's settings, or "".
+func connectionProblem(connection model.Connection) string {
+	switch {
+	case connection.Namespace == "":
+		return "Namespace is empty"
+	case connection.AllServices:
+		return ""
+	case connection.ServiceName == "" && connection.PodName == "":
+		return "both ServiceName and PodName are empty"
+	case connection.RemoteServicePort <= 0 || connection.RemoteServicePort > 65535:
+		return "RemoteServicePort is not a valid port"
+	case connection.LocalPort < 0 || connection.LocalPort > 65535:
+		return "LocalPort is not a valid port"
+	case connection.Relay && connection.ServiceName == "":
+		return "Relay needs a ServiceName"
+	case connection.Relay && connection.LoadBalance:
+		return "Relay and LoadBalance cannot be combined"
+	case connection.LoadBalance && (connection.ServiceName == "" || connection.IsUDP()):
+		return "LoadBalance needs a TCP ServiceName connection"
+	case connection.PodOrdinal != nil && *connection.PodOrdinal < 0:
+		return "PodOrdinal is negative"
+	case connection.PodOrdinal != nil && connection.PodSelection != "":
+		return "PodOrdinal and PodSelection cannot be combined"
+	case connection.PodSelection == model.PodNamePrefix && connection.PodNamePrefix == "":
+		return "PodSelection name-prefix needs a PodNamePrefix"
+	case connection.TLS != nil && connection.IsUDP():
+		return "TLS needs a TCP connection"
+	case connection.TLS != nil && (connection.TLS.CertFile == "") != (connection.TLS.KeyFile == ""):
+		return "TLS needs both CertFile and KeyFile, or neither for a self-signed certificate"
+	case connection.Transport == model.TransportExec && (connection.IsUDP() || connection.Relay || connection.LoadBalance):
+		return "Transport exec cannot be combined with UDP, Relay or LoadBalance"
+	case connection.Interface != "" && connection.IsUDP():
+		return "Interface needs a TCP connection"
+	case connection.Interface != "" && (connection.Address != "" || connection.LocalSocket != ""):
+		return "Interface cannot be combined with Address or LocalSocket"
+	case connection.LocalSocket != "" && connection.IsUDP():
+		return "LocalSocket needs a TCP connection"
+	case connection.LocalSocket != "" && connection.LocalPort != 0:
+		return "LocalSocket and LocalPort cannot be combined"
+	case connection.OwnerOnly && connection.IsUDP():
+		return "OwnerOnly needs a TCP connection"
+	case connection.RateLimit != nil && connection.IsUDP():
+		return "RateLimit needs a TCP connection"
+	case connection.Inject != nil && connection.IsUDP():
+		return "Inject needs a TCP connection"
+	case connection.Inject != nil && (connection.Inject.ClientCertFile == "") != (connection.Inject.ClientKeyFile == ""):
+		return "Inject needs both ClientCertFile and ClientKeyFile"
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+var (
+	eventsFollow   bool
+	eventsOutput   string
+	eventsSelector string
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Show the lifecycle events of the running kpfm instance",
+	Args:  cobra.NoArgs,
+	RunE:  runEvents,
+}
+
+func init() {
+	eventsCmd.Flags().BoolVarP(&eventsFollow, "follow", "f", false, "keep streaming new events")
+	eventsCmd.Flags().StringVarP(&eventsOutput, "output", "o", "text", "output format: text or json (one event per line)")
+	addSelectorFlag(eventsCmd, &eventsSelector, "only show the events of forwards")
+	_ = eventsCmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp))
+	rootCmd.AddCommand(eventsCmd)
+}
+
+func runEvents(cmd *cobra.Command, args []string) error {
+	var print func(control.Event) error
+	switch eventsOutput {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		print = func(event control.Event) error { return enc.Encode(event) }
+	case "text":
+		print = func(event control.Event) error {
+			_, err := fmt.Println(formatEvent(event))
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output format %q, use text or json", eventsOutput)
+	}
+	if eventsSelector != "" {
+		selector, err := parseSelector(eventsSelector)
+		if err != nil {
+			return err
+		}
+		printAll := print
+		print = func(event control.Event) error {
+			// Events of no forward, such as context changes, are always shown.
+			if event.Name != "" && !selector.Matches(labels.Set(event.Labels)) {
+				return nil
+			}
+			return printAll(event)
+		}
+	}
+	return control.NewClient(config.SocketPath(configPath)).Events(eventsFollow, print)
+}
+
+// formatEvent renders an event as a single human readable line.
+func formatEvent(event control.Event) string {
+	parts := []string{event.Time.Local().Format(time.RFC3339), string(event.Type)}
+	if event.Name != "" {
+		parts = append(parts, event.Name)
+	}
+	if event.Context != "" {
+		parts = append(parts, "context="+event.Context)
+	}
+	if event.Pod != "" {
+		parts = append(parts, "pod="+event.Pod)
+	}
+	if len(event.Labels) > 0 {
+		parts = append(parts, "labels="+model.FormatLabels(event.Labels))
+	}
+	if event.Error != "" {
+		parts = append(parts, "error="+event.Error)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/model"
+	"github.com/rparaujo/kpfm/pkg/ports"
+)
+
+var exportFormat string
+
+var exportCmd = &cobra.Command{
+	Use:   "export [connection...]",
+	Short: "Print the connections of the current kube context as kubectl port-forward commands",
+	Long: "Render the connections of the current kube context (or only the named ones) as the equivalent\n" +
+		"kubectl port-forward commands, one per line, or as a shell script running all of them until\n" +
+		"interrupted. Connections kubectl cannot express (UDP, AllServices) are listed as comments.",
+	RunE:              runExport,
+	ValidArgsFunction: completeConnectionNames,
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "kubectl", "output format: kubectl or script")
+	exportCmd.Flags().StringSliceVar(&startTags, "tags", nil, "only export connections carrying at least one of these tags")
+	addSelectorFlag(exportCmd, &startSelector, "only export connections")
+	exportCmd.Flags().StringVar(&startContext, "context", "", "export the connections of this kube context instead of the current context")
+	_ = exportCmd.RegisterFlagCompletionFunc("tags", completeTags)
+	_ = exportCmd.RegisterFlagCompletionFunc("context", completeContextNames)
+	rootCmd.AddCommand(exportCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	if exportFormat != "kubectl" && exportFormat != "script" {
+		return fmt.Errorf("unknown export format %q, use kubectl or script", exportFormat)
+	}
+	startNames = args
+	if err := parseStartSelector(); err != nil {
+		return err
+	}
+
+	contexts, err := config.Read(configPath)
+	if err != nil {
+		return fmt.Errorf("error reading YAML file: %v", err)
+	}
+	if err := ports.Assign(contexts, config.PortsPath()); err != nil {
+		return fmt.Errorf("error assigning local ports: %v", err)
+	}
+	currentContext := startContext
+	if currentContext == "" {
+		currentContext, err = kube.GetCurrentContext()
+		if err != nil {
+			return fmt.Errorf("error getting current context: %v", err)
+		}
+	}
+
+	var connections []model.Connection
+	for _, ctx := range contexts.Contexts {
+		if ctx.Name != currentContext {
+			continue
+		}
+		for _, connection := range ctx.Connections {
+			if wanted(connection) {
+				connections = append(connections, connection)
+			}
+		}
+	}
+	if len(connections) == 0 {
+		return fmt.Errorf("no connections configured for context %s", currentContext)
+	}
+
+	if exportFormat == "script" {
+		return writeExportScript(os.Stdout, currentContext, connections)
+	}
+	for _, connection := range connections {
+		if reason := unexportable(connection); reason != "" {
+			fmt.Printf("# %s/%s: %s\n", connection.Namespace, connection.Target(), reason)
+			continue
+		}
+		fmt.Println(kubectlCommand(currentContext, connection))
+	}
+	return nil
+}
+
+// writeExportScript writes a POSIX shell script running a kubectl port-forward per
+// connection in the background and stopping all of them when it is interrupted.
+func writeExportScript(w io.Writer, kubeContext string, connections []model.Connection) error {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Port-forwards of kube context %s, exported by kpfm.\n", kubeContext)
+	b.WriteString("set -e\n")
+	b.WriteString("trap 'kill $(jobs -p) 2>/dev/null' EXIT\n\n")
+	for _, connection := range connections {
+		if reason := unexportable(connection); reason != "" {
+			fmt.Fprintf(&b, "# %s/%s: %s\n", connection.Namespace, connection.Target(), reason)
+			continue
+		}
+		fmt.Fprintf(&b, "%s &\n", kubectlCommand(kubeContext, connection))
+	}
+	b.WriteString("\nwait\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// unexportable returns why kubectl port-forward cannot reproduce connection, if it can't.
+func unexportable(connection model.Connection) string {
+	switch {
+	case connection.AllServices:
+		return "AllServices has no kubectl equivalent, skipped"
+	case connection.IsUDP():
+		return "kubectl port-forward does not forward UDP, skipped"
+	}
+	return ""
+}
+
+// kubectlCommand returns the kubectl port-forward command equivalent to connection.
+func kubectlCommand(kubeContext string, connection model.Connection) string {
+	args := []string{"kubectl"}
+	if kubeContext != kube.InClusterContext {
+		args = append(args, "--context", shellQuote(kubeContext))
+	}
+	args = append(args, "port-forward", "-n", shellQuote(connection.Namespace), shellQuote(connection.Target()),
+		strconv.Itoa(connection.LocalPort)+":"+strconv.Itoa(connection.RemoteServicePort))
+	if connection.Address != "" {
+		args = append(args, "--address", shellQuote(connection.Address))
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes s for a POSIX shell when it contains anything beyond a safe set of
+// characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:@=,") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/manager"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+var (
+	fwdNamespace string
+	fwdContext   string
+	fwdAddress   string
+)
+
+var fwdCmd = &cobra.Command{
+	Use:   "fwd <pod|svc/name|pod/name> [LOCAL_PORT:]REMOTE_PORT...",
+	Short: "Forward ports of a single pod or service without a config file",
+	Long: "Forward local ports to a pod or service like kubectl port-forward, but resolving the pod\n" +
+		"again and reconnecting whenever the forward breaks. A bare name is treated as a pod, and a\n" +
+		"port given as :REMOTE_PORT gets a free local port.",
+	Example: "  kpfm fwd svc/postgres 5432:5432 -n data",
+	Args:    cobra.MinimumNArgs(2),
+	RunE:    runFwd,
+}
+
+func init() {
+	fwdCmd.Flags().StringVarP(&fwdNamespace, "namespace", "n", "default", "namespace of the pod or service")
+	fwdCmd.Flags().StringVar(&fwdContext, "context", "", "kube context to forward from instead of the current context")
+	fwdCmd.Flags().StringVar(&fwdAddress, "address", "", "local address to listen on (default localhost)")
+	_ = fwdCmd.RegisterFlagCompletionFunc("context", completeContextNames)
+	rootCmd.AddCommand(fwdCmd)
+}
+
+func runFwd(cmd *cobra.Command, args []string) error {
+	connections, err := fwdConnections(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+
+	kubeContext := fwdContext
+	if kubeContext == "" {
+		kubeContext, err = kube.GetCurrentContext()
+		if err != nil {
+			return fmt.Errorf("error getting current context: %v", err)
+		}
+	}
+	contexts := &model.Contexts{Contexts: []model.Context{{Name: kubeContext, Connections: connections}}}
+	if err := contexts.CheckLocalPorts(kubeContext, nil); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Pinned to the context: the forward must not follow a context switch to a cluster
+	// that may not have the target.
+	m := manager.New(contexts, manager.Options{Context: kubeContext})
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+	if err := m.Start(ctx); err != nil {
+		return fmt.Errorf("error starting port-forwards: %v", err)
+	}
+	logEvents(events, false)
+	return nil
+}
+
+// fwdConnections builds a connection per port mapping to target, a pod or kind/name.
+func fwdConnections(target string, mappings []string) ([]model.Connection, error) {
+	kind, name := "pod", target
+	if i := strings.Index(target, "/"); i >= 0 {
+		kind, name = target[:i], target[i+1:]
+	}
+	base := model.Connection{Namespace: fwdNamespace, Address: fwdAddress}
+	switch kind {
+	case "pod", "pods", "po":
+		base.PodName = name
+	case "svc", "service", "services":
+		base.ServiceName = name
+	default:
+		return nil, fmt.Errorf("unsupported resource kind %q, expected pod or svc", kind)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("missing %s name", kind)
+	}
+
+	taken := make(map[int]bool)
+	var connections []model.Connection
+	for _, mapping := range mappings {
+		local, remote, err := parsePortMapping(mapping)
+		if err != nil {
+			return nil, err
+		}
+		if local == 0 {
+			if local = kube.SuggestLocalPort(remote, taken); local == 0 {
+				return nil, fmt.Errorf("no free local port for %s", mapping)
+			}
+		}
+		taken[local] = true
+
+		connection := base
+		connection.LocalPort = local
+		connection.RemoteServicePort = remote
+		if len(mappings) > 1 {
+			connection.Name = fmt.Sprintf("%s:%d", name, remote)
+		}
+		connections = append(connections, connection)
+	}
+	return connections, nil
+}
+
+// parsePortMapping parses a kubectl style port mapping: PORT, LOCAL:REMOTE or :REMOTE,
+// the latter returning a zero local port.
+func parsePortMapping(mapping string) (int, int, error) {
+	localPart, remotePart := mapping, mapping
+	if i := strings.Index(mapping, ":"); i >= 0 {
+		localPart, remotePart = mapping[:i], mapping[i+1:]
+	}
+	remote, err := strconv.Atoi(remotePart)
+	if err != nil || remote <= 0 || remote > 65535 {
+		return 0, 0, fmt.Errorf("invalid port mapping %q", mapping)
+	}
+	if localPart == "" {
+		return 0, remote, nil
+	}
+	local, err := strconv.Atoi(localPart)
+	if err != nil || local <= 0 || local > 65535 {
+		return 0, 0, fmt.Errorf("invalid port mapping %q", mapping)
+	}
+	return local, remote, nil
+}
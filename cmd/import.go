@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+var (
+	importFile  string
+	importWrite bool
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import [\"kubectl port-forward ...\"...]",
+	Short: "Convert kubectl port-forward command lines into a Contexts config block",
+	Long: "Parse kubectl port-forward command lines, given as arguments or found in a shell history\n" +
+		"file, and print the equivalent Contexts YAML block. Forwards without --context go under the\n" +
+		"current kube context. With --write the connections the config file doesn't have yet are\n" +
+		"added to it instead, leaving the rest of the file, comments included, as it is and keeping\n" +
+		"a backup of the previous version.",
+	Example: "  kpfm import \"kubectl port-forward -n data svc/postgres 5432:5432\"\n" +
+		"  kpfm import --file ~/.bash_history --write",
+	RunE: runImport,
+}
+
+func init() {
+	importCmd.Flags().StringVarP(&importFile, "file", "f", "", "read command lines from this file, e.g. a shell history (- for stdin)")
+	importCmd.Flags().BoolVarP(&importWrite, "write", "w", false, "add the connections to the config file instead of printing them")
+	rootCmd.AddCommand(importCmd)
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	lines := args
+	if importFile != "" {
+		fromFile, err := readImportFile(importFile)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fromFile...)
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("nothing to import, pass kubectl port-forward command lines or --file")
+	}
+
+	var currentContext string
+	byContext := make(map[string][]model.Connection)
+	var order []string
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		kubeContext, connections, err := parsePortForward(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "# skipping %q: %v\n", line, err)
+			continue
+		}
+		if connections == nil {
+			continue
+		}
+		if kubeContext == "" {
+			if currentContext == "" {
+				if currentContext, err = kube.GetCurrentContext(); err != nil {
+					return fmt.Errorf("error getting current context: %v", err)
+				}
+			}
+			kubeContext = currentContext
+		}
+		if _, ok := byContext[kubeContext]; !ok {
+			order = append(order, kubeContext)
+		}
+		for _, connection := range connections {
+			// History files repeat the same invocations.
+			key := fmt.Sprintf("%s/%s/%s:%d:%d", kubeContext, connection.Namespace, connection.Target(), connection.RemoteServicePort, connection.LocalPort)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			byContext[kubeContext] = append(byContext[kubeContext], connection)
+		}
+	}
+
+	imported := model.Contexts{}
+	for _, kubeContext := range order {
+		imported.Contexts = append(imported.Contexts, model.Context{Name: kubeContext, Connections: nameImported(byContext[kubeContext])})
+	}
+	if len(imported.Contexts) == 0 {
+		return fmt.Errorf("no kubectl port-forward command lines found")
+	}
+	if importWrite {
+		added, err := config.Append(configPath, &imported)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %v", configPath, err)
+		}
+		total := 0
+		for _, ctx := range imported.Contexts {
+			total += len(ctx.Connections)
+		}
+		if added == 0 {
+			fmt.Fprintf(os.Stderr, "All %d imported connections are already in %s\n", total, configPath)
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Added %d of the %d imported connections to %s, the others are already in it\n", added, total, configPath)
+		return nil
+	}
+	out, err := yaml.Marshal(imported)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// readImportFile returns the lines of path, or of stdin for "-". zsh extended history
+// prefixes are removed.
+func readImportFile(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, ": ") {
+			// ": <timestamp>:<duration>;<command>"
+			if i := strings.Index(line, ";"); i >= 0 {
+				line = line[i+1:]
+			}
+		}
+		if strings.Contains(line, "port-forward") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// parsePortForward converts a kubectl port-forward command line into connections and the
+// kube context given with --context, if any. Lines of other commands yield no connections.
+func parsePortForward(line string) (string, []model.Connection, error) {
+	words, err := shellWords(line)
+	if err != nil {
+		return "", nil, err
+	}
+	for len(words) > 0 {
+		command, rest := words, []string(nil)
+		for i, word := range words {
+			if isShellOperator(word) {
+				command, rest = words[:i], words[i+1:]
+				break
+			}
+		}
+		words = rest
+		kubeContext, connections, err := parseKubectl(command)
+		if connections != nil || err != nil {
+			return kubeContext, connections, err
+		}
+	}
+	return "", nil, nil
+}
+
+// parseKubectl parses the words of a single command, skipping anything before kubectl
+// such as environment assignments.
+func parseKubectl(words []string) (string, []model.Connection, error) {
+	for len(words) > 0 && words[0] != "kubectl" && !strings.HasSuffix(words[0], "/kubectl") {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return "", nil, nil
+	}
+
+	var kubeContext, namespace, address, target string
+	var mappings []string
+	isPortForward := false
+	// Flags taking a value, besides the ones kept.
+	valueFlags := map[string]bool{"--kubeconfig": true, "--pod-running-timeout": true, "--cluster": true, "--user": true, "-s": true, "--server": true, "--token": true, "--request-timeout": true}
+	for i := 1; i < len(words); i++ {
+		word := words[i]
+		flag, value, hasValue := word, "", false
+		if strings.HasPrefix(word, "--") {
+			if j := strings.Index(word, "="); j >= 0 {
+				flag, value, hasValue = word[:j], word[j+1:], true
+			}
+		} else if strings.HasPrefix(word, "-n") && len(word) > 2 {
+			flag, value, hasValue = "-n", strings.TrimPrefix(word[2:], "="), true
+		}
+		takeValue := func() string {
+			if hasValue {
+				return value
+			}
+			if i+1 < len(words) {
+				i++
+				return words[i]
+			}
+			return ""
+		}
+
+		switch {
+		case flag == "-n" || flag == "--namespace":
+			namespace = takeValue()
+		case flag == "--context":
+			kubeContext = takeValue()
+		case flag == "--address":
+			// kpfm binds one address; localhost already covers both loopbacks.
+			address = strings.Split(takeValue(), ",")[0]
+		case valueFlags[flag]:
+			takeValue()
+		case strings.HasPrefix(word, "-"):
+		case !isPortForward:
+			if word != "port-forward" {
+				return "", nil, nil
+			}
+			isPortForward = true
+		case target == "":
+			target = word
+		default:
+			mappings = append(mappings, word)
+		}
+	}
+	if !isPortForward {
+		return "", nil, nil
+	}
+	if target == "" || len(mappings) == 0 {
+		return "", nil, fmt.Errorf("missing target or ports")
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	if address == "localhost" {
+		address = ""
+	}
+
+	kind, name := "pod", target
+	if i := strings.Index(target, "/"); i >= 0 {
+		kind, name = target[:i], target[i+1:]
+	}
+	base := model.Connection{Namespace: namespace, Address: address}
+	switch kind {
+	case "pod", "pods", "po":
+		base.PodName = name
+	case "svc", "service", "services":
+		base.ServiceName = name
+	default:
+		return "", nil, fmt.Errorf("kpfm forwards pods and services, not %s", kind)
+	}
+
+	var connections []model.Connection
+	for _, mapping := range mappings {
+		local, remote, err := parsePortMapping(mapping)
+		if err != nil {
+			return "", nil, err
+		}
+		connection := base
+		connection.LocalPort = local // zero for ":REMOTE", assigned by kpfm
+		connection.RemoteServicePort = remote
+		connections = append(connections, connection)
+	}
+	return kubeContext, connections, nil
+}
+
+// nameImported gives a Name to connections whose target is forwarded on more than one
+// port, so that every connection of the context keeps a distinct name.
+func nameImported(connections []model.Connection) []model.Connection {
+	count := make(map[string]int)
+	for _, connection := range connections {
+		count[connection.DisplayName()]++
+	}
+	for i, connection := range connections {
+		if count[connection.DisplayName()] > 1 {
+			connections[i].Name = fmt.Sprintf("%s:%d", connection.DisplayName(), connection.RemoteServicePort)
+		}
+	}
+	return connections
+}
+
+func isShellOperator(word string) bool {
+	return word == ";" || word == "&" || word == "|"
+}
+
+// shellWords splits a command line into words the way a POSIX shell would for simple
+// commands, honouring quotes and backslash escapes. Unquoted ;, & and | come out as words
+// of their own.
+func shellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case isShellOperator(string(r)):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			words = append(words, string(r))
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
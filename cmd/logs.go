@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/logging"
+)
+
+var (
+	logsFollow bool
+	logsTail   int
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <connection>",
+	Short: "Show the log of a connection of the running kpfm instance",
+	Long: "Show the log the running kpfm instance keeps for a connection: its lifecycle events,\n" +
+		"pod resolution, the forwarder's output such as \"Handling connection for\", errors\n" +
+		"reported by client-go and health probe failures. The last 1000 lines are kept.",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeConnectionNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return control.NewClient(config.SocketPath(configPath)).Logs(args[0], logsTail, logsFollow, func(line logging.Line) error {
+			_, err := fmt.Printf("%s %s\n", line.Time.Local().Format("2006-01-02T15:04:05.000"), line.Text)
+			return err
+		})
+	},
+}
+
+func init() {
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "keep streaming new lines")
+	logsCmd.Flags().IntVar(&logsTail, "tail", -1, "only show the last lines, all of them when negative")
+	rootCmd.AddCommand(logsCmd)
+}
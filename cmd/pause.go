@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+)
+
+var pauseCmd = &cobra.Command{
+	Use:               "pause <connection>",
+	Short:             "Stop a connection of the running kpfm instance and free its local port until resumed",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeConnectionNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := control.NewClient(config.SocketPath(configPath)).Pause(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Paused %s\n", args[0])
+		return nil
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:               "resume <connection>",
+	Short:             "Bring a paused connection of the running kpfm instance up again",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeConnectionNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := control.NewClient(config.SocketPath(configPath)).Resume(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Resuming %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/kube"
+)
+
+var portsNamespace string
+
+var portsCmd = &cobra.Command{
+	Use:   "ports <pod|svc/name|pod/name>",
+	Short: "List container ports of a pod or service and the service ports mapping to them",
+	Long: "List the container ports of a pod (or the pod behind a service) and which service ports map to them,\n" +
+		"to help pick the RemoteServicePort of a connection. Init and ephemeral containers are included. For a service,\n" +
+		"each service port is listed first with the RemoteServicePort it maps to. A bare name is treated as a pod.",
+	Args: cobra.ExactArgs(1),
+	RunE: runPorts,
+}
+
+func init() {
+	portsCmd.Flags().StringVarP(&portsNamespace, "namespace", "n", "default", "namespace of the pod or service")
+	rootCmd.AddCommand(portsCmd)
+}
+
+func runPorts(cmd *cobra.Command, args []string) error {
+	_, clientset, err := kube.NewClientset("")
+	if err != nil {
+		return err
+	}
+
+	kind, name := "pod", args[0]
+	if i := strings.Index(args[0], "/"); i >= 0 {
+		kind, name = args[0][:i], args[0][i+1:]
+	}
+
+	podName := name
+	switch kind {
+	case "pod", "pods", "po":
+	case "svc", "service", "services":
+		mappings, err := kube.ServicePortMappings(cmd.Context(), clientset, portsNamespace, name)
+		if err != nil {
+			return err
+		}
+		if err := printServicePorts(name, mappings); err != nil {
+			return err
+		}
+		podName, err = kube.GetPodName(cmd.Context(), clientset, portsNamespace, name)
+		if err != nil {
+			return fmt.Errorf("cannot resolve pod for service %s: %v", name, err)
+		}
+		fmt.Println()
+	default:
+		return fmt.Errorf("unsupported resource kind %q, expected pod or svc", kind)
+	}
+
+	ports, err := kube.ListPorts(cmd.Context(), clientset, podName, portsNamespace)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Pod %s/%s\n", portsNamespace, podName)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tNAME\tPORT\tPROTOCOL\tSERVICE PORTS")
+	for _, port := range ports {
+		services := strings.Join(port.ServicePorts, ",")
+		if services == "" {
+			services = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", containerLabel(port), port.Name, port.Port, port.Protocol, services)
+	}
+	return w.Flush()
+}
+
+// printServicePorts prints the ports of a service and the container ports they map to.
+func printServicePorts(service string, mappings []kube.ServicePortMapping) error {
+	fmt.Printf("Service %s/%s\n", portsNamespace, service)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PORT\tNAME\tPROTOCOL\tTARGET\tREMOTE PORT\tCONTAINER PORTS")
+	for _, mapping := range mappings {
+		target := mapping.TargetPort.String()
+		if target == "0" {
+			target = "-"
+		}
+		remote := "-"
+		if port := mapping.RemotePort(); port != 0 {
+			remote = fmt.Sprint(port)
+		}
+		var containers []string
+		for _, port := range mapping.Targets {
+			containers = append(containers, fmt.Sprintf("%s:%d", containerLabel(port), port.Port))
+		}
+		if len(containers) == 0 {
+			containers = []string{"-"}
+		}
+		name := mapping.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", mapping.Port, name, mapping.Protocol, target, remote, strings.Join(containers, ","))
+	}
+	return w.Flush()
+}
+
+// containerLabel names the container of port, marking init and ephemeral ones.
+func containerLabel(port kube.ContainerPort) string {
+	if port.Kind == "" {
+		return port.Container
+	}
+	return port.Container + " (" + port.Kind + ")"
+}
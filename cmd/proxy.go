@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/socks"
+)
+
+var (
+	socksAddr          string
+	proxyContext       string
+	proxyNamespace     string
+	proxyClusterDomain string
+)
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Run a SOCKS5 proxy that tunnels connections to cluster services on demand",
+	Long: "Run a SOCKS5 proxy that tunnels connections to cluster services on demand.\n" +
+		"Destinations are service names such as postgresql.db, postgresql.db.svc or\n" +
+		"postgresql.db.svc.cluster.local; bare names use --namespace. A forward to one of the\n" +
+		"service's pods is opened on the first connection and reused afterwards.",
+	Args: cobra.NoArgs,
+	RunE: runProxy,
+}
+
+func init() {
+	proxyCmd.Flags().StringVar(&socksAddr, "socks5", "127.0.0.1:1080", "address to accept SOCKS5 clients on")
+	proxyCmd.Flags().StringVar(&proxyContext, "context", "", "kube context to tunnel into (defaults to the current context)")
+	proxyCmd.Flags().StringVarP(&proxyNamespace, "namespace", "n", "default", "namespace of destinations without one")
+	proxyCmd.Flags().StringVar(&proxyClusterDomain, "cluster-domain", "cluster.local", "cluster DNS domain stripped from destinations")
+	_ = proxyCmd.RegisterFlagCompletionFunc("context", completeContextNames)
+	rootCmd.AddCommand(proxyCmd)
+}
+
+func runProxy(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if contexts, err := config.Read(configPath); err == nil {
+		kube.SetJumpHosts(contexts)
+		kube.SetAuth(contexts)
+		kube.SetRequestTimeout(time.Duration(contexts.RequestTimeout))
+		defer kube.CloseJumpHosts()
+	}
+
+	tunnels := kube.NewTunnels(proxyContext)
+	defer tunnels.Close()
+
+	server := socks.NewServer(func(ctx context.Context, host string, port int) (net.Conn, error) {
+		service, namespace, err := splitServiceHost(host)
+		if err != nil {
+			return nil, err
+		}
+		return tunnels.Dial(ctx, namespace, service, port)
+	})
+
+	fmt.Printf("SOCKS5 proxy listening on %s\n", socksAddr)
+	return server.ListenAndServe(ctx, socksAddr)
+}
+
+// splitServiceHost maps a destination host name to a service and namespace.
+func splitServiceHost(host string) (service, namespace string, err error) {
+	if net.ParseIP(host) != nil {
+		return "", "", fmt.Errorf("cannot tunnel to IP address %s, use a service name", host)
+	}
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	name = strings.TrimSuffix(name, "."+strings.Trim(proxyClusterDomain, "."))
+	name = strings.TrimSuffix(name, ".svc")
+
+	labels := strings.Split(name, ".")
+	switch len(labels) {
+	case 1:
+		return labels[0], proxyNamespace, nil
+	case 2:
+		return labels[0], labels[1], nil
+	default:
+		return "", "", fmt.Errorf("%s is not a service name", host)
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+)
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Have the running kpfm instance read the kubeconfig files of this shell",
+	Long: "Have the running kpfm instance switch to the kubeconfig files of this shell's KUBECONFIG\n" +
+		"(~/.kube/config when unset) and follow the current context they set, for tools that\n" +
+		"swap kubeconfig files rather than editing current-context. Sending the instance a\n" +
+		"SIGHUP reads its own files again instead.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// The instance runs elsewhere, so relative paths are made absolute here.
+		var paths []string
+		for _, path := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+			if path == "" {
+				continue
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, abs)
+		}
+		kubeconfig := strings.Join(paths, string(filepath.ListSeparator))
+		if err := control.NewClient(config.SocketPath(configPath)).Reload(kubeconfig); err != nil {
+			return err
+		}
+		if kubeconfig == "" {
+			kubeconfig = "~/.kube/config"
+		}
+		fmt.Printf("Reloading the kubeconfig from %s\n", kubeconfig)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reloadCmd)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/endpoints"
+)
+
+var (
+	renderTemplate string
+	renderActive   bool
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render --template file.tmpl",
+	Short: "Render a Go template over the forwards of the running kpfm instance",
+	Long: "Render a Go template over the forwards of the running kpfm instance, e.g. to generate\n" +
+		"haproxy configs, hosts file snippets or documentation. The template sees .Context and\n" +
+		".Forwards, each with the Name, Context, Connection, State, Pod, LastError and Stats\n" +
+		"that `kpfm status` shows, and can use .Forward \"name\", varName and addr.",
+	Args: cobra.NoArgs,
+	RunE: runRender,
+}
+
+func init() {
+	renderCmd.Flags().StringVarP(&renderTemplate, "template", "t", "", "template file to render, - for stdin")
+	renderCmd.Flags().BoolVar(&renderActive, "active", false, "only pass the forwards that are ready or idle")
+	_ = renderCmd.MarkFlagRequired("template")
+	rootCmd.AddCommand(renderCmd)
+}
+
+func runRender(cmd *cobra.Command, args []string) error {
+	var text []byte
+	var err error
+	if renderTemplate == "-" {
+		text, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		text, err = ioutil.ReadFile(renderTemplate)
+	}
+	if err != nil {
+		return err
+	}
+	tmpl, err := endpoints.ParseTemplate(filepath.Base(renderTemplate), string(text))
+	if err != nil {
+		return err
+	}
+
+	status, err := control.NewClient(config.SocketPath(configPath)).Status()
+	if err != nil {
+		return err
+	}
+	forwards := status.Forwards
+	if renderActive {
+		forwards = endpoints.Active(forwards)
+	}
+	out, err := endpoints.Execute(tmpl, endpoints.Data{Context: status.Context, Forwards: forwards})
+	if err != nil {
+		return fmt.Errorf("error rendering %s: %v", renderTemplate, err)
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+)
+
+var retryCmd = &cobra.Command{
+	Use:               "retry <connection>",
+	Short:             "Re-arm a broken connection of the running kpfm instance",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeConnectionNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := control.NewClient(config.SocketPath(configPath)).Retry(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Restarting %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(retryCmd)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/logging"
+)
+
+var (
+	configPath string
+	noColor    bool
+	quiet      bool
+	verbosity  int
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "kpfm",
+	Short: "Kubernetes Port-Forward Manager",
+	Long:  "kpfm manages multiple port-forwards to Kubernetes environments, following the current kube context.",
+	// Running kpfm without a subcommand keeps the original behaviour of starting every forward.
+	RunE:          runStart,
+	SilenceUsage:  true,
+	SilenceErrors: false,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		switch {
+		case quiet:
+			logging.SetLevel(logging.Quiet)
+		case verbosity > 0:
+			logging.SetLevel(logging.Level(verbosity))
+		}
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", config.Path(), "path to the kpfm config file")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "disable colored output (also set by NO_COLOR)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only print failures and warnings")
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "print pod resolution, transport and retry details (-vv adds the forwarders' per-connection output)")
+	addStartFlags(rootCmd)
+}
+
+// ExitError ends kpfm with Code once the deferred cleanups of the command have run, e.g.
+// to pass on the exit code of the command kpfm run ran. Commands returning it silence
+// their errors.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Execute runs the root command.
+func Execute() error {
+	return rootCmd.Execute()
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/endpoints"
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/manager"
+	"github.com/rparaujo/kpfm/pkg/ports"
+)
+
+var runTimeout time.Duration
+
+var runCmd = &cobra.Command{
+	Use:   "run [connection...] -- command [args...]",
+	Short: "Run a command against freshly started forwards and tear them down when it exits",
+	Long: "Start the configured forwards (or only the named connections), wait until they are ready,\n" +
+		"run the command with KPFM_<NAME>_HOST, KPFM_<NAME>_PORT and KPFM_<NAME>_ADDR set for every\n" +
+		"forward, and stop the forwards once it exits. kpfm exits with the command's exit code.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.ArgsLenAtDash() < 0 || cmd.ArgsLenAtDash() == len(args) {
+			return errors.New("missing command, pass it after --")
+		}
+		return nil
+	},
+	RunE:              runRun,
+	ValidArgsFunction: completeConnectionNames,
+}
+
+func init() {
+	runCmd.Flags().StringSliceVar(&startTags, "tags", nil, "only start connections carrying at least one of these tags")
+	addSelectorFlag(runCmd, &startSelector, "only start connections")
+	runCmd.Flags().StringVar(&startContext, "context", "", "forward the connections of this kube context instead of the current context")
+	runCmd.Flags().DurationVar(&runTimeout, "timeout", time.Minute, "how long to wait for the forwards before giving up")
+	_ = runCmd.RegisterFlagCompletionFunc("tags", completeTags)
+	_ = runCmd.RegisterFlagCompletionFunc("context", completeContextNames)
+	rootCmd.AddCommand(runCmd)
+}
+
+func runRun(cmd *cobra.Command, args []string) error {
+	dash := cmd.ArgsLenAtDash()
+	startNames = args[:dash]
+	command := args[dash:]
+	if err := parseStartSelector(); err != nil {
+		return err
+	}
+
+	contexts, err := config.Read(configPath)
+	if err != nil {
+		return fmt.Errorf("error reading YAML file: %v", err)
+	}
+	if err := ports.Assign(contexts, config.PortsPath()); err != nil {
+		return fmt.Errorf("error assigning local ports: %v", err)
+	}
+	kube.SetJumpHosts(contexts)
+	kube.SetAuth(contexts)
+	kube.SetRequestTimeout(time.Duration(contexts.RequestTimeout))
+	defer kube.CloseJumpHosts()
+	if err := setLogFilter(contexts.LogFilter); err != nil {
+		return err
+	}
+
+	currentContext := startContext
+	if currentContext == "" {
+		currentContext, err = kube.GetCurrentContext()
+		if err != nil {
+			return fmt.Errorf("error getting current context: %v", err)
+		}
+	}
+	if err := contexts.CheckLocalPorts(currentContext, wanted); err != nil {
+		return err
+	}
+	if err := contexts.CheckNames(currentContext, wanted); err != nil {
+		return err
+	}
+	if err := contexts.CheckDependencies(currentContext); err != nil {
+		return err
+	}
+
+	// Signals abort the wait for readiness; once the command runs it gets the terminal's
+	// signals itself and kpfm only tears down after it exits.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// The command owns the terminal, so a context switch is never asked about there.
+	opts, err := contextSwitchOptions(contexts.ContextSwitch, false)
+	if err != nil {
+		return err
+	}
+	opts.Context = startContext
+	opts.Filter = wanted
+	m := manager.New(contexts, opts)
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+	if err := m.Start(cmd.Context()); err != nil {
+		return fmt.Errorf("error starting port-forwards: %v", err)
+	}
+	defer m.Stop()
+
+	if err := waitReady(m, events, signals, runTimeout); err != nil {
+		return err
+	}
+
+	child := exec.Command(command[0], command[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.Env = append(os.Environ(), endpoints.Vars(m.Status())...)
+	if err := child.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for sig := range signals {
+			// Interrupts from the terminal already reached the command's process group.
+			if sig == syscall.SIGTERM {
+				// Windows cannot deliver signals to other processes, only kill them.
+				if err := child.Process.Signal(sig); err != nil {
+					child.Process.Kill()
+				}
+			}
+		}
+	}()
+	err = child.Wait()
+
+	m.Stop()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Exits once the deferred cleanups, like closing the jump hosts, have run.
+		cmd.SilenceErrors = true
+		return &ExitError{Code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// waitReady blocks until every forward of m is ready, one of them is broken, a signal
+// arrives, or timeout expires.
+func waitReady(m *manager.Manager, events <-chan manager.Event, signals <-chan os.Signal, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		pending := 0
+		for _, f := range m.Status() {
+			switch f.State {
+			case manager.StateReady, manager.StateIdle:
+			case manager.StateBroken:
+				return fmt.Errorf("port-forward for %s is broken: %s", f.Connection.Target(), f.LastError)
+			default:
+				pending++
+			}
+		}
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return errors.New("port-forwards stopped")
+			}
+			if event.Type == manager.EventFailed {
+				log.Printf("Port-forward for %s failed, retrying: %v", event.Name, event.Err)
+			}
+		case sig := <-signals:
+			return fmt.Errorf("interrupted by %s", sig)
+		case <-deadline:
+			return fmt.Errorf("%d port-forward(s) not ready after %s", pending, timeout)
+		}
+	}
+}
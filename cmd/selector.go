@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// startSelector is the --selector of the commands starting forwards, and startLabels
+// its parsed form used by wanted.
+var (
+	startSelector string
+	startLabels   = labels.Everything()
+)
+
+// addSelectorFlag adds the -l/--selector flag matching connections by their Labels to
+// cmd, storing it in selector. what completes its help, e.g. "only start connections".
+func addSelectorFlag(cmd *cobra.Command, selector *string, what string) {
+	cmd.Flags().StringVarP(selector, "selector", "l", "", what+" whose Labels match this selector, e.g. team=payments,tier!=db")
+}
+
+// parseSelector parses a --selector in the kubectl syntax; an empty one matches every
+// connection.
+func parseSelector(selector string) (labels.Selector, error) {
+	s, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+	}
+	return s, nil
+}
+
+// parseStartSelector parses startSelector into startLabels.
+func parseStartSelector() error {
+	s, err := parseSelector(startSelector)
+	if err != nil {
+		return err
+	}
+	startLabels = s
+	return nil
+}
+
+// selectForwards returns the forwards whose connection Labels match selector.
+func selectForwards(forwards []manager.ForwardStatus, selector labels.Selector) []manager.ForwardStatus {
+	selected := forwards[:0:0]
+	for _, f := range forwards {
+		if selector.Matches(labels.Set(f.Connection.Labels)) {
+			selected = append(selected, f)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/service"
+)
+
+var serviceNoStart bool
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Run kpfm as a background service at login (systemd or launchd)",
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install and enable the kpfm user service",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		svc, err := service.ForPlatform()
+		if err != nil {
+			return err
+		}
+		absConfig, err := filepath.Abs(configPath)
+		if err != nil {
+			return err
+		}
+		spec, err := service.DefaultSpec(absConfig)
+		if err != nil {
+			return err
+		}
+		path, err := svc.Install(spec)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s\n", path)
+		if serviceNoStart {
+			return nil
+		}
+		return svc.Start()
+	},
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop and remove the kpfm user service",
+	Args:  cobra.NoArgs,
+	RunE:  serviceAction(service.Manager.Uninstall),
+}
+
+var serviceStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the kpfm user service",
+	Args:  cobra.NoArgs,
+	RunE:  serviceAction(service.Manager.Start),
+}
+
+var serviceStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the kpfm user service",
+	Args:  cobra.NoArgs,
+	RunE:  serviceAction(service.Manager.Stop),
+}
+
+var serviceStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the kpfm user service",
+	Args:  cobra.NoArgs,
+	RunE:  serviceAction(service.Manager.Status),
+}
+
+func init() {
+	serviceInstallCmd.Flags().BoolVar(&serviceNoStart, "no-start", false, "install without starting the service")
+	serviceCmd.AddCommand(serviceInstallCmd, serviceUninstallCmd, serviceStartCmd, serviceStopCmd, serviceStatusCmd)
+	rootCmd.AddCommand(serviceCmd)
+}
+
+// serviceAction runs action against the platform's service manager.
+func serviceAction(action func(service.Manager) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		svc, err := service.ForPlatform()
+		if err != nil {
+			return err
+		}
+		return action(svc)
+	}
+}
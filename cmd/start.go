@@ -0,0 +1,570 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/console"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/dns"
+	"github.com/rparaujo/kpfm/pkg/endpoints"
+	"github.com/rparaujo/kpfm/pkg/eventlog"
+	"github.com/rparaujo/kpfm/pkg/instance"
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/manager"
+	"github.com/rparaujo/kpfm/pkg/model"
+	"github.com/rparaujo/kpfm/pkg/notify"
+	"github.com/rparaujo/kpfm/pkg/ports"
+	"github.com/rparaujo/kpfm/pkg/router"
+	"github.com/rparaujo/kpfm/pkg/webhook"
+)
+
+var (
+	startTags    []string
+	startNames   []string
+	startContext string
+	dryRun       bool
+	notifyFlag   bool
+	takeover     bool
+	waitFlag     bool
+	waitTimeout  time.Duration
+
+	checkInterval   time.Duration
+	watchKubeconfig bool
+)
+
+var startCmd = &cobra.Command{
+	Use:   "start [connection...]",
+	Short: "Start the port-forwards configured for the current kube context",
+	Long: "Start the port-forwards configured for the current kube context.\n" +
+		"Passing connection names (service or pod names) only starts those connections.",
+	RunE:              runStart,
+	ValidArgsFunction: completeConnectionNames,
+}
+
+func init() {
+	addStartFlags(startCmd)
+	rootCmd.AddCommand(startCmd)
+}
+
+func addStartFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&startTags, "tags", nil, "only start connections carrying at least one of these tags")
+	addSelectorFlag(cmd, &startSelector, "only start connections")
+	cmd.Flags().StringVar(&startContext, "context", "", "forward the connections of this kube context instead of following the current context")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the forwards that would be created without opening any tunnels")
+	cmd.Flags().BoolVar(&takeover, "takeover", false, "stop an instance already running with this config and take its place")
+	cmd.Flags().BoolVar(&waitFlag, "wait", false, "start in the background and return once every forward is ready")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", time.Minute, "how long --wait waits for the forwards")
+	cmd.Flags().BoolVar(&notifyFlag, "notify", false, "show desktop notifications when forwards fail or recover (overrides DesktopNotifications)")
+	cmd.Flags().DurationVar(&checkInterval, "check-interval", 0, "how often the current kube context is polled (overrides ContextSwitch.CheckInterval, default 10s)")
+	cmd.Flags().BoolVar(&watchKubeconfig, "watch-kubeconfig", false, "follow context changes as soon as the kubeconfig files are written (overrides ContextSwitch.Watch)")
+	_ = cmd.RegisterFlagCompletionFunc("tags", completeTags)
+	_ = cmd.RegisterFlagCompletionFunc("context", completeContextNames)
+}
+
+// wanted reports whether a connection passes the start filters given on the command line.
+func wanted(connection model.Connection) bool {
+	if !connection.HasAnyTag(startTags) || !startLabels.Matches(labels.Set(connection.Labels)) {
+		return false
+	}
+	if len(startNames) == 0 {
+		return true
+	}
+	for _, name := range startNames {
+		if connection.Answers(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func runStart(cmd *cobra.Command, args []string) error {
+	var err error
+	// A dry run changes nothing, not even by creating the config or recording ports.
+	if !dryRun {
+		if err = config.EnsureFile(); err != nil {
+			return fmt.Errorf("error creating config file: %v", err)
+		}
+	}
+
+	startNames = args
+	if err := parseStartSelector(); err != nil {
+		return err
+	}
+
+	contexts, err := config.Read(configPath)
+	if err != nil {
+		return fmt.Errorf("error reading YAML file: %v", err)
+	}
+	assignPorts := ports.Assign
+	if dryRun {
+		assignPorts = ports.Plan
+	}
+	if err := assignPorts(contexts, config.PortsPath()); err != nil {
+		return fmt.Errorf("error assigning local ports: %v", err)
+	}
+
+	kube.SetJumpHosts(contexts)
+	kube.SetAuth(contexts)
+	kube.SetRequestTimeout(time.Duration(contexts.RequestTimeout))
+	defer kube.CloseJumpHosts()
+	if err := setLogFilter(contexts.LogFilter); err != nil {
+		return err
+	}
+
+	currentContext := startContext
+	if currentContext == "" {
+		currentContext, err = kube.GetCurrentContext()
+		if err != nil {
+			return fmt.Errorf("error getting current context: %v", err)
+		}
+	}
+	if err := contexts.CheckLocalPorts(currentContext, wanted); err != nil {
+		return err
+	}
+	if err := contexts.CheckNames(currentContext, wanted); err != nil {
+		return err
+	}
+	if err := contexts.CheckDependencies(currentContext); err != nil {
+		return err
+	}
+
+	if waitFlag && !dryRun {
+		return runStartWait(cmd, args)
+	}
+
+	if dryRun {
+		return runDryRun(cmd.Context(), currentContext, contexts)
+	}
+
+	lockPath := config.LockPath(configPath)
+	var lock *instance.Lock
+	if takeover {
+		lock, err = instance.Takeover(lockPath, 10*time.Second)
+	} else {
+		lock, err = instance.Acquire(lockPath)
+	}
+	if err != nil {
+		var locked *instance.LockedError
+		if errors.As(err, &locked) {
+			return fmt.Errorf("%v; use --takeover to replace it", err)
+		}
+		return err
+	}
+	defer lock.Release()
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts, err := contextSwitchOptions(contexts.ContextSwitch, true)
+	if err != nil {
+		return err
+	}
+	opts.Context = startContext
+	opts.Filter = wanted
+	m := manager.New(contexts, opts)
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	eventLog, err := openEventLog(contexts.EventLog)
+	if err != nil {
+		log.Printf("Event log unavailable: %v", err)
+	}
+	// Subscribed before the start so the log misses nothing, and drained before exiting.
+	eventLogDone := make(chan struct{})
+	if eventLog != nil {
+		logged, unsubscribeLog := m.Subscribe()
+		defer unsubscribeLog()
+		go func() {
+			defer close(eventLogDone)
+			eventLog.Record(logged)
+		}()
+	} else {
+		close(eventLogDone)
+	}
+
+	stateDone := make(chan struct{})
+	if path, err := stateFilePath(contexts.StateFile); err != nil {
+		log.Printf("State file unavailable: %v", err)
+		close(stateDone)
+	} else if path == "" {
+		close(stateDone)
+	} else {
+		go func() {
+			defer close(stateDone)
+			if err := endpoints.WriteState(ctx, m, path); err != nil {
+				log.Printf("State file unavailable: %v", err)
+			}
+		}()
+	}
+
+	if len(contexts.Webhooks) > 0 {
+		notifier, err := webhook.New(contexts.Webhooks)
+		if err != nil {
+			return err
+		}
+		notified, unsubscribeWebhooks := m.Subscribe()
+		defer unsubscribeWebhooks()
+		go notifier.Run(ctx, notified)
+	}
+
+	if err := m.Start(ctx); err != nil {
+		return fmt.Errorf("error starting port-forwards: %v", err)
+	}
+
+	// SIGHUP reads the kubeconfig files again, e.g. once a file that was missing exists.
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	go func() {
+		for {
+			select {
+			case <-hangups:
+				m.ReloadKubeconfig(nil)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	controlServer := control.NewServer(m)
+	controlDone := make(chan struct{})
+	go func() {
+		defer close(controlDone)
+		if err := controlServer.ListenAndServe(ctx, config.SocketPath(configPath)); err != nil {
+			log.Printf("Control API unavailable: %v", err)
+		}
+	}()
+
+	if contexts.GRPC != nil {
+		go func() {
+			if err := serveGRPC(ctx, controlServer, contexts.GRPC); err != nil {
+				log.Printf("gRPC API unavailable: %v", err)
+			}
+		}()
+	}
+
+	if contexts.REST != nil {
+		go func() {
+			if err := serveREST(ctx, controlServer, contexts.REST); err != nil {
+				log.Printf("REST API unavailable: %v", err)
+			}
+		}()
+	}
+
+	if contexts.Dashboard != nil {
+		go func() {
+			if err := controlServer.ListenAndServeDashboard(ctx, contexts.Dashboard.Listen); err != nil {
+				log.Printf("Dashboard unavailable: %v", err)
+			}
+		}()
+	}
+
+	if contexts.DNS != nil {
+		go func() {
+			stub := dns.NewServer(contexts.DNS.ClusterDomain, forwardedService(m))
+			if err := stub.ListenAndServe(ctx, contexts.DNS.Listen); err != nil {
+				log.Printf("DNS stub unavailable: %v", err)
+			}
+		}()
+	}
+
+	if contexts.HTTPRouter != nil {
+		go func() {
+			r := router.New(contexts.HTTPRouter.Domain, forwardPort(m))
+			if err := r.ListenAndServe(ctx, contexts.HTTPRouter.Listen); err != nil {
+				log.Printf("HTTP router unavailable: %v", err)
+			}
+		}()
+	}
+
+	if contexts.EnvFile != nil {
+		go func() {
+			if err := endpoints.WriteFile(ctx, m, contexts.EnvFile.Path, contexts.EnvFile.Template); err != nil {
+				log.Printf("Env file unavailable: %v", err)
+			}
+		}()
+	}
+
+	logEvents(events, notifyFlag || contexts.DesktopNotifications)
+	<-eventLogDone
+	<-stateDone
+	// Release the lock only once the control socket is gone, so a takeover can't race it.
+	<-controlDone
+	return nil
+}
+
+// openEventLog opens the event log configured by settings, which may be nil for the
+// defaults. It returns nil if the log is disabled.
+func openEventLog(settings *model.EventLog) (*eventlog.Log, error) {
+	if settings == nil {
+		settings = &model.EventLog{}
+		settings.ApplyDefaults()
+	}
+	if settings.Disabled {
+		return nil, nil
+	}
+	path := settings.Path
+	if path == "" {
+		path = config.EventLogPath()
+	}
+	path, err := config.ExpandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	return eventlog.Open(path, int64(settings.MaxSizeMB)<<20, settings.MaxFiles)
+}
+
+// stateFilePath returns where the state file configured by settings, which may be nil
+// for the defaults, is kept, or "" if it is disabled.
+func stateFilePath(settings *model.StateFile) (string, error) {
+	if settings == nil {
+		return config.StateFilePath(), nil
+	}
+	if settings.Disabled {
+		return "", nil
+	}
+	if settings.Path == "" {
+		return config.StateFilePath(), nil
+	}
+	return config.ExpandHome(settings.Path)
+}
+
+// setLogFilter applies the forwarder output filter configured by settings, which may be
+// nil for the defaults.
+func setLogFilter(settings *model.LogFilter) error {
+	if settings == nil {
+		return nil
+	}
+	patterns := make([]*regexp.Regexp, 0, len(settings.Patterns))
+	for _, pattern := range settings.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid LogFilter pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	showFrom := logging.Never
+	switch settings.ShowFrom {
+	case model.LogLevelNormal:
+		showFrom = logging.Normal
+	case model.LogLevelVerbose:
+		showFrom = logging.Verbose
+	case model.LogLevelDebug:
+		showFrom = logging.Debug
+	}
+	logging.SetFilter(!settings.KeepHandlingConnection, patterns, showFrom)
+	return nil
+}
+
+// serveREST serves the REST API, generating a token into config.RESTTokenPath when the
+// config doesn't set one.
+func serveREST(ctx context.Context, server *control.Server, settings *model.REST) error {
+	addr := settings.Listen
+	if addr == "" {
+		addr = control.DefaultRESTListen
+	}
+	token := settings.Token
+	if token == "" {
+		var err error
+		if token, err = writeToken(config.RESTTokenPath(configPath)); err != nil {
+			return err
+		}
+		defer os.Remove(config.RESTTokenPath(configPath))
+		log.Printf("REST API on http://%s, token in %s", addr, config.RESTTokenPath(configPath))
+	} else {
+		log.Printf("REST API on http://%s", addr)
+	}
+	return server.ListenAndServeREST(ctx, addr, token)
+}
+
+// serveGRPC serves the gRPC API. On a unix socket it relies on the socket's permissions;
+// on a loopback port it requires a token, generated into config.GRPCTokenPath when the
+// config doesn't set one.
+func serveGRPC(ctx context.Context, server *control.Server, settings *model.GRPC) error {
+	listen := settings.Listen
+	if listen == "" {
+		listen = "unix://" + config.GRPCSocketPath(configPath)
+	}
+	token := settings.Token
+	if token == "" && !strings.HasPrefix(listen, "unix://") {
+		var err error
+		if token, err = writeToken(config.GRPCTokenPath(configPath)); err != nil {
+			return err
+		}
+		defer os.Remove(config.GRPCTokenPath(configPath))
+		log.Printf("gRPC API on %s, token in %s", listen, config.GRPCTokenPath(configPath))
+	}
+	return server.ListenAndServeGRPC(ctx, listen, token)
+}
+
+// writeToken generates an API token and writes it to path, readable only by the user.
+func writeToken(path string) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.MkdirAll(config.RuntimeDir(), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// forwardedService reports whether m runs a forward for a service, for the DNS stub.
+func forwardedService(m *manager.Manager) dns.Lookup {
+	return func(service, namespace string) bool {
+		for _, status := range m.Status() {
+			connection := status.Connection
+			if strings.EqualFold(connection.ServiceName, service) && strings.EqualFold(connection.Namespace, namespace) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// forwardPort looks up the local port of a TCP forward by its name, service or pod name,
+// for the HTTP router.
+func forwardPort(m *manager.Manager) router.Lookup {
+	return func(name string) (int, bool) {
+		for _, status := range m.Status() {
+			connection := status.Connection
+			if connection.IsUDP() {
+				continue
+			}
+			if strings.EqualFold(status.Name, name) || strings.EqualFold(connection.ServiceName, name) || strings.EqualFold(connection.PodName, name) {
+				return connection.LocalPort, true
+			}
+		}
+		return 0, false
+	}
+}
+
+// logEvents prints a line per manager event until the manager stops, optionally raising
+// desktop notifications when forwards fail, recover, or come up on a new context.
+func logEvents(events <-chan manager.Event, notifyEnabled bool) {
+	out := console.New(os.Stdout, noColor)
+	// Track failing forwards and those already announced after a context switch,
+	// so each transition is notified once
+	failing := make(map[string]bool)
+	var announced map[string]bool
+
+	for event := range events {
+		if logging.Enabled(logging.Normal) || event.Type == manager.EventFailed || event.Type == manager.EventBroken {
+			out.Event(event)
+		}
+		switch event.Type {
+		case manager.EventContextChanged:
+			announced = make(map[string]bool)
+
+		case manager.EventReady:
+			if notifyEnabled && failing[event.Name] {
+				desktopNotify("Port-forward recovered", fmt.Sprintf("%s is forwarding again", event.Name))
+			} else if notifyEnabled && announced != nil && !announced[event.Name] {
+				desktopNotify("Port-forward ready", fmt.Sprintf("%s is forwarding on context %s", event.Name, event.Context))
+			}
+			delete(failing, event.Name)
+			if announced != nil {
+				announced[event.Name] = true
+			}
+
+		case manager.EventFailed:
+			if notifyEnabled && !failing[event.Name] {
+				desktopNotify("Port-forward failed", fmt.Sprintf("%s: %v", event.Name, event.Err))
+			}
+			failing[event.Name] = true
+		}
+	}
+}
+
+// desktopNotify shows a desktop notification, logging instead of failing when none can be shown.
+func desktopNotify(title, message string) {
+	if err := notify.Send(title, message); err != nil {
+		log.Printf("Cannot show desktop notification: %v", err)
+	}
+}
+
+// runDryRun prints the forwards that would be created for the given context.
+func runDryRun(ctx context.Context, kubeContext string, contexts *model.Contexts) error {
+	// Connections are looked up on the context they dial, as the manager does, which for
+	// pinned ones of other contexts is that context.
+	clientsets := make(map[string]kubernetes.Interface)
+	clientFor := func(connection model.Connection) (kubernetes.Interface, error) {
+		name := connection.KubeContext(kubeContext)
+		if cs, ok := clientsets[name]; ok {
+			return cs, nil
+		}
+		_, cs, err := kube.NewClientset(name)
+		if err != nil {
+			return nil, err
+		}
+		clientsets[name] = cs
+		return cs, nil
+	}
+
+	failed := 0
+	var connections []model.Connection
+	for _, connection := range contexts.ActiveConnections(kubeContext) {
+		if !wanted(connection) {
+			continue
+		}
+		if !connection.AllServices {
+			connections = append(connections, connection)
+			continue
+		}
+		cs, err := clientFor(connection)
+		if err != nil {
+			fmt.Printf("%s %s: context %s: %v\n", connection.Namespace, connection.Target(), connection.KubeContext(kubeContext), err)
+			failed++
+			continue
+		}
+		expanded, err := kube.ExpandAllServices(ctx, cs, connection)
+		if err != nil {
+			fmt.Printf("%s %s: cannot list services: %v\n", connection.Namespace, connection.Target(), err)
+			failed++
+			continue
+		}
+		connections = append(connections, expanded...)
+	}
+	for _, connection := range connections {
+		cs, err := clientFor(connection)
+		if err != nil {
+			fmt.Printf("%s %s: context %s: %v\n", connection.Namespace, connection.Target(), connection.KubeContext(kubeContext), err)
+			failed++
+			continue
+		}
+		plan := kube.PlanPortForward(ctx, cs, connection)
+		fmt.Printf("%s %s: pod=%s ports=%s address=%s\n", connection.Namespace, connection.Target(), plan.PodName, plan.Ports, plan.Address)
+		for _, problem := range plan.Problems {
+			fmt.Printf("  ! %s\n", problem)
+		}
+		if len(plan.Problems) > 0 {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d connection(s) would fail to start", failed)
+	}
+	return nil
+}
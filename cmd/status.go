@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/manager"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+var statusSelector string
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the forwards of the running kpfm instance",
+	Args:  cobra.NoArgs,
+	RunE:  runStatus,
+}
+
+func init() {
+	addSelectorFlag(statusCmd, &statusSelector, "only show forwards")
+	rootCmd.AddCommand(statusCmd)
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	selector, err := parseSelector(statusSelector)
+	if err != nil {
+		return err
+	}
+	status, err := control.NewClient(config.SocketPath(configPath)).Status()
+	if err != nil {
+		return err
+	}
+	status.Forwards = selectForwards(status.Forwards, selector)
+
+	fmt.Printf("Context: %s\n", status.Context)
+	for _, f := range status.Forwards {
+		if f.State == manager.StateBroken {
+			fmt.Printf("! %s is broken after %d consecutive failures, run `kpfm retry %s` once fixed\n",
+				f.Connection.Target(), f.Failures, f.Name)
+		}
+		if r := f.Reachability; r != nil && !r.Reachable {
+			fmt.Printf("! %s is up but nothing answers on its local port: %s\n", f.Name, r.Error)
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTARGET\tNAMESPACE\tLOCAL\tSTATE\tREACHABLE\tSINCE\tRESTARTS\tIN\tOUT\tACTIVE\tCONNS\tLABELS\tLAST ERROR")
+	for _, f := range status.Forwards {
+		in, out, active, conns := "-", "-", "-", "-"
+		if f.Stats != nil {
+			in = formatBytes(f.Stats.BytesIn)
+			out = formatBytes(f.Stats.BytesOut)
+			active = fmt.Sprint(f.Stats.ActiveConnections)
+			conns = fmt.Sprint(f.Stats.TotalConnections)
+		}
+		lastErr := f.LastError
+		switch {
+		case lastErr == "" && f.LastFailure != "":
+			// Recovered since; show what broke it last and when.
+			lastErr = fmt.Sprintf("%s (%s ago)", f.LastFailure, time.Since(f.LastFailureAt).Round(time.Second))
+		case lastErr == "":
+			lastErr = "-"
+		}
+		reachable := "-"
+		if r := f.Reachability; r != nil {
+			reachable = "no"
+			if r.Reachable {
+				reachable = "yes"
+			}
+			reachable += fmt.Sprintf(" (%s ago)", time.Since(r.CheckedAt).Round(time.Second))
+		}
+		name := f.Name
+		if f.Context != status.Context {
+			// A pinned forward of another context.
+			name += " @" + f.Context
+		}
+		target := f.Connection.Target()
+		if f.Connection.Context != "" {
+			target += " @" + f.Connection.Context
+		}
+		local := strconv.Itoa(f.Connection.LocalPort)
+		if f.Connection.LocalSocket != "" {
+			local = f.Connection.LocalSocket
+		}
+		labels := model.FormatLabels(f.Connection.Labels)
+		if labels == "" {
+			labels = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name, target, f.Connection.Namespace, local, f.State, reachable,
+			time.Since(f.Since).Round(time.Second), f.Restarts, in, out, active, conns, labels, lastErr)
+	}
+	return w.Flush()
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/kube"
+)
+
+var switchCmd = &cobra.Command{
+	Use:   "switch <context>",
+	Short: "Make a kube context current and move the running forwards to it right away",
+	Long: "Set the current-context of the kubeconfig, as kubectl config use-context does, and have\n" +
+		"the running kpfm instance replace the forwards of the previous context with those of the\n" +
+		"new one right away instead of when it notices the change, printing what it stopped and\n" +
+		"started. ContextSwitch.Confirm isn't asked: running the command is the confirmation.",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeContextNames,
+	RunE:              runSwitch,
+}
+
+func init() {
+	rootCmd.AddCommand(switchCmd)
+}
+
+func runSwitch(cmd *cobra.Command, args []string) error {
+	kubeContext := args[0]
+	if err := kube.SetCurrentContext(kubeContext); err != nil {
+		return err
+	}
+	fmt.Printf("Switched to context %s\n", kubeContext)
+
+	if _, err := os.Stat(config.SocketPath(configPath)); os.IsNotExist(err) {
+		fmt.Println("No kpfm instance is running")
+		return nil
+	}
+	result, err := control.NewClient(config.SocketPath(configPath)).Switch(kubeContext)
+	if err != nil {
+		return err
+	}
+	if result.Stopped == nil && result.Started == nil {
+		fmt.Printf("The forwards already run on %s\n", kubeContext)
+		return nil
+	}
+	printForwardList("Stopped", result.Stopped)
+	printForwardList("Started", result.Started)
+	return nil
+}
+
+// printForwardList prints the names of the forwards a switch stopped or started.
+func printForwardList(what string, names []string) {
+	if len(names) == 0 {
+		fmt.Printf("%s: none\n", what)
+		return
+	}
+	fmt.Printf("%s: %s\n", what, strings.Join(names, ", "))
+}
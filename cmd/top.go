@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// Orders of the rows of kpfm top.
+const (
+	topSortRate  = "rate"
+	topSortConns = "conns"
+	topSortTotal = "total"
+	topSortName  = "name"
+)
+
+var (
+	topInterval time.Duration
+	topSort     string
+	topOnce     bool
+	topSelector string
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show the live traffic of the forwards of the running kpfm instance",
+	Long: "Show the throughput and connections of each forward of the running kpfm instance,\n" +
+		"refreshed in place, busiest first. It needs TrafficStats: true in the config;\n" +
+		"forwards without a counting proxy are listed at the bottom with no figures.",
+	Args: cobra.NoArgs,
+	RunE: runTop,
+}
+
+func init() {
+	topCmd.Flags().DurationVarP(&topInterval, "interval", "d", 2*time.Second, "time between refreshes")
+	topCmd.Flags().StringVarP(&topSort, "sort", "s", topSortRate,
+		"order of the rows: rate (bytes per second in and out), conns (active connections), total (bytes since start) or name")
+	topCmd.Flags().BoolVar(&topOnce, "once", false, "print the rates over one interval and exit instead of refreshing")
+	addSelectorFlag(topCmd, &topSelector, "only show forwards")
+	rootCmd.AddCommand(topCmd)
+}
+
+// topRow is a forward with its traffic over the last interval.
+type topRow struct {
+	forward manager.ForwardStatus
+	inRate  float64 // bytes per second sent by local clients
+	outRate float64 // bytes per second returned to local clients
+}
+
+func runTop(cmd *cobra.Command, args []string) error {
+	switch topSort {
+	case topSortRate, topSortConns, topSortTotal, topSortName:
+	default:
+		return fmt.Errorf("invalid --sort %q: must be one of %s, %s, %s or %s",
+			topSort, topSortRate, topSortConns, topSortTotal, topSortName)
+	}
+	if topInterval <= 0 {
+		return fmt.Errorf("invalid --interval %s: must be positive", topInterval)
+	}
+	selector, err := parseSelector(topSelector)
+	if err != nil {
+		return err
+	}
+
+	client := control.NewClient(config.SocketPath(configPath))
+	previous, err := client.Status()
+	if err != nil {
+		return err
+	}
+	last := time.Now()
+	for {
+		time.Sleep(topInterval)
+		status, err := client.Status()
+		if err != nil {
+			return err
+		}
+		status.Forwards = selectForwards(status.Forwards, selector)
+		now := time.Now()
+		rows := topRows(previous, status, now.Sub(last))
+		previous, last = status, now
+
+		if !topOnce {
+			// Move home and clear the screen so the table refreshes in place.
+			fmt.Print("\x1b[H\x1b[2J")
+		}
+		printTop(status, rows, now)
+		if topOnce {
+			return nil
+		}
+	}
+}
+
+// topRows pairs the forwards of status with their counters in previous to work out
+// their rates over elapsed, sorted by topSort.
+func topRows(previous, status *control.StatusResponse, elapsed time.Duration) []topRow {
+	before := make(map[string]manager.ForwardStatus, len(previous.Forwards))
+	for _, f := range previous.Forwards {
+		before[f.ID] = f
+	}
+
+	rows := make([]topRow, 0, len(status.Forwards))
+	for _, f := range status.Forwards {
+		row := topRow{forward: f}
+		if p, ok := before[f.ID]; ok && f.Stats != nil && p.Stats != nil {
+			// A restarted proxy starts counting from zero again; count from there.
+			in, out := f.Stats.BytesIn, f.Stats.BytesOut
+			if in >= p.Stats.BytesIn {
+				in -= p.Stats.BytesIn
+			}
+			if out >= p.Stats.BytesOut {
+				out -= p.Stats.BytesOut
+			}
+			row.inRate = float64(in) / elapsed.Seconds()
+			row.outRate = float64(out) / elapsed.Seconds()
+		}
+		rows = append(rows, row)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		// Forwards without figures go last whatever the order.
+		if (a.forward.Stats == nil) != (b.forward.Stats == nil) {
+			return a.forward.Stats != nil
+		}
+		if a.forward.Stats != nil {
+			switch topSort {
+			case topSortRate:
+				if x, y := a.inRate+a.outRate, b.inRate+b.outRate; x != y {
+					return x > y
+				}
+			case topSortConns:
+				if x, y := a.forward.Stats.ActiveConnections, b.forward.Stats.ActiveConnections; x != y {
+					return x > y
+				}
+			case topSortTotal:
+				if x, y := a.forward.Stats.BytesIn+a.forward.Stats.BytesOut, b.forward.Stats.BytesIn+b.forward.Stats.BytesOut; x != y {
+					return x > y
+				}
+			}
+		}
+		return a.forward.Name < b.forward.Name
+	})
+	return rows
+}
+
+// printTop prints the header and one line per forward.
+func printTop(status *control.StatusResponse, rows []topRow, now time.Time) {
+	var inRate, outRate float64
+	var active int64
+	for _, row := range rows {
+		inRate += row.inRate
+		outRate += row.outRate
+		if row.forward.Stats != nil {
+			active += row.forward.Stats.ActiveConnections
+		}
+	}
+	fmt.Printf("kpfm top - %s  context: %s  sort: %s\n", now.Format("15:04:05"), status.Context, topSort)
+	fmt.Printf("Forwards: %d  in: %s  out: %s  active: %d\n\n",
+		len(rows), formatRate(inRate), formatRate(outRate), active)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLOCAL\tSTATE\tIN/s\tOUT/s\tIN\tOUT\tACTIVE\tCONNS")
+	for _, row := range rows {
+		f := row.forward
+		name := f.Name
+		if f.Context != status.Context {
+			name += " @" + f.Context
+		}
+		in, out, totalIn, totalOut, active, conns := "-", "-", "-", "-", "-", "-"
+		if f.Stats != nil {
+			in = formatRate(row.inRate)
+			out = formatRate(row.outRate)
+			totalIn = formatBytes(f.Stats.BytesIn)
+			totalOut = formatBytes(f.Stats.BytesOut)
+			active = fmt.Sprint(f.Stats.ActiveConnections)
+			conns = fmt.Sprint(f.Stats.TotalConnections)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name, f.Connection.LocalAddr(), f.State, in, out, totalIn, totalOut, active, conns)
+	}
+	w.Flush()
+}
+
+// formatRate renders bytes per second like formatBytes, e.g. "1.5MiB".
+func formatRate(bytesPerSecond float64) string {
+	return formatBytes(int64(bytesPerSecond + 0.5))
+}
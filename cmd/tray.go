@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/tray"
+)
+
+var trayInterval time.Duration
+
+var trayCmd = &cobra.Command{
+	Use:   "tray",
+	Short: "Show the forwards of the running kpfm instance in the system tray",
+	Long: "Show a status icon in the system tray, green when every forward is up, amber while some\n" +
+		"are starting or retrying, red when some are broken and grey when kpfm isn't running. Its\n" +
+		"menu lists the forwards with actions to restart, pause, resume and retry them. The tray\n" +
+		"talks to the instance started by `kpfm start` and keeps running when it restarts.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return tray.Run(control.NewClient(config.SocketPath(configPath)), trayInterval)
+	},
+}
+
+func init() {
+	trayCmd.Flags().DurationVar(&trayInterval, "interval", 2*time.Second, "how often the status is refreshed")
+	rootCmd.AddCommand(trayCmd)
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/update"
+	"github.com/rparaujo/kpfm/pkg/version"
+)
+
+var selfUpdateCheck bool
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the kpfm version and build metadata",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println(version.String())
+	},
+}
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Replace kpfm with the latest GitHub release",
+	Long: "Download the latest release for this platform from GitHub, verify it against the release\n" +
+		"checksums and replace the running binary with it.",
+	Args: cobra.NoArgs,
+	RunE: runSelfUpdate,
+}
+
+func init() {
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateCheck, "check", false, "only report whether a newer release exists")
+	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(selfUpdateCmd)
+}
+
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	release, err := update.Latest(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("cannot check for updates: %v", err)
+	}
+	if release.Tag == version.Version {
+		fmt.Printf("kpfm %s is up to date\n", version.Version)
+		return nil
+	}
+	if selfUpdateCheck {
+		fmt.Printf("kpfm %s is available (running %s)\n", release.Tag, version.Version)
+		return nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return err
+	}
+	if err := update.Apply(cmd.Context(), release, executable); err != nil {
+		return fmt.Errorf("cannot update %s: %v", executable, err)
+	}
+	fmt.Printf("Updated %s from %s to %s\n", executable, version.Version, release.Tag)
+	return nil
+}
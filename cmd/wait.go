@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/instance"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+const waitPollInterval = 500 * time.Millisecond
+
+// runStartWait starts kpfm in the background (unless it is already running), waits until
+// every forward is ready or waitTimeout expires, and prints a summary.
+func runStartWait(cmd *cobra.Command, args []string) error {
+	client := control.NewClient(config.SocketPath(configPath))
+
+	exited := make(chan error, 1)
+	if _, err := client.Status(); err != nil {
+		logPath := filepath.Join(config.RuntimeDir(), "kpfm.log")
+		process, err := instance.Spawn(withoutWaitFlags(os.Args[1:]), logPath)
+		if err != nil {
+			return fmt.Errorf("cannot start kpfm in the background: %v", err)
+		}
+		fmt.Printf("Started kpfm (pid %d), logging to %s\n", process.Pid, logPath)
+		go func() {
+			state, err := process.Wait()
+			if err == nil {
+				err = fmt.Errorf("kpfm exited (%s), see %s", state, logPath)
+			}
+			exited <- err
+		}()
+	}
+
+	deadline := time.After(waitTimeout)
+	for {
+		select {
+		case err := <-exited:
+			return err
+		case <-deadline:
+			status, err := client.Status()
+			if err != nil {
+				return err
+			}
+			printWaitSummary(status)
+			return fmt.Errorf("not every forward was ready after %s", waitTimeout)
+		case <-time.After(waitPollInterval):
+		}
+
+		status, err := client.Status()
+		if err != nil {
+			// The instance is still starting up its control socket.
+			continue
+		}
+		ready, broken := 0, 0
+		for _, f := range status.Forwards {
+			switch f.State {
+			case manager.StateReady, manager.StateIdle:
+				ready++
+			case manager.StateBroken:
+				broken++
+			}
+		}
+		if broken > 0 {
+			printWaitSummary(status)
+			return fmt.Errorf("%d forward(s) are broken", broken)
+		}
+		if ready == len(status.Forwards) {
+			printWaitSummary(status)
+			return nil
+		}
+	}
+}
+
+// printWaitSummary prints where each forward listens and whether it is up.
+func printWaitSummary(status *control.StatusResponse) {
+	fmt.Printf("Context: %s\n", status.Context)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTARGET\tNAMESPACE\tLOCAL\tSTATE\tLAST ERROR")
+	for _, f := range status.Forwards {
+		lastErr := f.LastError
+		if lastErr == "" {
+			lastErr = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			f.Name, f.Connection.Target(), f.Connection.Namespace, f.Connection.LocalAddr(), f.State, lastErr)
+	}
+	w.Flush()
+}
+
+// withoutWaitFlags drops --wait and --wait-timeout from the command line so the
+// background instance runs in the foreground of its own session.
+func withoutWaitFlags(args []string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--wait" || strings.HasPrefix(arg, "--wait="):
+		case arg == "--wait-timeout":
+			i++ // skip the value
+		case strings.HasPrefix(arg, "--wait-timeout="):
+		default:
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
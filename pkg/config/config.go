@@ -0,0 +1,210 @@
+package config
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/util/homedir"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// Dir returns the directory holding the kpfm configuration: ~/.config/kpfm, or
+// %AppData%\kpfm on Windows.
+func Dir() string {
+	if runtime.GOOS == "windows" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			return filepath.Join(dir, "kpfm")
+		}
+	}
+	return filepath.Join(homedir.HomeDir(), ".config", "kpfm")
+}
+
+// Path returns the location of the default config file.
+func Path() string {
+	return filepath.Join(Dir(), "config.yaml")
+}
+
+// StateDir returns the directory holding state kpfm keeps across restarts and reboots:
+// $XDG_STATE_HOME/kpfm, ~/.local/state/kpfm, or %LocalAppData%\kpfm on Windows.
+func StateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "kpfm")
+	}
+	if dir := os.Getenv("LocalAppData"); runtime.GOOS == "windows" && dir != "" {
+		return filepath.Join(dir, "kpfm")
+	}
+	return filepath.Join(homedir.HomeDir(), ".local", "state", "kpfm")
+}
+
+// PortsPath returns the file persisting auto-assigned local ports.
+func PortsPath() string {
+	return filepath.Join(StateDir(), "ports.json")
+}
+
+// CertDir returns the directory holding the self-signed certificates of TLS connections.
+func CertDir() string {
+	return filepath.Join(StateDir(), "certs")
+}
+
+// ExpandHome replaces a leading ~ in path with the user's home directory.
+func ExpandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// EventLogPath returns the default location of the event log.
+func EventLogPath() string {
+	return filepath.Join(StateDir(), "events.log")
+}
+
+// StateFilePath returns the default location of the state file.
+func StateFilePath() string {
+	return filepath.Join(StateDir(), "state.json")
+}
+
+// RuntimeDir returns the directory holding kpfm's runtime files such as the control socket.
+func RuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "kpfm")
+	}
+	if runtime.GOOS == "windows" {
+		// The temp directory is already per user.
+		return filepath.Join(os.TempDir(), "kpfm")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kpfm-%d", os.Getuid()))
+}
+
+// instanceName names the runtime files of the instance running the config at configPath:
+// kpfm for the default config and kpfm-<hash of its absolute path> for any other, so
+// instances of different configs neither share nor remove each other's sockets.
+func instanceName(configPath string) string {
+	abs, err := filepath.Abs(configPath)
+	if err != nil {
+		abs = configPath
+	}
+	if def, err := filepath.Abs(Path()); err == nil && abs == def {
+		return "kpfm"
+	}
+	sum := sha1.Sum([]byte(abs))
+	return "kpfm-" + hex.EncodeToString(sum[:6])
+}
+
+// LockPath returns the PID/lock file of the instance running the config at configPath.
+func LockPath(configPath string) string {
+	return filepath.Join(RuntimeDir(), instanceName(configPath)+".pid")
+}
+
+// SocketPath returns the location of the control socket of the instance running the
+// config at configPath.
+func SocketPath(configPath string) string {
+	return filepath.Join(RuntimeDir(), instanceName(configPath)+".sock")
+}
+
+// GRPCSocketPath returns the default location of the gRPC control socket of the instance
+// running the config at configPath.
+func GRPCSocketPath(configPath string) string {
+	return filepath.Join(RuntimeDir(), instanceName(configPath)+"-grpc.sock")
+}
+
+// RESTTokenPath returns where a generated REST API token of the instance running the
+// config at configPath is stored.
+func RESTTokenPath(configPath string) string {
+	return filepath.Join(RuntimeDir(), instanceName(configPath)+"-rest-token")
+}
+
+// GRPCTokenPath returns where a generated gRPC API token of the instance running the
+// config at configPath is stored.
+func GRPCTokenPath(configPath string) string {
+	return filepath.Join(RuntimeDir(), instanceName(configPath)+"-grpc-token")
+}
+
+// Read parses the YAML config file at filename, decrypting it first when it is SOPS or
+// age encrypted. Keys are matched case-insensitively, so the canonical lowerCamelCase
+// keys and the PascalCase ones both work.
+func Read(filename string) (*model.Contexts, error) {
+	buf, err := readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &model.Contexts{}
+	if err := decode(buf, c); err != nil {
+		return nil, err
+	}
+	if err := expandTemplates(c); err != nil {
+		return nil, err
+	}
+	if err := inheritConnections(c); err != nil {
+		return nil, err
+	}
+	c.ApplyDefaults()
+	if err := c.CheckLabels(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// decode parses YAML config into c, matching keys case-insensitively. Files of an
+// older Version are migrated in memory first.
+func decode(buf []byte, c *model.Contexts) error {
+	var node yaml.Node
+	if err := yaml.Unmarshal(buf, &node); err != nil {
+		return err
+	}
+	if node.Kind == 0 {
+		// Empty file.
+		return nil
+	}
+	if steps, err := migrate(&node); err != nil {
+		return err
+	} else if len(steps) > 0 {
+		logging.Verbosef("Config is of an older version, upgraded in memory; run `kpfm config migrate` to update the file")
+	}
+	if err := canonicalKeys(&node, reflect.TypeOf(c)); err != nil {
+		return err
+	}
+	if err := canonicalTemplateKeys(&node); err != nil {
+		return err
+	}
+	return node.Decode(c)
+}
+
+// EnsureFile creates the config directory and an empty config file if they don't exist.
+func EnsureFile() error {
+	dirPath := Dir()
+	filePath := Path()
+
+	// Step 1: Create the directory if it doesn't exist
+	err := os.MkdirAll(dirPath, 0755) // Permissions are set to rwxr-xr-x
+	if err != nil {
+		return err
+	}
+
+	// Step 2: Create the file only if it does not exist
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			logging.Verbosef("File already exists: %s", filePath)
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	return nil
+}
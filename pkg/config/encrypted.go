@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+)
+
+// Encryption is how a config file is encrypted at rest.
+type Encryption string
+
+const (
+	// NotEncrypted is a plaintext config.
+	NotEncrypted Encryption = ""
+	// SOPS files are YAML with encrypted values and a top-level sops key holding the
+	// metadata; the sops command decrypts them with whatever keys it is set up for.
+	SOPS Encryption = "sops"
+	// Age files are encrypted as a whole with age, armored or binary, and decrypted
+	// with the identities of AgeKeyFile.
+	Age Encryption = "age"
+)
+
+// ageHeaders start age encrypted files: the armored and the binary format.
+var ageHeaders = []string{"-----BEGIN AGE ENCRYPTED FILE-----", "age-encryption.org/v1\n"}
+
+// DetectEncryption reports how the config file content buf is encrypted.
+func DetectEncryption(buf []byte) Encryption {
+	trimmed := bytes.TrimLeft(buf, " \t\r\n")
+	for _, header := range ageHeaders {
+		if bytes.HasPrefix(trimmed, []byte(header)) {
+			return Age
+		}
+	}
+	var doc struct {
+		SOPS *struct {
+			MAC string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	if yaml.Unmarshal(buf, &doc) == nil && doc.SOPS != nil && doc.SOPS.MAC != "" {
+		return SOPS
+	}
+	return NotEncrypted
+}
+
+// AgeKeyFile returns the file holding the age identities that decrypt age encrypted
+// configs: $SOPS_AGE_KEY_FILE, or the keys.txt sops uses under the user config directory.
+func AgeKeyFile() string {
+	if path := os.Getenv("SOPS_AGE_KEY_FILE"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "sops", "age", "keys.txt")
+}
+
+// readFile returns the content of the config file at filename, decrypted in memory when
+// it is encrypted with SOPS or age. The plaintext is never written to disk.
+func readFile(filename string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	switch encryption := DetectEncryption(buf); encryption {
+	case SOPS:
+		logging.Verbosef("Decrypting %s with sops", filename)
+		return decrypt(encryption, "sops", "--decrypt", "--input-type", "yaml", "--output-type", "yaml", filename)
+	case Age:
+		logging.Verbosef("Decrypting %s with age", filename)
+		return decrypt(encryption, "age", "--decrypt", "--identity", AgeKeyFile(), filename)
+	}
+	return buf, nil
+}
+
+// decrypt runs the decryption command name and returns what it printed.
+func decrypt(encryption Encryption, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("cannot decrypt %s encrypted config with %s: %v", encryption, name, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// checkWritable returns an error when the config file content buf is encrypted, since
+// kpfm would write it back in plaintext.
+func checkWritable(filename string, buf []byte) error {
+	if encryption := DetectEncryption(buf); encryption != NotEncrypted {
+		return fmt.Errorf("%s is %s encrypted and kpfm does not rewrite encrypted configs; decrypt it, edit it and encrypt it again", filename, encryption)
+	}
+	return nil
+}
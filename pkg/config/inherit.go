@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// inheritConnections gives every context with an Inherit the connections of the
+// context it names, transitively, merged with its own: a connection named like an
+// inherited one overrides the fields it sets, the others are added after them.
+func inheritConnections(c *model.Contexts) error {
+	byName := make(map[string]int, len(c.Contexts))
+	for i, ctx := range c.Contexts {
+		byName[ctx.Name] = i
+	}
+
+	resolved := make(map[string][]model.Connection)
+	var resolve func(name string, path []string) ([]model.Connection, error)
+	resolve = func(name string, path []string) ([]model.Connection, error) {
+		if connections, ok := resolved[name]; ok {
+			return connections, nil
+		}
+		for _, n := range path {
+			if n == name {
+				return nil, fmt.Errorf("contexts inherit from each other: %s", strings.Join(append(path, name), " -> "))
+			}
+		}
+		ctx := c.Contexts[byName[name]]
+		if ctx.Inherit == "" {
+			return ctx.Connections, nil
+		}
+		if _, ok := byName[ctx.Inherit]; !ok {
+			return nil, fmt.Errorf("context %s inherits from %s, which is not a context of the config", name, ctx.Inherit)
+		}
+		inherited, err := resolve(ctx.Inherit, append(path, name))
+		if err != nil {
+			return nil, err
+		}
+		connections, err := mergeConnections(name, inherited, ctx.Connections)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = connections
+		return connections, nil
+	}
+
+	for i := range c.Contexts {
+		connections, err := resolve(c.Contexts[i].Name, nil)
+		if err != nil {
+			return err
+		}
+		c.Contexts[i].Connections = connections
+	}
+	return nil
+}
+
+// mergeConnections returns inherited with the overrides of own applied and the other
+// connections of own added, for the context contextName.
+func mergeConnections(contextName string, inherited, own []model.Connection) ([]model.Connection, error) {
+	merged := make([]model.Connection, len(inherited), len(inherited)+len(own))
+	copy(merged, inherited)
+	index := make(map[string]int, len(inherited))
+	for i, connection := range inherited {
+		if !connection.AllServices {
+			index[connection.DisplayName()] = i
+		}
+	}
+
+	for _, connection := range own {
+		if i, ok := index[connection.DisplayName()]; ok && !connection.AllServices {
+			merged[i] = override(merged[i], connection)
+			continue
+		}
+		if connection.ServiceName == "" && connection.PodName == "" && !connection.AllServices {
+			return nil, fmt.Errorf("connection %s of context %s has no service or pod and overrides no inherited connection",
+				connection.DisplayName(), contextName)
+		}
+		merged = append(merged, connection)
+	}
+	return merged, nil
+}
+
+// override returns base with the fields set in with, those not of their zero value,
+// replaced. Lists and blocks such as Tags or Probe are replaced as a whole.
+func override(base, with model.Connection) model.Connection {
+	b := reflect.ValueOf(&base).Elem()
+	w := reflect.ValueOf(with)
+	for i := 0; i < w.NumField(); i++ {
+		if field := w.Field(i); !field.IsZero() {
+			b.Field(i).Set(field)
+		}
+	}
+	return base
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// canonicalKeys rewrites the mapping keys of node that match a yaml field name of t
+// case-insensitively to that field name, so serviceName, servicename and ServiceName
+// all set ServiceName. Keys naming no field are an error, unless their value only
+// defines an anchor for use elsewhere, like the shared connection lists of the sample.
+func canonicalKeys(node *yaml.Node, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := canonicalKeys(child, t); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice {
+			return nil
+		}
+		for _, child := range node.Content {
+			if err := canonicalKeys(child, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := yamlFields(t)
+		seen := make(map[string]bool)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[strings.ToLower(key.Value)]
+			if !ok {
+				if value.Anchor != "" {
+					continue
+				}
+				return unknownField(key, t, fields)
+			}
+			if seen[field.name] {
+				return fmt.Errorf("line %d: %s is set twice", key.Line, field.name)
+			}
+			seen[field.name] = true
+			key.Value = field.name
+			if err := canonicalKeys(value, field.typ); err != nil {
+				return err
+			}
+		}
+	case yaml.AliasNode:
+		// The anchored node is rewritten where it is defined, or here if it was defined
+		// under a key naming no field, e.g. a top-level list of shared connections.
+		return canonicalKeys(node.Alias, t)
+	}
+	return nil
+}
+
+// lowerCamelKeys rewrites the mapping keys of node naming a yaml field of t to the
+// canonical lowerCamelCase, leaving alone the keys of maps such as Labels.
+func lowerCamelKeys(node *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for _, child := range node.Content {
+			lowerCamelKeys(child, t.Elem())
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if field, ok := fields[strings.ToLower(node.Content[i].Value)]; ok {
+				node.Content[i].Value = model.LowerCamel(field.name)
+				lowerCamelKeys(node.Content[i+1], field.typ)
+			}
+		}
+	}
+}
+
+// unknownField returns the error for key, which names no field of t, suggesting the
+// field it is most likely a typo of.
+func unknownField(key *yaml.Node, t reflect.Type, fields map[string]yamlField) error {
+	best, bestDistance := "", 3
+	for lower, field := range fields {
+		if d := editDistance(strings.ToLower(key.Value), lower); d < bestDistance || (d == bestDistance && best != "" && field.name < best) {
+			best, bestDistance = field.name, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("line %d: unknown field %q in %s, did you mean %s?", key.Line, key.Value, t.Name(), best)
+	}
+	return fmt.Errorf("line %d: unknown field %q in %s", key.Line, key.Value, t.Name())
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+type yamlField struct {
+	name string
+	typ  reflect.Type
+}
+
+// yamlFields returns the fields of struct type t by lower-cased yaml name.
+func yamlFields(t reflect.Type) map[string]yamlField {
+	fields := make(map[string]yamlField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = yamlField{name: name, typ: f.Type}
+	}
+	return fields
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// CurrentVersion is the version of the config layout this kpfm reads and writes.
+// Files without a Version key predate versioning and are version 0.
+const CurrentVersion = 1
+
+// migration upgrades a parsed config file from version from to from+1 in place.
+type migration struct {
+	from        int
+	description string
+	apply       func(root *yaml.Node) error
+}
+
+// migrations lists the upgrades between consecutive versions in order. A change of
+// layout appends one, so files written for any earlier version keep loading.
+var migrations = []migration{
+	{0, "record the config version", func(*yaml.Node) error { return nil }},
+}
+
+// Migrate upgrades the config file at filename to CurrentVersion. It returns the
+// upgraded file, keeping comments and anchors, and the steps applied; no steps means
+// the file is already current and data is nil.
+func Migrate(filename string) (data []byte, steps []string, err error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkWritable(filename, buf); err != nil {
+		return nil, nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Kind == 0 {
+		// Empty file.
+		return nil, nil, nil
+	}
+	steps, err = migrate(&doc)
+	if err != nil || len(steps) == 0 {
+		return nil, steps, err
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, nil, err
+	}
+	// Make sure the result still loads before anyone writes it.
+	if err := decode(out.Bytes(), &model.Contexts{}); err != nil {
+		return nil, nil, fmt.Errorf("migrated config is invalid: %v", err)
+	}
+	return out.Bytes(), steps, nil
+}
+
+// migrate upgrades the config document doc to CurrentVersion in place and returns the
+// descriptions of the migrations it applied.
+func migrate(doc *yaml.Node) ([]string, error) {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		// Left for decoding to report.
+		return nil, nil
+	}
+	version, err := configVersion(root)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("config version %d is newer than the %d this kpfm supports, upgrade kpfm", version, CurrentVersion)
+	}
+
+	var steps []string
+	for _, m := range migrations {
+		if m.from < version {
+			continue
+		}
+		if err := m.apply(root); err != nil {
+			return nil, fmt.Errorf("cannot migrate config from version %d: %v", m.from, err)
+		}
+		steps = append(steps, fmt.Sprintf("%d -> %d: %s", m.from, m.from+1, m.description))
+	}
+	if len(steps) > 0 {
+		setVersion(root, CurrentVersion)
+	}
+	return steps, nil
+}
+
+// configVersion returns the Version of the config mapping root, 0 when it has none.
+func configVersion(root *yaml.Node) (int, error) {
+	_, value := mappingValue(root, "Version")
+	if value == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value.Value)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("line %d: invalid config version %q", value.Line, value.Value)
+	}
+	return version, nil
+}
+
+// setVersion sets the Version of the config mapping root, adding it as the first key
+// when it has none.
+func setVersion(root *yaml.Node, version int) {
+	if _, value := mappingValue(root, "Version"); value != nil {
+		value.Value = strconv.Itoa(version)
+		value.Tag = "!!int"
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "Version"}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// mappingValue returns the key and value nodes of name in mapping, matched
+// case-insensitively like every config key, or nils.
+func mappingValue(mapping *yaml.Node, name string) (key, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if strings.EqualFold(mapping.Content[i].Value, name) {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
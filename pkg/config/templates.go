@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// placeholder matches the ${name} parameters of template connections.
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_-]*)\}`)
+
+var connectionType = reflect.TypeOf(model.Connection{})
+
+// canonicalTemplateKeys does for the connections of the Templates of the config
+// document what canonicalKeys does for the rest of it, which leaves them alone since
+// they are decoded once instantiated. Unknown keys are reported with their line.
+func canonicalTemplateKeys(doc *yaml.Node) error {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	_, templates := mappingValue(root, "Templates")
+	if templates == nil || templates.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, template := range templates.Content {
+		if template.Kind != yaml.MappingNode {
+			continue
+		}
+		_, connections := mappingValue(template, "Connections")
+		if connections == nil || connections.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, connection := range connections.Content {
+			if err := canonicalKeys(connection, connectionType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expandTemplates appends the connections of the templates each context uses to its
+// Connections.
+func expandTemplates(c *model.Contexts) error {
+	templates := make(map[string]model.Template, len(c.Templates))
+	for _, t := range c.Templates {
+		if t.Name == "" {
+			return fmt.Errorf("templates need a Name")
+		}
+		if _, ok := templates[t.Name]; ok {
+			return fmt.Errorf("two templates are named %s", t.Name)
+		}
+		templates[t.Name] = t
+	}
+
+	for i := range c.Contexts {
+		ctx := &c.Contexts[i]
+		for _, use := range ctx.Use {
+			t, ok := templates[use.Template]
+			if !ok {
+				return fmt.Errorf("context %s uses template %q, which is not defined", ctx.Name, use.Template)
+			}
+			connections, err := instantiate(t, use.Parameters)
+			if err != nil {
+				return fmt.Errorf("context %s: template %s: %v", ctx.Name, t.Name, err)
+			}
+			ctx.Connections = append(ctx.Connections, connections...)
+		}
+	}
+	return nil
+}
+
+// instantiate returns the connections of t with its placeholders replaced by
+// parameters, or by the defaults of t for those left out.
+func instantiate(t model.Template, parameters map[string]string) ([]model.Connection, error) {
+	values := make(map[string]string, len(t.Parameters))
+	for name, value := range t.Parameters {
+		values[name] = value
+	}
+	for name, value := range parameters {
+		if _, ok := t.Parameters[name]; !ok {
+			if len(t.Parameters) == 0 {
+				return nil, fmt.Errorf("unknown parameter %s, it has none", name)
+			}
+			return nil, fmt.Errorf("unknown parameter %s, it has %s", name, strings.Join(parameterNames(t), ", "))
+		}
+		values[name] = value
+	}
+	for _, name := range parameterNames(t) {
+		if values[name] == "" {
+			return nil, fmt.Errorf("parameter %s is not set", name)
+		}
+	}
+
+	connections := make([]model.Connection, 0, len(t.Connections))
+	for i, fields := range t.Connections {
+		var node yaml.Node
+		if err := node.Encode(fields); err != nil {
+			return nil, err
+		}
+		if err := substitute(&node, values); err != nil {
+			return nil, fmt.Errorf("connection %d: %v", i+1, err)
+		}
+		var connection model.Connection
+		if err := node.Decode(&connection); err != nil {
+			return nil, fmt.Errorf("connection %d: %v", i+1, err)
+		}
+		connections = append(connections, connection)
+	}
+	return connections, nil
+}
+
+// substitute replaces the placeholders in the values under node. A value changed this
+// way is typed anew, so "${port}" can fill a number.
+func substitute(node *yaml.Node, values map[string]string) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var err error
+		value := placeholder.ReplaceAllStringFunc(node.Value, func(match string) string {
+			name := placeholder.FindStringSubmatch(match)[1]
+			v, ok := values[name]
+			if !ok && err == nil {
+				err = fmt.Errorf("%s is not a parameter of the template", match)
+			}
+			return v
+		})
+		if err != nil {
+			return err
+		}
+		if value != node.Value {
+			node.Value, node.Tag, node.Style = value, "", 0
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := substitute(node.Content[i], values); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := substitute(child, values); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parameterNames returns the sorted names of the parameters of t.
+func parameterNames(t model.Template) []string {
+	names := make([]string, 0, len(t.Parameters))
+	for name := range t.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
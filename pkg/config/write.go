@@ -0,0 +1,243 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// maxBackups is how many backups of each config file are kept.
+const maxBackups = 10
+
+// BackupDir returns the directory holding backups of config files kpfm rewrote.
+func BackupDir() string {
+	return filepath.Join(Dir(), "backups")
+}
+
+// Append adds the connections of imported to the config file at filename, creating
+// contexts it doesn't have yet, and returns how many it added. Connections already
+// configured are left out. Only the new entries are added to the YAML document, so the
+// comments, anchors and key order of the file are kept.
+func Append(filename string, imported *model.Contexts) (int, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if err := checkWritable(filename, buf); err != nil {
+		return 0, err
+	}
+	// Read without applying defaults, as the file has them.
+	c := &model.Contexts{}
+	if err := decode(buf, c); err != nil {
+		return 0, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return 0, err
+	}
+	if doc.Kind == 0 {
+		// Empty file.
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if _, err := migrate(&doc); err != nil {
+		return 0, err
+	}
+	root := doc.Content[0]
+	setVersion(root, CurrentVersion)
+	// New entries follow the key casing of the file, PascalCase or lowerCamelCase.
+	contextsKey, _ := mappingValue(root, "Contexts")
+	lowerKeys := contextsKey != nil && contextsKey.Value == "contexts"
+	contexts := mappingSequence(root, "Contexts")
+
+	added := 0
+	for _, ctx := range imported.Contexts {
+		i := 0
+		for ; i < len(c.Contexts); i++ {
+			if c.Contexts[i].Name == ctx.Name {
+				break
+			}
+		}
+		if i == len(c.Contexts) {
+			c.Contexts = append(c.Contexts, model.Context{Name: ctx.Name})
+			context := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "Name"},
+				{Kind: yaml.ScalarNode, Value: ctx.Name},
+				{Kind: yaml.ScalarNode, Value: "Connections"},
+				{Kind: yaml.SequenceNode},
+			}}
+			if lowerKeys {
+				lowerCamelKeys(context, reflect.TypeOf(model.Context{}))
+			}
+			contexts.Content = append(contexts.Content, context)
+		}
+		connections := mappingSequence(unalias(contexts.Content[i]), "Connections")
+		for _, connection := range ctx.Connections {
+			existing := c.Contexts[i].Connections
+			if hasConnection(existing, connection) {
+				continue
+			}
+			if name := connection.DisplayName(); hasName(existing, name) {
+				// Names must stay unique within the context.
+				connection.Name = fmt.Sprintf("%s:%d", name, connection.RemoteServicePort)
+				if hasName(existing, connection.Name) {
+					connection.Name = fmt.Sprintf("%s.%s:%d", name, connection.Namespace, connection.RemoteServicePort)
+				}
+			}
+			node := &yaml.Node{}
+			if err := node.Encode(connection); err != nil {
+				return 0, err
+			}
+			if lowerKeys {
+				lowerCamelKeys(node, reflect.TypeOf(connection))
+			}
+			connections.Content = append(connections.Content, node)
+			c.Contexts[i].Connections = append(existing, connection)
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return 0, err
+	}
+	if err := enc.Close(); err != nil {
+		return 0, err
+	}
+	// Make sure the result still loads before it replaces the file.
+	if err := decode(out.Bytes(), &model.Contexts{}); err != nil {
+		return 0, fmt.Errorf("updated config is invalid: %v", err)
+	}
+	return added, WriteFile(filename, out.Bytes())
+}
+
+// mappingSequence returns the block sequence under name in mapping, adding an empty one
+// when it has none.
+func mappingSequence(mapping *yaml.Node, name string) *yaml.Node {
+	_, value := mappingValue(mapping, name)
+	if value == nil {
+		value = &yaml.Node{Kind: yaml.SequenceNode}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, value)
+	}
+	value = unalias(value)
+	// Entries are added one per line, not to a [] left by hand.
+	value.Style &^= yaml.FlowStyle
+	return value
+}
+
+// unalias replaces an alias node with a copy of the node it refers to, so what is added
+// to it doesn't also end up at the anchor and its other aliases.
+func unalias(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		copied := *node.Alias
+		copied.Anchor = ""
+		copied.Content = append([]*yaml.Node(nil), node.Alias.Content...)
+		*node = copied
+	}
+	return node
+}
+
+func hasConnection(connections []model.Connection, connection model.Connection) bool {
+	for _, existing := range connections {
+		if existing.Namespace == connection.Namespace && existing.Target() == connection.Target() &&
+			existing.RemoteServicePort == connection.RemoteServicePort && existing.LocalPort == connection.LocalPort {
+			return true
+		}
+	}
+	return false
+}
+
+func hasName(connections []model.Connection, name string) bool {
+	for _, existing := range connections {
+		if existing.DisplayName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteFile atomically replaces the file at filename with data: the previous content is
+// copied to BackupDir first and data goes to a temporary file renamed over filename, so a
+// crash mid-write leaves either the old or the new file behind.
+func WriteFile(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+		if err := backup(filename); err != nil {
+			return fmt.Errorf("cannot back up %s: %v", filename, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// backup copies filename to a timestamped file in BackupDir and removes the oldest
+// backups of it beyond maxBackups.
+func backup(filename string) error {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	dir := BackupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ext := filepath.Ext(filename)
+	prefix := strings.TrimSuffix(filepath.Base(filename), ext) + "-"
+	name := prefix + time.Now().Format("20060102-150405.000") + ext
+	if err := ioutil.WriteFile(filepath.Join(dir, name), buf, 0600); err != nil {
+		return err
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, prefix+"*"+ext))
+	if err != nil {
+		return err
+	}
+	// Timestamps sort chronologically.
+	sort.Strings(backups)
+	for len(backups) > maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
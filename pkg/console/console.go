@@ -0,0 +1,162 @@
+// Package console renders manager events as one human-friendly, optionally colored line
+// per state change.
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// ANSI escape sequences.
+const (
+	reset  = "\x1b[0m"
+	bold   = "\x1b[1m"
+	dim    = "\x1b[2m"
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+	cyan   = "\x1b[36m"
+)
+
+// repeatInterval is how often a forward failing again and again with the same error is
+// reported while it keeps failing.
+const repeatInterval = time.Minute
+
+// Renderer writes event lines to a terminal or file.
+type Renderer struct {
+	mu      sync.Mutex
+	out     io.Writer
+	color   bool
+	width   int                // widest connection name seen so far, to keep the columns aligned
+	repeats map[string]*repeat // per forward name, the error it keeps failing with
+}
+
+// repeat is an error a forward failed with in a row, so the failures collapse into one
+// line once in a while instead of one line per restart. The event log keeps them all.
+type repeat struct {
+	err     string
+	count   int
+	first   time.Time
+	printed time.Time
+}
+
+// New returns a Renderer writing to out. Colors are used when out is a terminal, unless
+// noColor is set or the NO_COLOR environment variable is.
+func New(out *os.File, noColor bool) *Renderer {
+	return &Renderer{out: out, color: !noColor && colorSupported(out), repeats: make(map[string]*repeat)}
+}
+
+func colorSupported(out *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := out.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Event prints a one-line summary of event.
+func (r *Renderer) Event(event manager.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	timestamp := r.paint(dim, event.Time.Local().Format("15:04:05"))
+	if event.Type == manager.EventContextChanged {
+		fmt.Fprintf(r.out, "%s %s\n", timestamp, r.paint(bold+cyan, "context changed to "+event.Context))
+		return
+	}
+
+	count, show := r.collapse(event)
+	if !show {
+		return
+	}
+	if len(event.Name) > r.width {
+		r.width = len(event.Name)
+	}
+	name := event.Name + strings.Repeat(" ", r.width-len(event.Name))
+	color, symbol := style(event.Type)
+	line := fmt.Sprintf("%s %s %s %s", timestamp, r.paint(color, symbol), r.paint(bold, name), r.paint(color, string(event.Type)))
+	if detail := detail(event); detail != "" {
+		line += " " + detail
+	}
+	if count > 1 {
+		line += r.paint(dim, fmt.Sprintf(" (x%d, first seen %s)", count, r.repeats[event.Name].first.Local().Format("15:04")))
+	}
+	fmt.Fprintln(r.out, line)
+}
+
+// collapse tracks the errors each forward fails with in a row. It returns how many times
+// in a row the forward failed with the error of event, and whether to print event: a
+// repeated failure only every repeatInterval, and none of the restarts between them.
+func (r *Renderer) collapse(event manager.Event) (int, bool) {
+	rep := r.repeats[event.Name]
+	switch event.Type {
+	case manager.EventFailed:
+		if event.Err == nil {
+			return 0, true
+		}
+		if rep == nil || rep.err != event.Err.Error() {
+			r.repeats[event.Name] = &repeat{err: event.Err.Error(), count: 1, first: event.Time, printed: event.Time}
+			return 1, true
+		}
+		rep.count++
+		if event.Time.Sub(rep.printed) < repeatInterval {
+			return rep.count, false
+		}
+		rep.printed = event.Time
+		return rep.count, true
+	case manager.EventRestarting, manager.EventStarting, manager.EventPodResolved:
+		return 0, rep == nil || rep.count < 2
+	}
+	delete(r.repeats, event.Name)
+	return 0, true
+}
+
+func (r *Renderer) paint(color, s string) string {
+	if !r.color || color == "" {
+		return s
+	}
+	return color + s + reset
+}
+
+// style returns the color and symbol of an event type: green when ready, yellow while
+// (re)starting, red on failures.
+func style(t manager.EventType) (string, string) {
+	switch t {
+	case manager.EventReady:
+		return green, "●"
+	case manager.EventStarting, manager.EventRestarting, manager.EventPodResolved:
+		return yellow, "◌"
+	case manager.EventFailed, manager.EventBroken:
+		return red, "✗"
+	default:
+		return dim, "○"
+	}
+}
+
+// detail describes the specifics of an event.
+func detail(event manager.Event) string {
+	switch event.Type {
+	case manager.EventReady, manager.EventPodResolved:
+		if event.Pod != "" {
+			return "pod " + event.Pod
+		}
+	case manager.EventBroken:
+		return fmt.Sprintf("(run `kpfm retry %s` once fixed): %v", event.Name, event.Err)
+	case manager.EventIdle:
+		return "until the next connection"
+	case manager.EventPaused:
+		return fmt.Sprintf("(run `kpfm resume %s` to bring it back)", event.Name)
+	case manager.EventWaiting:
+		return "for the connections it depends on"
+	}
+	if event.Err != nil {
+		return event.Err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// StatusResponse is returned by the /status endpoint.
+type StatusResponse struct {
+	Context  string
+	Forwards []manager.ForwardStatus
+}
+
+// Event is the wire form of a manager.Event served by the /events endpoint.
+type Event struct {
+	Type        manager.EventType
+	Time        time.Time
+	Context     string            `json:",omitempty"`
+	Name        string            `json:",omitempty"`
+	ServiceName string            `json:",omitempty"`
+	Pod         string            `json:",omitempty"`
+	Error       string            `json:",omitempty"`
+	Labels      map[string]string `json:",omitempty"`
+}
+
+// NewEvent converts a manager event to its wire form.
+func NewEvent(event manager.Event) Event {
+	e := Event{
+		Type:        event.Type,
+		Time:        event.Time,
+		Context:     event.Context,
+		Name:        event.Name,
+		ServiceName: event.ServiceName,
+		Pod:         event.Pod,
+		Labels:      event.Labels,
+	}
+	if event.Err != nil {
+		e.Error = event.Err.Error()
+	}
+	return e
+}
+
+// Client talks to a running kpfm instance over its control socket.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the control socket at path.
+func NewClient(path string) *Client {
+	return &Client{http: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}}
+}
+
+// Status returns the state of every forward of the running instance.
+func (c *Client) Status() (*StatusResponse, error) {
+	status := &StatusResponse{}
+	if err := c.get("/status", status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Retry re-arms a broken forward of the running instance.
+func (c *Client) Retry(name string) error {
+	return c.post("/retry?name=" + url.QueryEscape(name))
+}
+
+// Restart restarts a forward of the running instance.
+func (c *Client) Restart(name string) error {
+	return c.post("/restart?name=" + url.QueryEscape(name))
+}
+
+// Pause pauses a forward of the running instance.
+func (c *Client) Pause(name string) error {
+	return c.post("/pause?name=" + url.QueryEscape(name))
+}
+
+// Resume resumes a paused forward of the running instance.
+func (c *Client) Resume(name string) error {
+	return c.post("/resume?name=" + url.QueryEscape(name))
+}
+
+// Reload has the running instance read the kubeconfig again, from the files of
+// kubeconfig, a KUBECONFIG style list where empty means ~/.kube/config.
+func (c *Client) Reload(kubeconfig string) error {
+	return c.post("/reload?kubeconfig=" + url.QueryEscape(kubeconfig))
+}
+
+// Switch has the running instance move its forwards to kubeContext right away, without
+// waiting to notice that it became the current context.
+func (c *Client) Switch(kubeContext string) (*manager.ContextSwitch, error) {
+	result := &manager.ContextSwitch{}
+	if err := c.postFor("/switch?context="+url.QueryEscape(kubeContext), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Events calls fn with the recent events of the running instance and, when follow is
+// set, with every new event until the instance stops or fn returns an error.
+func (c *Client) Events(follow bool, fn func(Event) error) error {
+	path := "/events"
+	if follow {
+		path += "?follow=1"
+	}
+	resp, err := c.http.Get("http://kpfm" + path)
+	if err != nil {
+		return fmt.Errorf("cannot reach kpfm, is it running? %v", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event Event
+		if err := dec.Decode(&event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
+
+// Logs calls fn with the log lines of the named forward, the last tail ones when tail
+// is not negative, and when follow is set with every new line until the instance stops
+// or fn returns an error.
+func (c *Client) Logs(name string, tail int, follow bool, fn func(logging.Line) error) error {
+	query := url.Values{"name": {name}}
+	if tail >= 0 {
+		query.Set("tail", strconv.Itoa(tail))
+	}
+	if follow {
+		query.Set("follow", "1")
+	}
+	resp, err := c.http.Get("http://kpfm/logs?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("cannot reach kpfm, is it running? %v", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var line logging.Line
+		if err := dec.Decode(&line); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) get(path string, v interface{}) error {
+	resp, err := c.http.Get("http://kpfm" + path)
+	if err != nil {
+		return fmt.Errorf("cannot reach kpfm, is it running? %v", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *Client) post(path string) error {
+	resp, err := c.http.Post("http://kpfm"+path, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("cannot reach kpfm, is it running? %v", err)
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// postFor is post decoding the JSON response into v.
+func (c *Client) postFor(path string, v interface{}) error {
+	resp, err := c.http.Post("http://kpfm"+path, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("cannot reach kpfm, is it running? %v", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
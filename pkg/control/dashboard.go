@@ -0,0 +1,69 @@
+package control
+
+import (
+	"context"
+	_ "embed"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// DefaultDashboardListen is where the dashboard is served unless configured otherwise.
+const DefaultDashboardListen = model.DefaultDashboardListen
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// ListenAndServeDashboard serves the web dashboard on the loopback TCP address addr until
+// ctx is cancelled. The page drives the control API, which it reaches under /api/.
+func (s *Server) ListenAndServeDashboard(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", http.StripPrefix("/api", s.mux))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+
+	if addr == "" {
+		addr = DefaultDashboardListen
+	}
+	// The Host check of localOnly can be forged by anyone reaching the port.
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Dashboard listening on http://%s", listener.Addr())
+	return serveHTTP(ctx, listener, localOnly(mux))
+}
+
+// localOnly protects a handler served over TCP from other sites the browser visits:
+// the Host must be a loopback name (against DNS rebinding) and state-changing requests
+// must carry a header a cross-site form cannot set.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		ip := net.ParseIP(host)
+		if host != "localhost" && !strings.HasSuffix(host, ".localhost") && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet && r.Header.Get("X-Kpfm") != "1" {
+			http.Error(w, "missing X-Kpfm header", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
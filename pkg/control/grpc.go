@@ -0,0 +1,254 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	apiv1 "github.com/rparaujo/kpfm/pkg/api/v1"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// grpcServer implements the Control service of pkg/api/v1 on top of a Manager.
+type grpcServer struct {
+	apiv1.UnimplementedControlServer
+	manager *manager.Manager
+}
+
+// ListenAndServeGRPC serves the gRPC control API until ctx is cancelled. listen is either
+// unix://<path> or a loopback host:port. When token is set every call must carry
+// "authorization: Bearer <token>" metadata; a host:port listener requires one.
+func (s *Server) ListenAndServeGRPC(ctx context.Context, listen, token string) error {
+	listener, cleanup, err := listenGRPC(listen, token)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var opts []grpc.ServerOption
+	if token != "" {
+		opts = append(opts,
+			grpc.UnaryInterceptor(requireTokenUnary(token)),
+			grpc.StreamInterceptor(requireTokenStream(token)))
+	}
+	srv := grpc.NewServer(opts...)
+	apiv1.RegisterControlServer(srv, &grpcServer{manager: s.manager})
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	return srv.Serve(listener)
+}
+
+func listenGRPC(listen, token string) (net.Listener, func(), error) {
+	if path := strings.TrimPrefix(listen, "unix://"); path != listen {
+		listener, err := listenUnix(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return listener, func() { os.Remove(path) }, nil
+	}
+
+	if token == "" {
+		return nil, nil, errors.New("the gRPC API needs a token on a TCP address")
+	}
+	if err := checkLoopback(listen); err != nil {
+		return nil, nil, err
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return listener, func() {}, nil
+}
+
+// checkToken is the gRPC counterpart of requireToken.
+func checkToken(ctx context.Context, token string) error {
+	var got string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) == 1 {
+			got = values[0]
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return nil
+}
+
+func requireTokenUnary(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := checkToken(ctx, token); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func requireTokenStream(token string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkToken(ss.Context(), token); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// bearerToken sends the API token with every call of a client.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity allows the token over plaintext, which is only served on
+// loopback.
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
+
+// DialGRPC connects to the gRPC control API at target, e.g. unix:///run/user/1000/kpfm/kpfm-grpc.sock.
+// token is sent with every call when set, as a TCP listener requires.
+func DialGRPC(target, token string) (apiv1.ControlClient, *grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken(token)))
+	}
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return apiv1.NewControlClient(conn), conn, nil
+}
+
+func (g *grpcServer) Status(ctx context.Context, req *apiv1.StatusRequest) (*apiv1.StatusResponse, error) {
+	resp := &apiv1.StatusResponse{Context: g.manager.Context()}
+	for _, f := range g.manager.Status() {
+		resp.Forwards = append(resp.Forwards, protoForward(f))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) Retry(ctx context.Context, req *apiv1.ForwardRequest) (*apiv1.ForwardResponse, error) {
+	return &apiv1.ForwardResponse{}, grpcError(g.manager.Retry(req.Name))
+}
+
+func (g *grpcServer) Restart(ctx context.Context, req *apiv1.ForwardRequest) (*apiv1.ForwardResponse, error) {
+	return &apiv1.ForwardResponse{}, grpcError(g.manager.Restart(req.Name))
+}
+
+func (g *grpcServer) Pause(ctx context.Context, req *apiv1.ForwardRequest) (*apiv1.ForwardResponse, error) {
+	return &apiv1.ForwardResponse{}, grpcError(g.manager.Pause(req.Name))
+}
+
+func (g *grpcServer) Resume(ctx context.Context, req *apiv1.ForwardRequest) (*apiv1.ForwardResponse, error) {
+	return &apiv1.ForwardResponse{}, grpcError(g.manager.Resume(req.Name))
+}
+
+func (g *grpcServer) Events(req *apiv1.EventsRequest, stream apiv1.Control_EventsServer) error {
+	history, events, unsubscribe := g.manager.SubscribeWithHistory()
+	defer unsubscribe()
+
+	for _, event := range history {
+		if err := stream.Send(protoEvent(event)); err != nil {
+			return err
+		}
+	}
+	if !req.Follow {
+		return nil
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(protoEvent(event)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// grpcError maps manager errors to gRPC status codes.
+func grpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, manager.ErrUnknownForward):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func protoForward(f manager.ForwardStatus) *apiv1.Forward {
+	c := f.Connection
+	forward := &apiv1.Forward{
+		Name:        f.Name,
+		Context:     f.Context,
+		Target:      c.Target(),
+		ServiceName: c.ServiceName,
+		PodName:     c.PodName,
+		Namespace:   c.Namespace,
+		LocalPort:   int32(c.LocalPort),
+		RemotePort:  int32(c.RemoteServicePort),
+		Tags:        c.Tags,
+		State:       string(f.State),
+		Pod:         f.Pod,
+		LastError:   f.LastError,
+		Since:       timestamppb.New(f.Since),
+		Failures:    int32(f.Failures),
+
+		Restarts:      int32(f.Restarts),
+		TotalFailures: int32(f.TotalFailures),
+		LastFailure:   f.LastFailure,
+	}
+	if !f.LastFailureAt.IsZero() {
+		forward.LastFailureTime = timestamppb.New(f.LastFailureAt)
+	}
+	if !f.LastReady.IsZero() {
+		forward.LastReady = timestamppb.New(f.LastReady)
+	}
+	if f.Uptime > 0 {
+		forward.Uptime = durationpb.New(f.Uptime)
+	}
+	if f.Stats != nil {
+		forward.Stats = &apiv1.Stats{
+			BytesIn:           f.Stats.BytesIn,
+			BytesOut:          f.Stats.BytesOut,
+			ActiveConnections: f.Stats.ActiveConnections,
+			TotalConnections:  f.Stats.TotalConnections,
+		}
+	}
+	return forward
+}
+
+func protoEvent(event manager.Event) *apiv1.Event {
+	e := &apiv1.Event{
+		Type:        string(event.Type),
+		Time:        timestamppb.New(event.Time),
+		Context:     event.Context,
+		Name:        event.Name,
+		ServiceName: event.ServiceName,
+		Pod:         event.Pod,
+		Labels:      event.Labels,
+	}
+	if event.Err != nil {
+		e.Error = event.Err.Error()
+	}
+	return e
+}
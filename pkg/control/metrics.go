@@ -0,0 +1,108 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/manager"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// writeMetrics renders forward state, restart and failure counters and traffic counters in the Prometheus text format.
+func writeMetrics(w io.Writer, forwards []manager.ForwardStatus) {
+	fmt.Fprintln(w, "# HELP kpfm_forward_up Whether the forward is ready (1) or not (0).")
+	fmt.Fprintln(w, "# TYPE kpfm_forward_up gauge")
+	for _, f := range forwards {
+		up := 0
+		if f.State == manager.StateReady {
+			up = 1
+		}
+		fmt.Fprintf(w, "kpfm_forward_up{%s} %d\n", labels(f), up)
+	}
+
+	fmt.Fprintln(w, "# HELP kpfm_forward_reachable Whether the last local dial of the ready forward got an answer (1) or not (0).")
+	fmt.Fprintln(w, "# TYPE kpfm_forward_reachable gauge")
+	for _, f := range forwards {
+		if f.Reachability == nil {
+			continue
+		}
+		reachable := 0
+		if f.Reachability.Reachable {
+			reachable = 1
+		}
+		fmt.Fprintf(w, "kpfm_forward_reachable{%s} %d\n", labels(f), reachable)
+	}
+
+	metrics := []struct {
+		name, help, kind string
+		stats            bool // only for forwards behind a counting proxy
+		value            func(f manager.ForwardStatus) float64
+	}{
+		{"kpfm_forward_restarts_total", "Generations of the forward started after the first.", "counter", false,
+			func(f manager.ForwardStatus) float64 { return float64(f.Restarts) }},
+		{"kpfm_forward_failures_total", "Failures of the forward, consecutive or not.", "counter", false,
+			func(f manager.ForwardStatus) float64 { return float64(f.TotalFailures) }},
+		{"kpfm_forward_uptime_seconds", "How long the forward has been ready, 0 unless it is.", "gauge", false,
+			func(f manager.ForwardStatus) float64 { return f.Uptime.Seconds() }},
+		{"kpfm_forward_last_ready_timestamp_seconds", "When the forward last became ready, 0 if it never did.", "gauge", false,
+			func(f manager.ForwardStatus) float64 { return unixSeconds(f.LastReady) }},
+		{"kpfm_forward_last_failure_timestamp_seconds", "When the forward last failed, 0 if it never did.", "gauge", false,
+			func(f manager.ForwardStatus) float64 { return unixSeconds(f.LastFailureAt) }},
+		{"kpfm_forward_suppressed_log_lines_total", "Forwarder output lines the log filter dropped.", "counter", false,
+			func(f manager.ForwardStatus) float64 { return float64(f.SuppressedLogLines) }},
+		{"kpfm_forward_received_bytes_total", "Bytes sent by local clients through the forward.", "counter", true,
+			func(f manager.ForwardStatus) float64 { return float64(f.Stats.BytesIn) }},
+		{"kpfm_forward_sent_bytes_total", "Bytes returned to local clients by the forward.", "counter", true,
+			func(f manager.ForwardStatus) float64 { return float64(f.Stats.BytesOut) }},
+		{"kpfm_forward_active_connections", "Local connections currently open.", "gauge", true,
+			func(f manager.ForwardStatus) float64 { return float64(f.Stats.ActiveConnections) }},
+		{"kpfm_forward_connections_total", "Local connections accepted.", "counter", true,
+			func(f manager.ForwardStatus) float64 { return float64(f.Stats.TotalConnections) }},
+		{"kpfm_forward_connection_seconds_total", "Summed duration of closed local connections.", "counter", true,
+			func(f manager.ForwardStatus) float64 { return f.Stats.ConnectionTime.Seconds() }},
+		{"kpfm_forward_longest_connection_seconds", "Duration of the longest closed local connection.", "gauge", true,
+			func(f manager.ForwardStatus) float64 { return f.Stats.LongestConnection.Seconds() }},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric.name, metric.kind)
+		for _, f := range forwards {
+			if metric.stats && f.Stats == nil {
+				continue
+			}
+			fmt.Fprintf(w, "%s{%s} %g\n", metric.name, labels(f), metric.value(f))
+		}
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// labels renders the Prometheus labels of a forward, with the Labels of its connection
+// as label_<key>, sorted by key. A key exported under the same name as an earlier one is
+// left out, since Prometheus rejects duplicate labels.
+func labels(f manager.ForwardStatus) string {
+	s := fmt.Sprintf("context=%q,name=%q,namespace=%q,service=%q,local_port=\"%d\"",
+		f.Context, f.Name, f.Connection.Namespace, f.Connection.ServiceName, f.Connection.LocalPort)
+	keys := make([]string, 0, len(f.Connection.Labels))
+	for key := range f.Connection.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		name := model.MetricLabelName(key)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		s += fmt.Sprintf(",%s=%q", name, f.Connection.Labels[key])
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/rparaujo/kpfm/pkg/manager"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// DefaultRESTListen is where the REST API is served unless configured otherwise.
+const DefaultRESTListen = model.DefaultRESTListen
+
+// ListenAndServeREST serves the REST API on the loopback address addr until ctx is
+// cancelled. Every request must carry "Authorization: Bearer <token>".
+//
+//	GET    /v1/status                    context and forwards
+//	GET    /v1/forwards                  forwards
+//	POST   /v1/forwards                  add a forward from a JSON connection, without hooks,
+//	                                     Inject, TLS files or LocalSocket
+//	DELETE /v1/forwards/<name>           remove a forward
+//	POST   /v1/forwards/<name>/restart   restart a forward
+//	POST   /v1/forwards/<name>/retry     re-arm a broken forward
+//	POST   /v1/forwards/<name>/pause     pause a forward
+//	POST   /v1/forwards/<name>/resume    resume a paused forward
+func (s *Server) ListenAndServeREST(ctx context.Context, addr, token string) error {
+	if token == "" {
+		return errors.New("the REST API needs a token")
+	}
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", s.handleStatus)
+	mux.HandleFunc("/v1/forwards", s.handleForwards)
+	mux.HandleFunc("/v1/forwards/", s.handleForward)
+	return serveHTTP(ctx, listener, requireToken(token, mux))
+}
+
+// requireToken rejects requests without the bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleForwards(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, s.manager.Status())
+	case http.MethodPost:
+		var connection model.Connection
+		if err := json.NewDecoder(r.Body).Decode(&connection); err != nil {
+			http.Error(w, fmt.Sprintf("invalid connection: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := validateConnection(connection); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.manager.Add(connection); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleForward serves /v1/forwards/<name> and its actions.
+func (s *Server) handleForward(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/forwards/"), "/")
+	name := parts[0]
+
+	var err error
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		err = s.manager.Remove(name)
+	case len(parts) == 2 && parts[1] == "restart" && r.Method == http.MethodPost:
+		err = s.manager.Restart(name)
+	case len(parts) == 2 && parts[1] == "retry" && r.Method == http.MethodPost:
+		err = s.manager.Retry(name)
+	case len(parts) == 2 && parts[1] == "pause" && r.Method == http.MethodPost:
+		err = s.manager.Pause(name)
+	case len(parts) == 2 && parts[1] == "resume" && r.Method == http.MethodPost:
+		err = s.manager.Resume(name)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateConnection checks the fields a forward added at runtime needs, and refuses the
+// ones running commands or reading local files: those only come from the config file.
+func validateConnection(c model.Connection) error {
+	switch {
+	case c.OnReady != "" || c.OnStop != "":
+		return errors.New("OnReady and OnStop can only be set in the config file")
+	case c.Inject != nil:
+		return errors.New("Inject can only be set in the config file")
+	case c.TLS != nil && (c.TLS.CertFile != "" || c.TLS.KeyFile != ""):
+		return errors.New("TLS.CertFile and TLS.KeyFile can only be set in the config file")
+	case c.LocalSocket != "":
+		return errors.New("LocalSocket can only be set in the config file")
+	case c.ServiceName == "" && c.PodName == "":
+		return errors.New("ServiceName or PodName is required")
+	case c.Namespace == "":
+		return errors.New("Namespace is required")
+	case c.LocalPort <= 0 || c.LocalPort > 65535:
+		return errors.New("LocalPort must be a valid port")
+	case c.RemoteServicePort <= 0 || c.RemoteServicePort > 65535:
+		return errors.New("RemoteServicePort must be a valid port")
+	}
+	return c.CheckLabels()
+}
+
+// writeError maps manager errors to HTTP status codes.
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, manager.ErrUnknownForward):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, manager.ErrForwardExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// checkLoopback refuses to serve an unauthenticated or token-protected API beyond the
+// local machine.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("control APIs only listen on loopback addresses, not %q", host)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Package control exposes a running kpfm instance over a local unix socket so CLI
+// commands can inspect and drive it.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// Server serves the control API of a Manager.
+type Server struct {
+	manager *manager.Manager
+	mux     *http.ServeMux
+}
+
+// NewServer returns a control server for m.
+func NewServer(m *manager.Manager) *Server {
+	s := &Server{manager: m, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	s.mux.HandleFunc("/retry", s.handleRetry)
+	s.mux.HandleFunc("/restart", s.handleRestart)
+	s.mux.HandleFunc("/pause", s.handlePause)
+	s.mux.HandleFunc("/resume", s.handleResume)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/logs", s.handleLogs)
+	s.mux.HandleFunc("/reload", s.handleReload)
+	s.mux.HandleFunc("/switch", s.handleSwitch)
+	return s
+}
+
+// ListenAndServe serves the control API on the unix socket at path until ctx is cancelled.
+// It refuses to replace the socket of another running instance.
+func (s *Server) ListenAndServe(ctx context.Context, path string) error {
+	listener, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	return serveHTTP(ctx, listener, s.mux)
+}
+
+// listenUnix listens on the unix socket path, replacing one left behind by an instance
+// that didn't shut down cleanly but not one another instance still listens on.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another kpfm instance is listening on %s", path)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// serveHTTP serves handler on listener until ctx is cancelled.
+func serveHTTP(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	err := srv.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, StatusResponse{
+		Context:  s.manager.Context(),
+		Forwards: s.manager.Status(),
+	})
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, s.manager.Status())
+}
+
+func (s *Server) handleRetry(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, s.manager.Retry)
+}
+
+func (s *Server) handleRestart(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, s.manager.Restart)
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, s.manager.Pause)
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, s.manager.Resume)
+}
+
+// handleReload reads the kubeconfig again, from the files of the kubeconfig parameter,
+// a KUBECONFIG style list, when it is given.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var paths []string
+	if query := r.URL.Query(); query.Has("kubeconfig") {
+		paths = kube.KubeconfigPaths(query.Get("kubeconfig"))
+	}
+	s.manager.ReloadKubeconfig(paths)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSwitch moves the forwards to the context named in the request right away.
+func (s *Server) handleSwitch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result, err := s.manager.SwitchContext(r.URL.Query().Get("context"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// handleAction applies a manager operation to the forward named in the request.
+func (s *Server) handleAction(w http.ResponseWriter, r *http.Request, action func(name string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := action(r.URL.Query().Get("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEvents writes the recent events as JSON lines and, with follow=1, keeps
+// streaming new ones until the client goes away or the manager stops.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	history, events, unsubscribe := s.manager.SubscribeWithHistory()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, event := range history {
+		enc.Encode(NewEvent(event))
+	}
+	if r.URL.Query().Get("follow") != "1" {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(NewEvent(event)); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleLogs writes the log of the named forward as JSON lines, the last tail ones when
+// tail is set, and with follow=1 keeps streaming new lines until the client goes away.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	buffer, err := s.manager.Logs(query.Get("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	lines, follow, cancel := buffer.Follow()
+	defer cancel()
+	if tail, err := strconv.Atoi(query.Get("tail")); err == nil && tail >= 0 && tail < len(lines) {
+		lines = lines[len(lines)-tail:]
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, line := range lines {
+		enc.Encode(line)
+	}
+	if query.Get("follow") != "1" {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case line := <-follow:
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+		case <-s.manager.Done():
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
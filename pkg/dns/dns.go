@@ -0,0 +1,134 @@
+// Package dns runs a small DNS stub that resolves the in-cluster names of forwarded
+// services to the loopback address.
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+const (
+	DefaultListen        = model.DefaultDNSListen
+	DefaultClusterDomain = model.DefaultClusterDomain
+
+	// Short TTL so clients notice quickly when a forward goes away.
+	ttl = 5
+)
+
+// Lookup reports whether a service in namespace is forwarded.
+type Lookup func(service, namespace string) bool
+
+// Server answers A queries for <service>.<namespace>.svc.<cluster domain> names.
+// Unknown names in the cluster domain get NXDOMAIN; names outside of it are refused.
+type Server struct {
+	suffix string
+	lookup Lookup
+}
+
+// NewServer returns a stub for clusterDomain (DefaultClusterDomain when empty).
+func NewServer(clusterDomain string, lookup Lookup) *Server {
+	if clusterDomain == "" {
+		clusterDomain = DefaultClusterDomain
+	}
+	return &Server{
+		suffix: ".svc." + strings.ToLower(strings.Trim(clusterDomain, ".")) + ".",
+		lookup: lookup,
+	}
+}
+
+// ListenAndServe answers queries on the UDP address addr until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = DefaultListen
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 512)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		resp, err := s.answer(buf[:n])
+		if err != nil {
+			// Not a query we can parse; drop it like most resolvers do.
+			continue
+		}
+		conn.WriteTo(resp, peer)
+	}
+}
+
+// answer builds the response to a single DNS query.
+func (s *Server) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               header.ID,
+			Response:         true,
+			OpCode:           header.OpCode,
+			RecursionDesired: header.RecursionDesired,
+		},
+		Questions: []dnsmessage.Question{question},
+	}
+
+	service, namespace, ok := s.split(question.Name.String())
+	switch {
+	case !ok:
+		resp.RCode = dnsmessage.RCodeRefused
+	case !s.lookup(service, namespace):
+		resp.Authoritative = true
+		resp.RCode = dnsmessage.RCodeNameError
+	default:
+		resp.Authoritative = true
+		// Other record types get an empty answer, so clients fall back to the A record.
+		if question.Type == dnsmessage.TypeA && question.Class == dnsmessage.ClassINET {
+			resp.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{
+					Name:  question.Name,
+					Type:  dnsmessage.TypeA,
+					Class: dnsmessage.ClassINET,
+					TTL:   ttl,
+				},
+				Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			}}
+		}
+	}
+	return resp.Pack()
+}
+
+// split extracts the service and namespace of a name in the cluster domain.
+func (s *Server) split(name string) (service, namespace string, ok bool) {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, s.suffix) {
+		return "", "", false
+	}
+	labels := strings.Split(strings.TrimSuffix(name, s.suffix), ".")
+	if len(labels) != 2 {
+		return "", "", false
+	}
+	return labels[0], labels[1], true
+}
@@ -0,0 +1,94 @@
+// Package endpoints renders the local endpoints of the active forwards for other tools:
+// as environment variables, through a template, or into a file kept up to date.
+package endpoints
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// Vars returns KPFM_<NAME>_{HOST,PORT,ADDR} variables for every forward, or
+// KPFM_<NAME>_{SOCKET,ADDR} for those served on a LocalSocket.
+func Vars(forwards []manager.ForwardStatus) []string {
+	var vars []string
+	for _, f := range forwards {
+		prefix := "KPFM_" + VarName(f.Name) + "_"
+		if socket := f.Connection.LocalSocket; socket != "" {
+			vars = append(vars, prefix+"SOCKET="+socket, prefix+"ADDR="+socket)
+			continue
+		}
+		host := f.Connection.LocalHost()
+		port := strconv.Itoa(f.Connection.LocalPort)
+		vars = append(vars,
+			prefix+"HOST="+host,
+			prefix+"PORT="+port,
+			prefix+"ADDR="+net.JoinHostPort(host, port),
+		)
+	}
+	return vars
+}
+
+// VarName upper-cases name and replaces characters not allowed in variable names.
+func VarName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// Data is what templates are executed with.
+type Data struct {
+	Context  string
+	Forwards []manager.ForwardStatus
+}
+
+// Forward returns the forward called name, or nil, e.g. {{with .Forward "postgresql"}}.
+func (d Data) Forward(name string) *manager.ForwardStatus {
+	for i := range d.Forwards {
+		if d.Forwards[i].Name == name {
+			return &d.Forwards[i]
+		}
+	}
+	return nil
+}
+
+// Active returns the forwards that accept connections: ready ones and idle ones, whose
+// local port stays open.
+func Active(forwards []manager.ForwardStatus) []manager.ForwardStatus {
+	var active []manager.ForwardStatus
+	for _, f := range forwards {
+		if f.State == manager.StateReady || f.State == manager.StateIdle {
+			active = append(active, f)
+		}
+	}
+	return active
+}
+
+// ParseTemplate parses a template over Data. Besides the built-in functions it offers
+// varName, see VarName, and addr, the host:port or socket a forward listens on.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(template.FuncMap{
+		"varName": VarName,
+		"addr": func(f manager.ForwardStatus) string {
+			return f.Connection.LocalAddr()
+		},
+	}).Parse(text)
+}
+
+// Execute renders tmpl with d.
+func Execute(tmpl *template.Template, d Data) ([]byte, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, d)
+	return buf.Bytes(), err
+}
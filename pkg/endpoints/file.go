@@ -0,0 +1,108 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// Source is what WriteFile watches: the manager of the running instance.
+type Source interface {
+	Context() string
+	Status() []manager.ForwardStatus
+	Subscribe() (<-chan manager.Event, func())
+}
+
+// WriteFile keeps path up to date with the endpoints of the active forwards of m until
+// ctx is cancelled, rewriting it whenever a forward comes up or goes down. Without a
+// template the file holds the Vars as KEY=value lines, which direnv's dotenv and
+// docker-compose's env_file read.
+func WriteFile(ctx context.Context, m Source, path, templatePath string) error {
+	if path == "" {
+		return errors.New("no Path set")
+	}
+	path, err := config.ExpandHome(path)
+	if err != nil {
+		return err
+	}
+	var tmpl *template.Template
+	if templatePath != "" {
+		if templatePath, err = config.ExpandHome(templatePath); err != nil {
+			return err
+		}
+		text, err := ioutil.ReadFile(templatePath)
+		if err != nil {
+			return err
+		}
+		if tmpl, err = ParseTemplate(filepath.Base(templatePath), string(text)); err != nil {
+			return err
+		}
+	}
+
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	var last []byte
+	for {
+		content, err := render(tmpl, m.Context(), Active(m.Status()))
+		if err != nil {
+			log.Printf("Cannot render %s: %v", path, err)
+		} else if last == nil || string(content) != string(last) {
+			if err := writeAtomic(path, content); err != nil {
+				log.Printf("Cannot write %s: %v", path, err)
+			} else {
+				last = content
+			}
+		}
+
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// render returns the file content for the active forwards.
+func render(tmpl *template.Template, kubeContext string, forwards []manager.ForwardStatus) ([]byte, error) {
+	if tmpl != nil {
+		return Execute(tmpl, Data{Context: kubeContext, Forwards: forwards})
+	}
+	var b strings.Builder
+	for _, v := range Vars(forwards) {
+		fmt.Fprintln(&b, v)
+	}
+	return []byte(b.String()), nil
+}
+
+// writeAtomic replaces path with content, so readers never see a partial file.
+func writeAtomic(path string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
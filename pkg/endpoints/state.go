@@ -0,0 +1,99 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// State is the content of the state file: the running instance and all its forwards.
+type State struct {
+	PID      int // of the kpfm process, to tell a state file left behind by a crash
+	Context  string
+	Updated  time.Time
+	Forwards []StateForward
+}
+
+// StateForward describes one forward of the state file.
+type StateForward struct {
+	Name        string
+	Context     string
+	Target      string
+	Namespace   string
+	Pod         string `json:",omitempty"`
+	LocalPort   int    `json:",omitempty"`
+	LocalSocket string `json:",omitempty"`
+	Address     string // where clients connect: host:port or the socket path
+	State       manager.State
+	Since       time.Time
+	Restarts    int
+	LastError   string `json:",omitempty"`
+}
+
+// NewState returns the state of the forwards of an instance on kubeContext.
+func NewState(kubeContext string, forwards []manager.ForwardStatus) State {
+	state := State{PID: os.Getpid(), Context: kubeContext, Forwards: []StateForward{}}
+	for _, f := range forwards {
+		state.Forwards = append(state.Forwards, StateForward{
+			Name:        f.Name,
+			Context:     f.Context,
+			Target:      f.Connection.Target(),
+			Namespace:   f.Connection.Namespace,
+			Pod:         f.Pod,
+			LocalPort:   f.Connection.LocalPort,
+			LocalSocket: f.Connection.LocalSocket,
+			Address:     f.Connection.LocalAddr(),
+			State:       f.State,
+			Since:       f.Since,
+			Restarts:    f.Restarts,
+			LastError:   f.LastError,
+		})
+	}
+	return state
+}
+
+// WriteState keeps the JSON state file at path up to date with every forward of m,
+// rewriting it atomically whenever one changes, until ctx is cancelled or m stops. The
+// file is removed then, so that it only exists while kpfm runs.
+func WriteState(ctx context.Context, m Source, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	var last []byte
+	for {
+		state := NewState(m.Context(), m.Status())
+		// Compared without the time of the update.
+		current, _ := json.Marshal(state)
+		if string(current) != string(last) {
+			state.Updated = time.Now()
+			content, err := json.MarshalIndent(state, "", "  ")
+			if err == nil {
+				err = writeAtomic(path, append(content, '\n'))
+			}
+			if err != nil {
+				log.Printf("Cannot write %s: %v", path, err)
+			} else {
+				last = current
+			}
+		}
+
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Package eventlog appends the lifecycle events of a running instance to a file as JSON
+// lines, so tunnels that dropped can be looked into after the fact.
+package eventlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// Log is an append-only event file rotated once it grows past maxSize, keeping maxFiles
+// rotated files as path.1 (the newest) to path.<maxFiles>.
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// Open opens or creates the event log at path.
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	l := &Log{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Record writes every event received until events is closed, then closes the log.
+func (l *Log) Record(events <-chan manager.Event) {
+	defer l.file.Close()
+	for event := range events {
+		if err := l.Write(control.NewEvent(event)); err != nil {
+			log.Printf("Cannot write the event log: %v", err)
+		}
+	}
+}
+
+// Write appends an event as one JSON line, rotating the file first if it would grow
+// past the size limit.
+func (l *Log) Write(event control.Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("cannot rotate %s: %v", l.path, err)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts a new file.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
@@ -0,0 +1,56 @@
+// Package hooks runs the lifecycle hook commands configured on connections.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// Run executes command through the shell with KPFM_* variables describing the forward
+// called name and the event, and waits for it to finish or ctx to be cancelled.
+func Run(ctx context.Context, command, event, kubeContext, name string, connection model.Connection) error {
+	cmd := shell(ctx, command, event, kubeContext, name, connection)
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %q failed: %v", event, command, err)
+	}
+	return nil
+}
+
+// Output executes command like Run and returns what it printed, trimmed of surrounding
+// whitespace.
+func Output(ctx context.Context, command, event, kubeContext, name string, connection model.Connection) (string, error) {
+	out, err := shell(ctx, command, event, kubeContext, name, connection).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s command %q failed: %v", event, command, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func shell(ctx context.Context, command, event, kubeContext, name string, connection model.Connection) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"KPFM_EVENT="+event,
+		"KPFM_CONTEXT="+kubeContext,
+		"KPFM_NAME="+name,
+		"KPFM_NAMESPACE="+connection.Namespace,
+		"KPFM_SERVICE="+connection.ServiceName,
+		"KPFM_POD="+connection.PodName,
+		"KPFM_LOCAL_PORT="+strconv.Itoa(connection.LocalPort),
+		"KPFM_REMOTE_PORT="+strconv.Itoa(connection.RemoteServicePort),
+	)
+	return cmd
+}
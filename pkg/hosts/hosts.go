@@ -0,0 +1,119 @@
+// Package hosts maintains a kpfm-owned block of loopback entries in the system hosts file.
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	beginMarker = "# BEGIN kpfm"
+	endMarker   = "# END kpfm"
+	address     = "127.0.0.1"
+)
+
+// Path is the hosts file kpfm edits.
+var Path = defaultPath()
+
+func defaultPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// Set replaces kpfm's block in the hosts file with entries pointing names at 127.0.0.1.
+// An empty names removes the block; lines outside of it are left untouched.
+func Set(names []string) error {
+	content, err := os.ReadFile(Path)
+	if err != nil {
+		return err
+	}
+
+	lines := strip(strings.Split(strings.TrimRight(string(content), "\n"), "\n"))
+	if len(names) > 0 {
+		sorted := append([]string(nil), names...)
+		sort.Strings(sorted)
+		lines = append(lines, beginMarker)
+		for _, name := range sorted {
+			lines = append(lines, address+"\t"+name)
+		}
+		lines = append(lines, endMarker)
+	}
+
+	updated := strings.Join(lines, "\n") + "\n"
+	if updated == string(content) {
+		return nil
+	}
+	if err := writeAtomic(Path, []byte(updated)); err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("cannot update %s, run kpfm with permission to write it: %v", Path, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeAtomic replaces path with content through a temporary file in the same directory,
+// keeping its mode, so resolvers never read a truncated hosts file. A hosts file that
+// can't be replaced, like the bind-mounted one of containers, is written in place.
+func writeAtomic(path string, content []byte) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) {
+		return os.WriteFile(path, content, info.Mode().Perm())
+	}
+	return err
+}
+
+// strip removes kpfm's block, including blocks left behind by an instance that crashed.
+func strip(lines []string) []string {
+	var kept []string
+	inBlock := false
+	for _, line := range lines {
+		switch strings.TrimSpace(line) {
+		case beginMarker:
+			inBlock = true
+			continue
+		case endMarker:
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,133 @@
+// Package instance makes sure only one kpfm runs against a given config file.
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockedError is returned when another instance holds the lock.
+type LockedError struct {
+	PID  int
+	Path string
+}
+
+func (e *LockedError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("another kpfm instance (pid %d) is already running with this config (lock %s)", e.PID, e.Path)
+	}
+	return fmt.Sprintf("another kpfm instance is already running with this config (lock %s)", e.Path)
+}
+
+// Lock is a held PID/lock file.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes the lock at path and records the current PID in it. It returns a
+// *LockedError when another live instance holds it.
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, &LockedError{PID: readPID(path), Path: path}
+	}
+
+	if err := file.Truncate(0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+	return &Lock{file: file}, nil
+}
+
+// Takeover stops the instance holding the lock at path and acquires it, waiting up to
+// timeout for the other instance to exit.
+func Takeover(path string, timeout time.Duration) (*Lock, error) {
+	lock, err := Acquire(path)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		return lock, err
+	}
+	if locked.PID <= 0 {
+		return nil, err
+	}
+
+	if err := terminate(locked.PID); err != nil {
+		return nil, fmt.Errorf("cannot stop kpfm instance %d: %v", locked.PID, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(200 * time.Millisecond)
+		lock, err = Acquire(path)
+		if !errors.As(err, &locked) {
+			return lock, err
+		}
+	}
+	return nil, fmt.Errorf("kpfm instance %d did not exit within %s", locked.PID, timeout)
+}
+
+// Release clears the PID file and releases the lock. The file is left in place: removing
+// it would let a new instance lock the removed file while another one creates a new one.
+func (l *Lock) Release() error {
+	l.file.Truncate(0)
+	unlockFile(l.file)
+	return l.file.Close()
+}
+
+func readPID(path string) int {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Spawn starts kpfm with args as a background process detached from the terminal,
+// appending its output to logPath.
+func Spawn(args []string, logPath string) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
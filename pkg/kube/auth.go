@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// contextAuth is who the clients of a context authenticate and act as, overriding the
+// kubeconfig user.
+type contextAuth struct {
+	impersonate       string
+	impersonateGroups []string
+	credentials       *model.Credentials
+}
+
+// auths holds the configured auth overrides per kube context.
+var auths = struct {
+	sync.Mutex
+	byContext map[string]contextAuth
+}{byContext: make(map[string]contextAuth)}
+
+// SetAuth registers the impersonation and credential overrides of every configured
+// context, so clients for those contexts use them instead of the kubeconfig user's.
+func SetAuth(cfg *model.Contexts) {
+	auths.Lock()
+	defer auths.Unlock()
+	for _, ctx := range cfg.Contexts {
+		if ctx.Impersonate != "" || len(ctx.ImpersonateGroups) > 0 || ctx.Credentials != nil {
+			auths.byContext[ctx.Name] = contextAuth{
+				impersonate:       ctx.Impersonate,
+				impersonateGroups: ctx.ImpersonateGroups,
+				credentials:       ctx.Credentials,
+			}
+		}
+	}
+}
+
+// applyAuth sets the overrides registered for kubeContext on config.
+func applyAuth(kubeContext string, c *rest.Config) error {
+	auths.Lock()
+	auth, ok := auths.byContext[kubeContext]
+	auths.Unlock()
+	if !ok {
+		return nil
+	}
+	if auth.impersonate == "" && len(auth.impersonateGroups) > 0 {
+		return fmt.Errorf("context %s sets ImpersonateGroups without Impersonate", kubeContext)
+	}
+
+	if creds := auth.credentials; creds != nil {
+		tokenFile, err := config.ExpandHome(creds.TokenFile)
+		if err != nil {
+			return err
+		}
+		certFile, err := config.ExpandHome(creds.ClientCertFile)
+		if err != nil {
+			return err
+		}
+		keyFile, err := config.ExpandHome(creds.ClientKeyFile)
+		if err != nil {
+			return err
+		}
+		// Drop every credential of the kubeconfig user, so the override is the only one
+		// sent and an exec or auth provider plugin doesn't run.
+		c.BearerToken, c.BearerTokenFile = creds.Token, tokenFile
+		c.Username, c.Password = "", ""
+		c.ExecProvider, c.AuthProvider, c.AuthConfigPersister = nil, nil, nil
+		c.TLSClientConfig.CertFile, c.TLSClientConfig.KeyFile = certFile, keyFile
+		c.TLSClientConfig.CertData, c.TLSClientConfig.KeyData = nil, nil
+	}
+	c.Impersonate = rest.ImpersonationConfig{UserName: auth.impersonate, Groups: auth.impersonateGroups}
+	return nil
+}
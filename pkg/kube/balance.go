@@ -0,0 +1,307 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+const (
+	balanceSyncInterval = 10 * time.Second
+	balanceDialTimeout  = 5 * time.Second
+)
+
+// balancedForwarder serves a local port by spreading accepted connections round-robin
+// over forwards to every ready pod of a service, following the pods as they come and go.
+type balancedForwarder struct {
+	ctx        context.Context
+	config     *rest.Config
+	clientset  kubernetes.Interface
+	connection model.Connection
+	stopChan   <-chan struct{}
+	readyChan  chan struct{}
+	out        io.Writer
+
+	mu       sync.Mutex
+	backends map[string]*backend // by pod name
+	next     int
+}
+
+// backend is a forward to one pod, listening on an internal local port.
+type backend struct {
+	pod  string
+	port int
+	stop chan struct{}
+	done chan struct{} // closed once the forward ended
+}
+
+// newBalancedForwarder prepares a load-balancing forwarder for a service connection.
+func newBalancedForwarder(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, connection model.Connection, stopChan, readyChan chan struct{}, out io.Writer) (Forwarder, error) {
+	if connection.ServiceName == "" {
+		return nil, errors.New("LoadBalance needs a ServiceName")
+	}
+	return &balancedForwarder{
+		ctx:        ctx,
+		config:     config,
+		clientset:  clientset,
+		connection: connection,
+		stopChan:   stopChan,
+		readyChan:  readyChan,
+		out:        out,
+		backends:   make(map[string]*backend),
+	}, nil
+}
+
+// ForwardPorts balances local connections until stopChan is closed, or until the service
+// has no ready pod left.
+func (f *balancedForwarder) ForwardPorts() error {
+	defer f.closeBackends()
+	if err := f.sync(); err != nil {
+		return err
+	}
+
+	var listeners []net.Listener
+	for _, address := range f.connection.BindAddresses() {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(f.connection.LocalPort)))
+		if err != nil {
+			fmt.Fprintf(f.out, "Unable to listen on %s:%d: %v\n", address, f.connection.LocalPort, err)
+			continue
+		}
+		fmt.Fprintf(f.out, "Balancing from %s -> pods %s\n", listener.Addr(), f.PodNames())
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("unable to listen on any of the requested ports: [%d:%d]", f.connection.LocalPort, f.connection.RemoteServicePort)
+	}
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	for _, listener := range listeners {
+		go f.accept(listener)
+	}
+	close(f.readyChan)
+
+	ticker := time.NewTicker(balanceSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopChan:
+			return nil
+		case <-ticker.C:
+			if err := f.sync(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// PodNames returns the pods currently balanced over, comma separated.
+func (f *balancedForwarder) PodNames() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.backends))
+	for name := range f.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// sync opens forwards to ready pods that have none and closes those of pods that are
+// gone, not ready or whose forward ended. A failed lookup keeps the current forwards.
+func (f *balancedForwarder) sync() error {
+	pods, err := ServicePods(f.ctx, f.clientset, f.connection.Namespace, f.connection.ServiceName)
+	if err != nil && !errors.Is(err, ErrNoPods) {
+		if f.live() > 0 {
+			fmt.Fprintf(f.out, "Cannot list the pods of %s/%s: %v\n", f.connection.Namespace, f.connection.ServiceName, err)
+			return nil
+		}
+		return err
+	}
+
+	ready := make(map[string]bool)
+	for i := range pods {
+		if podReady(&pods[i]) && pods[i].DeletionTimestamp == nil && crashLooping(&pods[i], time.Now()) == "" {
+			ready[pods[i].Name] = true
+		}
+	}
+
+	f.mu.Lock()
+	for name, b := range f.backends {
+		if !ready[name] || b.ended() {
+			close(b.stop)
+			delete(f.backends, name)
+		}
+	}
+	var missing []string
+	for name := range ready {
+		if _, ok := f.backends[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, name := range missing {
+		b, err := f.open(name)
+		if err != nil {
+			fmt.Fprintf(f.out, "Cannot forward to pod %s/%s: %v\n", f.connection.Namespace, name, err)
+			continue
+		}
+		f.mu.Lock()
+		f.backends[name] = b
+		f.mu.Unlock()
+	}
+
+	if f.live() == 0 {
+		return fmt.Errorf("no ready pods to balance over for service %s/%s", f.connection.Namespace, f.connection.ServiceName)
+	}
+	return nil
+}
+
+// open starts a forward to a pod on an internal port and waits until it is up.
+func (f *balancedForwarder) open(pod string) (*backend, error) {
+	port, err := freeLocalPort()
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{pod: pod, port: port, stop: make(chan struct{}), done: make(chan struct{})}
+	ready := make(chan struct{})
+	fw, err := newForwarder(f.config, f.clientset, f.connection.Namespace, pod, loopbackAddresses, port, f.connection.RemoteServicePort, b.stop, ready, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		err := fw.ForwardPorts()
+		close(b.done)
+		failed <- err
+	}()
+
+	timer := time.NewTimer(tunnelOpenTimeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return b, nil
+	case err := <-failed:
+		if err == nil {
+			err = errors.New("forward closed")
+		}
+		return nil, err
+	case <-timer.C:
+		close(b.stop)
+		return nil, errors.New("timed out waiting for the forward")
+	case <-f.stopChan:
+		close(b.stop)
+		return nil, errors.New("forward stopped")
+	}
+}
+
+func (b *backend) ended() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// live counts the backends whose forward is still up.
+func (f *balancedForwarder) live() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, b := range f.backends {
+		if !b.ended() {
+			n++
+		}
+	}
+	return n
+}
+
+// pick returns the live backends in round-robin order, starting with the one the next
+// connection should use.
+func (f *balancedForwarder) pick() []*backend {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var live []*backend
+	for _, b := range f.backends {
+		if !b.ended() {
+			live = append(live, b)
+		}
+	}
+	if len(live) == 0 {
+		return nil
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].pod < live[j].pod })
+	start := f.next % len(live)
+	f.next++
+	return append(live[start:], live[:start]...)
+}
+
+func (f *balancedForwarder) closeBackends() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, b := range f.backends {
+		close(b.stop)
+		delete(f.backends, name)
+	}
+}
+
+func (f *balancedForwarder) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener is closed when the forwarder stops.
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// handle pipes a local connection to the next backend, trying the others if it can't
+// be reached.
+func (f *balancedForwarder) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var upstream net.Conn
+	for _, b := range f.pick() {
+		var err error
+		upstream, err = net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(b.port)), balanceDialTimeout)
+		if err == nil {
+			fmt.Fprintf(f.out, "Handling connection for %d on pod %s\n", f.connection.LocalPort, b.pod)
+			break
+		}
+		fmt.Fprintf(f.out, "Cannot reach the forward to pod %s: %v\n", b.pod, err)
+	}
+	if upstream == nil {
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,135 @@
+package kube
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// cachedClient is a rest.Config and Clientset built from a given kubeconfig revision.
+type cachedClient struct {
+	config    *rest.Config
+	clientset *kubernetes.Clientset
+	modTime   time.Time
+	tunnel    *sshTunnel // the jump host tunnel the client goes through, if any
+}
+
+// clients caches one client per requested context so forwards share the parsed
+// kubeconfig, transport, and exec auth plugin state.
+var clients = struct {
+	sync.Mutex
+	byContext map[string]cachedClient
+}{byContext: make(map[string]cachedClient)}
+
+// loadingRules returns the standard client-go kubeconfig loading rules: the files listed
+// in $KUBECONFIG (merged in order) or ~/.kube/config, unless a reload set other files.
+func loadingRules() *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if paths, ok := kubeconfigPrecedence(); ok {
+		rules.Precedence = paths
+	}
+	// Never rewrite the user's files.
+	rules.MigrationRules = nil
+	return rules
+}
+
+// loadKubeconfig returns the merged kubeconfig.
+func loadKubeconfig() (*clientcmdapi.Config, error) {
+	config, err := loadingRules().Load()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load kubeconfig: %v", err)
+	}
+	return config, nil
+}
+
+// kubeconfigModTime returns the latest modification time of the kubeconfig files.
+func kubeconfigModTime() time.Time {
+	var latest time.Time
+	for _, path := range loadingRules().GetLoadingPrecedence() {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// NewClientset builds the rest.Config and Clientset for kubeContext, or for the
+// kubeconfig's current context when kubeContext is empty. Inside a pod without a
+// kubeconfig, the empty and InClusterContext contexts use the service account.
+func NewClientset(kubeContext string) (*rest.Config, *kubernetes.Clientset, error) {
+	config, clientset, _, err := newClient(kubeContext)
+	return config, clientset, err
+}
+
+func newClient(kubeContext string) (*rest.Config, *kubernetes.Clientset, *sshTunnel, error) {
+	config, err := inClusterConfig(kubeContext)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if config != nil {
+		if err := applyAuth(InClusterContext, config); err != nil {
+			return nil, nil, nil, err
+		}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return config, clientset, nil, nil
+	}
+
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	)
+	config, err = loader.ClientConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	contextName := kubeContext
+	if contextName == "" {
+		if raw, err := loader.RawConfig(); err == nil {
+			contextName = raw.CurrentContext
+		}
+	}
+	if err := applyAuth(contextName, config); err != nil {
+		return nil, nil, nil, err
+	}
+	tunnel, err := routeThroughJumpHost(contextName, config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return config, clientset, tunnel, nil
+}
+
+// Clientset returns the cached rest.Config and Clientset for kubeContext, building them
+// with NewClientset on first use, after a kubeconfig file has changed, or after its
+// jump host tunnel died.
+func Clientset(kubeContext string) (*rest.Config, *kubernetes.Clientset, error) {
+	modTime := kubeconfigModTime()
+
+	clients.Lock()
+	defer clients.Unlock()
+
+	if cached, ok := clients.byContext[kubeContext]; ok && cached.modTime.Equal(modTime) && (cached.tunnel == nil || cached.tunnel.alive()) {
+		return cached.config, cached.clientset, nil
+	}
+
+	config, clientset, tunnel, err := newClient(kubeContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	clients.byContext[kubeContext] = cachedClient{config: config, clientset: clientset, modTime: modTime, tunnel: tunnel}
+	return config, clientset, nil
+}
@@ -1,50 +1,353 @@
 package kube
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
 )
 
-// getCurrentContext reads the current kubecontext from the kubeconfig file.
+// getCurrentContext reads the current kubecontext from the kubeconfig files.
 func GetCurrentContext() (string, error) {
-	// Find the kubeconfig file.
-	kubeconfig := os.Getenv("KUBECONFIG")
-	if kubeconfig == "" {
-		if home := homedir.HomeDir(); home != "" {
-			kubeconfig = home + "/.kube/config"
-		} else {
-			return "", fmt.Errorf("cannot find kubeconfig file")
-		}
+	if inCluster() {
+		return InClusterContext, nil
 	}
-
-	// Load the kubeconfig file to get the config.
-	config, err := clientcmd.LoadFromFile(kubeconfig)
+	config, err := loadKubeconfig()
 	if err != nil {
-		return "", fmt.Errorf("cannot load kubeconfig file: %v", err)
+		return "", err
 	}
 
 	// Return the current context name.
 	return config.CurrentContext, nil
 }
 
-// watchContextChanges periodically checks for changes in the current kubecontext and notifies via a channel.
-func WatchContextChanges(notifyChan chan<- string, checkInterval time.Duration) {
-	var lastContext string
+// SetCurrentContext makes kubeContext the current context of the kubeconfig files, as
+// kubectl config use-context does: in the file that sets the current context, or else in
+// the first one. Only the top-level current-context of the file is rewritten, and the
+// file is replaced at once so kubectl and kpfm never read it half written.
+func SetCurrentContext(kubeContext string) error {
+	if inCluster() {
+		return errors.New("running in a pod without a kubeconfig, there is no current context to set")
+	}
+	config, err := loadKubeconfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Contexts[kubeContext]; !ok {
+		return fmt.Errorf("no context %s in the kubeconfig", kubeContext)
+	}
+
+	rules := loadingRules()
+	path := rules.GetDefaultFilename()
+	for _, file := range rules.GetLoadingPrecedence() {
+		if c, err := clientcmd.LoadFromFile(file); err == nil && c.CurrentContext != "" {
+			path = file
+			break
+		}
+	}
+	// Replace the file a kubeconfig symlink points to, not the symlink.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	mode := os.FileMode(0o600)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	data, err = setCurrentContext(data, kubeContext)
+	if err != nil {
+		return fmt.Errorf("cannot update %s: %v", path, err)
+	}
+	return writeAtomic(path, data, mode)
+}
+
+// setCurrentContext sets the top-level current-context of the kubeconfig data. The line
+// of the key is replaced, or one appended, leaving the rest of the file as it is; JSON
+// and other flow-style files are encoded again instead.
+func setCurrentContext(data []byte, kubeContext string) ([]byte, error) {
+	value, err := yaml.Marshal(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	line := append([]byte("current-context: "), bytes.TrimSpace(value)...)
+	if len(bytes.TrimSpace(data)) == 0 {
+		return append(line, '\n'), nil
+	}
 
-	for range time.Tick(checkInterval) {
-		currentContext, err := GetCurrentContext()
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("not a kubeconfig")
+	}
+	root := doc.Content[0]
+	var key, current *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "current-context" {
+			key, current = root.Content[i], root.Content[i+1]
+		}
+	}
+
+	if root.Style&yaml.FlowStyle == 0 {
+		switch {
+		case key == nil:
+			if !bytes.HasSuffix(data, []byte("\n")) {
+				data = append(data, '\n')
+			}
+			return append(append(data, line...), '\n'), nil
+		case key.Column == 1 && current.Kind == yaml.ScalarNode && current.Line == key.Line &&
+			current.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0:
+			lines := bytes.SplitAfter(data, []byte("\n"))
+			end := []byte("\n")
+			if !bytes.HasSuffix(lines[key.Line-1], end) {
+				end = nil
+			}
+			lines[key.Line-1] = append(line, end...)
+			return bytes.Join(lines, nil), nil
+		}
+	}
+
+	if key == nil {
+		key = &yaml.Node{Kind: yaml.ScalarNode, Value: "current-context"}
+		current = &yaml.Node{Kind: yaml.ScalarNode}
+		root.Content = append(root.Content, key, current)
+	}
+	current.Kind, current.Style, current.Tag, current.Value = yaml.ScalarNode, 0, "", kubeContext
+	root.Style = 0
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeAtomic replaces path with data through a temporary file in the same directory.
+func writeAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ContextTarget is what a kube context points at. Generated kubeconfigs may keep a
+// context's name while changing its cluster, user or namespace.
+type ContextTarget struct {
+	Cluster   string
+	Server    string
+	User      string
+	Namespace string
+}
+
+// ContextChange is a change of the current kube context, or of what it points at.
+type ContextChange struct {
+	Name   string
+	Target ContextTarget
+}
+
+// CurrentContextTarget returns the current kube context and what it points at.
+func CurrentContextTarget() (ContextChange, error) {
+	if inCluster() {
+		return ContextChange{Name: InClusterContext}, nil
+	}
+	config, err := loadKubeconfig()
+	if err != nil {
+		return ContextChange{}, err
+	}
+	return ContextChange{Name: config.CurrentContext, Target: targetOf(config, config.CurrentContext)}, nil
+}
+
+// TargetOf returns what kubeContext points at.
+func TargetOf(kubeContext string) (ContextTarget, error) {
+	if inCluster() {
+		return ContextTarget{}, nil
+	}
+	config, err := loadKubeconfig()
+	if err != nil {
+		return ContextTarget{}, err
+	}
+	return targetOf(config, kubeContext), nil
+}
+
+func targetOf(config *clientcmdapi.Config, kubeContext string) ContextTarget {
+	ctx, ok := config.Contexts[kubeContext]
+	if !ok {
+		return ContextTarget{}
+	}
+	target := ContextTarget{Cluster: ctx.Cluster, User: ctx.AuthInfo, Namespace: ctx.Namespace}
+	if cluster, ok := config.Clusters[ctx.Cluster]; ok {
+		target.Server = cluster.Server
+	}
+	return target
+}
+
+// ListContexts returns the names of all contexts defined in the kubeconfig files.
+func ListContexts() ([]string, error) {
+	if inCluster() {
+		return []string{InClusterContext}, nil
+	}
+	config, err := loadKubeconfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// kubeconfigSettle is how long writes to the kubeconfig files must pause before the
+// current context is read again, as kubectl writes through a lock and a rename.
+const kubeconfigSettle = 100 * time.Millisecond
+
+// WatchContextChanges checks for changes of the current kubecontext, or of the cluster,
+// user or namespace it points at, every checkInterval and notifies via a channel until
+// ctx is cancelled. With watchFiles it also checks as soon as the kubeconfig files are
+// written, polling only as a fallback.
+func WatchContextChanges(ctx context.Context, notifyChan chan<- ContextChange, checkInterval time.Duration, watchFiles bool) {
+	// Read the context right away so a change within the first interval isn't missed.
+	last, _ := CurrentContextTarget()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	// The files are watched again after a reload, which may have changed the list.
+	var written <-chan struct{}
+	stopWatching := func() {}
+	watch := func() {
+		stopWatching()
+		if !watchFiles || inCluster() {
+			return
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		var err error
+		if written, err = watchKubeconfig(watchCtx); err != nil {
+			logging.Printf("Cannot watch the kubeconfig files, polling every %s instead: %v", checkInterval, err)
+		}
+		stopWatching = cancel
+	}
+	watch()
+	defer func() { stopWatching() }()
+	reloaded := kubeconfigReloaded()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-written:
+		case <-reloaded:
+			reloaded = kubeconfigReloaded()
+			watch()
+		}
+
+		current, err := CurrentContextTarget()
 		if err != nil {
 			fmt.Printf("Error getting current context: %v\n", err)
 			continue
 		}
 
-		if currentContext != lastContext && lastContext != "" {
-			notifyChan <- currentContext
+		if current != last && last.Name != "" {
+			select {
+			case notifyChan <- current:
+			case <-ctx.Done():
+				return
+			}
 		}
-		lastContext = currentContext
+		last = current
 	}
 }
+
+// watchKubeconfig returns a channel receiving a value once writes to any of the
+// kubeconfig files settle. It watches their directories, so files replaced by a rename
+// or created later are followed too.
+func watchKubeconfig(ctx context.Context) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, path := range loadingRules().GetLoadingPrecedence() {
+		path = filepath.Clean(path)
+		files[path] = true
+		dirs[filepath.Dir(path)] = true
+	}
+	// A missing directory is skipped, as long as one of them can be watched.
+	var watched int
+	var lastErr error
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			logging.Verbosef("Cannot watch %s: %v", dir, err)
+			lastErr = err
+			continue
+		}
+		watched++
+	}
+	if watched == 0 {
+		watcher.Close()
+		return nil, lastErr
+	}
+
+	written := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		settle := time.NewTimer(0)
+		<-settle.C
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.Events:
+				if files[filepath.Clean(event.Name)] {
+					settle.Reset(kubeconfigSettle)
+				}
+			case err := <-watcher.Errors:
+				logging.Verbosef("Watching the kubeconfig files: %v", err)
+			case <-settle.C:
+				select {
+				case written <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return written, nil
+}
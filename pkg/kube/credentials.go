@@ -0,0 +1,103 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// credentialCheckTimeout bounds the request made to tell a rejected WebSocket upgrade
+// apart from other handshake failures.
+const credentialCheckTimeout = 10 * time.Second
+
+// errCredentialsRejected ends a WebSocket forward whose streams are refused because the
+// API server rejected the credentials.
+var errCredentialsRejected = errors.New("credentials rejected")
+
+// credentialErrors are fragments of the messages client-go, exec plugins and the API
+// server produce when credentials are missing, expired or rejected.
+var credentialErrors = []string{
+	"unauthorized",
+	"provide credentials",
+	"getting credentials",
+	"exec plugin",
+	"token has expired",
+	"token is expired",
+	"certificate has expired",
+}
+
+// IsCredentialError reports whether err comes from credentials the API server rejected
+// or that could not be refreshed, e.g. an expired OIDC token from an exec plugin.
+func IsCredentialError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errCredentialsRejected) || apierrors.IsUnauthorized(err) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range credentialErrors {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// resetClient drops the cached client of kubeContext so the next forward rebuilds its
+// transport and runs the credential plugins again.
+func resetClient(kubeContext string) {
+	clients.Lock()
+	defer clients.Unlock()
+	delete(clients.byContext, kubeContext)
+}
+
+// credentialsRejected reports whether a failed WebSocket dial was caused by the API
+// server rejecting the credentials. The handshake error does not carry the status, so
+// a rejected upgrade is followed by a plain authenticated request, which also lets an
+// exec plugin see the 401 and refresh its cached credentials.
+func credentialsRejected(config *rest.Config, err error) bool {
+	if IsCredentialError(err) {
+		return true
+	}
+	var dialErr *websocket.DialError
+	if !errors.As(err, &dialErr) || dialErr.Err != websocket.ErrBadStatus {
+		return false
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return IsCredentialError(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), credentialCheckTimeout)
+	defer cancel()
+	return IsCredentialError(clientset.Discovery().RESTClient().Get().AbsPath("/api").Do(ctx).Error())
+}
+
+// ExecPlugin returns the command of the exec credential plugin used by kubeContext (the
+// current context when empty), or "" when its user authenticates otherwise.
+func ExecPlugin(kubeContext string) (string, error) {
+	config, err := loadKubeconfig()
+	if err != nil {
+		return "", err
+	}
+	if kubeContext == "" {
+		kubeContext = config.CurrentContext
+	}
+	ctx, ok := config.Contexts[kubeContext]
+	if !ok {
+		return "", fmt.Errorf("context %q not found in kubeconfig", kubeContext)
+	}
+	user, ok := config.AuthInfos[ctx.AuthInfo]
+	if !ok || user.Exec == nil {
+		return "", nil
+	}
+	return user.Exec.Command, nil
+}
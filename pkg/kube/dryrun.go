@@ -0,0 +1,130 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// DefaultBindAddress is the local address forwards listen on unless Address is set.
+const DefaultBindAddress = "localhost"
+
+// ForwardPlan describes the port-forward that would be created for a connection.
+type ForwardPlan struct {
+	Connection model.Connection
+	PodName    string
+	Ports      string
+	Address    string
+	Problems   []string
+}
+
+// PlanPortForward resolves the target pod, checks RBAC and local port availability
+// for a connection without opening any tunnel.
+func PlanPortForward(ctx context.Context, clientset kubernetes.Interface, connection model.Connection) ForwardPlan {
+	plan := ForwardPlan{
+		Connection: connection,
+		Ports:      ForwardPorts(connection),
+		Address:    DefaultBindAddress,
+	}
+	if connection.Address != "" {
+		plan.Address = connection.Address
+	}
+
+	podName, err := ResolvePodName(ctx, clientset, connection)
+	if err != nil {
+		plan.Problems = append(plan.Problems, fmt.Sprintf("cannot resolve pod: %v", err))
+	} else {
+		plan.PodName = podName
+		subresource, allowed, err := CanForward(ctx, clientset, connection, podName)
+		if err != nil {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("cannot check RBAC: %v", err))
+		} else if !allowed {
+			plan.Problems = append(plan.Problems, "not allowed to create pods/"+subresource)
+		}
+	}
+
+	if connection.IsUDP() || connection.Relay {
+		allowed, err := canCreatePods(ctx, clientset, connection.Namespace)
+		if err != nil {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("cannot check RBAC: %v", err))
+		} else if !allowed {
+			plan.Problems = append(plan.Problems, "not allowed to create the relay pod")
+		}
+	}
+	if connection.LocalSocket != "" {
+		plan.Address = connection.LocalSocket
+	} else if connection.IsUDP() {
+		if err := CheckLocalUDPPort(connection.BindAddresses()[0], connection.LocalPort); err != nil {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("local UDP port unavailable: %v", err))
+		}
+	} else if err := CheckLocalPort(plan.Address, connection.LocalPort); err != nil {
+		plan.Problems = append(plan.Problems, fmt.Sprintf("local port unavailable: %v", err))
+	}
+
+	return plan
+}
+
+// CanPortForward asks the API server whether the current user may port-forward to the pod.
+func CanPortForward(ctx context.Context, clientset kubernetes.Interface, namespace, podName string) (bool, error) {
+	return canI(ctx, clientset, &authorizationv1.ResourceAttributes{
+		Namespace:   namespace,
+		Verb:        "create",
+		Resource:    "pods",
+		Subresource: "portforward",
+		Name:        podName,
+	})
+}
+
+// CanForward asks the API server whether the current user may reach the pod with the
+// connection's Transport, returning the pods subresource it needs: portforward, or exec.
+// The auto transport is allowed when either is.
+func CanForward(ctx context.Context, clientset kubernetes.Interface, connection model.Connection, podName string) (string, bool, error) {
+	if connection.Transport != model.TransportExec {
+		allowed, err := CanPortForward(ctx, clientset, connection.Namespace, podName)
+		if err != nil || allowed || connection.Transport != model.TransportAuto {
+			return "portforward", allowed, err
+		}
+	}
+	allowed, err := CanExec(ctx, clientset, connection.Namespace, podName)
+	return "exec", allowed, err
+}
+
+// CanExec asks the API server whether the current user may exec into the pod.
+func CanExec(ctx context.Context, clientset kubernetes.Interface, namespace, podName string) (bool, error) {
+	return canI(ctx, clientset, &authorizationv1.ResourceAttributes{
+		Namespace:   namespace,
+		Verb:        "create",
+		Resource:    "pods",
+		Subresource: "exec",
+		Name:        podName,
+	})
+}
+
+// canCreatePods asks the API server whether the current user may create pods in namespace.
+func canCreatePods(ctx context.Context, clientset kubernetes.Interface, namespace string) (bool, error) {
+	return canI(ctx, clientset, &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "create",
+		Resource:  "pods",
+	})
+}
+
+func canI(ctx context.Context, clientset kubernetes.Interface, attributes *authorizationv1.ResourceAttributes) (bool, error) {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: attributes,
+		},
+	}
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+	resp, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return resp.Status.Allowed, nil
+}
@@ -0,0 +1,65 @@
+package kube
+
+import (
+	"context"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// readyEndpointPods returns the names of the ready pods a service routes to, read from
+// its EndpointSlices, or from its Endpoints on clusters without the EndpointSlice API.
+// Endpoints that aren't pods, e.g. external IPs, are left out.
+func readyEndpointPods(ctx context.Context, clientset kubernetes.Interface, namespace, serviceName string) (map[string]bool, error) {
+	callCtx, cancel := requestContext(ctx)
+	defer cancel()
+	slices, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(callCtx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + serviceName,
+	})
+	if apierrors.IsNotFound(err) {
+		return readyEndpointsPods(ctx, clientset, namespace, serviceName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" {
+				continue
+			}
+			// An unset condition means ready.
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			names[endpoint.TargetRef.Name] = true
+		}
+	}
+	return names, nil
+}
+
+// readyEndpointsPods is readyEndpointPods for the core Endpoints API.
+func readyEndpointsPods(ctx context.Context, clientset kubernetes.Interface, namespace, serviceName string) (map[string]bool, error) {
+	callCtx, cancel := requestContext(ctx)
+	defer cancel()
+	endpoints, err := clientset.CoreV1().Endpoints(namespace).Get(callCtx, serviceName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+				names[address.TargetRef.Name] = true
+			}
+		}
+	}
+	return names, nil
+}
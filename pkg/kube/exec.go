@@ -0,0 +1,178 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// execRelayScript connects the exec stream to the port inside the container, with socat
+// or else nc, which the image must ship along with sh.
+const execRelayScript = `command -v socat >/dev/null 2>&1 && exec socat - TCP:localhost:%[1]d; exec nc localhost %[1]d`
+
+// useExec reports whether a connection's traffic goes through pods/exec: always for the
+// exec transport, and for auto when the user may not port-forward to the pod.
+func useExec(ctx context.Context, clientset kubernetes.Interface, connection model.Connection, podName string) bool {
+	switch connection.Transport {
+	case model.TransportExec:
+		return true
+	case model.TransportAuto:
+		allowed, err := CanPortForward(ctx, clientset, connection.Namespace, podName)
+		if err == nil && !allowed {
+			logging.Connectionf(ctx, "Port-forwarding to pod %s is forbidden, tunneling through exec", podName)
+			return true
+		}
+	}
+	return false
+}
+
+// execForwarder tunnels every local connection through its own exec stream running
+// socat or nc in the pod, for clusters that deny pods/portforward but allow pods/exec.
+type execForwarder struct {
+	config     *rest.Config
+	clientset  kubernetes.Interface
+	namespace  string
+	podName    string
+	container  string
+	addresses  []string
+	localPort  int
+	remotePort int
+	stopChan   <-chan struct{}
+	readyChan  chan struct{}
+	out        io.Writer
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newExecForwarder(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, connection model.Connection, podName string, stopChan, readyChan chan struct{}, out io.Writer) (Forwarder, error) {
+	reqCtx, cancel := requestContext(ctx)
+	defer cancel()
+	pod, err := clientset.CoreV1().Pods(connection.Namespace).Get(reqCtx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &execForwarder{
+		config:     config,
+		clientset:  clientset,
+		namespace:  connection.Namespace,
+		podName:    podName,
+		container:  containerWithPort(pod, connection.RemoteServicePort),
+		addresses:  connection.BindAddresses(),
+		localPort:  connection.LocalPort,
+		remotePort: connection.RemoteServicePort,
+		stopChan:   stopChan,
+		readyChan:  readyChan,
+		out:        out,
+		conns:      make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// containerWithPort returns the container of pod declaring port, or its first one.
+func containerWithPort(pod *corev1.Pod, port int) string {
+	for _, container := range pod.Spec.Containers {
+		for _, p := range container.Ports {
+			if int(p.ContainerPort) == port {
+				return container.Name
+			}
+		}
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	return pod.Spec.Containers[0].Name
+}
+
+// ForwardPorts listens on the local port until stopChan is closed.
+func (f *execForwarder) ForwardPorts() error {
+	var listeners []net.Listener
+	for _, address := range f.addresses {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(f.localPort)))
+		if err != nil {
+			fmt.Fprintf(f.out, "Unable to listen on %s:%d: %v\n", address, f.localPort, err)
+			continue
+		}
+		fmt.Fprintf(f.out, "Forwarding from %s -> %d through exec in %s/%s\n", listener.Addr(), f.remotePort, f.podName, f.container)
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("unable to listen on any of the requested ports: [%d:%d]", f.localPort, f.remotePort)
+	}
+	for _, listener := range listeners {
+		go f.accept(listener)
+	}
+	if f.readyChan != nil {
+		close(f.readyChan)
+	}
+
+	<-f.stopChan
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+func (f *execForwarder) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener is closed when the forwarder stops.
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// handle runs the relay script with the local connection as its stdin and stdout.
+func (f *execForwarder) handle(conn net.Conn) {
+	f.mu.Lock()
+	f.conns[conn] = struct{}{}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+	fmt.Fprintf(f.out, "Handling connection for %d\n", f.localPort)
+
+	req := f.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(f.namespace).
+		Name(f.podName).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: f.container,
+			Command:   []string{"sh", "-c", fmt.Sprintf(execRelayScript, f.remotePort)},
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(f.config, "POST", req.URL())
+	if err != nil {
+		fmt.Fprintf(f.out, "error creating exec stream for port %d -> %d: %v\n", f.localPort, f.remotePort, err)
+		return
+	}
+	err = executor.Stream(remotecommand.StreamOptions{Stdin: conn, Stdout: conn, Stderr: f.out})
+	if err != nil {
+		fmt.Fprintf(f.out, "error forwarding port %d through exec: %v\n", f.remotePort, err)
+	}
+}
@@ -0,0 +1,34 @@
+package kube
+
+import (
+	"os"
+
+	"k8s.io/client-go/rest"
+)
+
+// InClusterContext is the context name kpfm reports when it runs inside a pod without a
+// kubeconfig. Config files list their in-cluster connections under a context of that name.
+const InClusterContext = "in-cluster"
+
+// inCluster reports whether kpfm should use the pod's service account: none of the
+// kubeconfig files exist and the kubelet injected the API server address.
+func inCluster() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" || os.Getenv("KUBERNETES_SERVICE_PORT") == "" {
+		return false
+	}
+	for _, path := range loadingRules().GetLoadingPrecedence() {
+		if _, err := os.Stat(path); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// inClusterConfig returns the service account config when kubeContext refers to the
+// cluster kpfm runs in, or nil otherwise.
+func inClusterConfig(kubeContext string) (*rest.Config, error) {
+	if (kubeContext != "" && kubeContext != InClusterContext) || !inCluster() {
+		return nil, nil
+	}
+	return rest.InClusterConfig()
+}
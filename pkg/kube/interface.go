@@ -0,0 +1,79 @@
+package kube
+
+import (
+	"context"
+	"io"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// Forwarder is satisfied by client-go's PortForwarder and by kpfm's own forwarders.
+type Forwarder interface {
+	// ForwardPorts listens on the local port and forwards until the stop channel it was
+	// built with is closed.
+	ForwardPorts() error
+}
+
+// Client is what SetupPortForward needs of the cluster behind a kube context, so the
+// forward orchestration runs against the fake of package kubefake as well as a cluster.
+type Client interface {
+	// ResolvePod returns the pod connection forwards to.
+	ResolvePod(ctx context.Context, connection model.Connection) (string, error)
+	// Forwarder returns the forwarder of connection to podName, which closes readyChan
+	// once it listens, stops when stopChan is closed and writes its chatter to out.
+	Forwarder(ctx context.Context, connection model.Connection, podName string, stopChan, readyChan chan struct{}, out io.Writer) (Forwarder, error)
+	// Target returns what the kube context points at.
+	Target() (ContextTarget, error)
+	// Reset drops the cached credentials after the API server rejected them.
+	Reset()
+}
+
+// clusterClient is the Client of a kube context's cluster, going through the cached
+// clients of Clientset.
+type clusterClient struct {
+	kubeContext string
+}
+
+// NewClient returns the Client of the cluster of kubeContext, or of the kubeconfig's
+// current context when kubeContext is empty.
+func NewClient(kubeContext string) Client {
+	return &clusterClient{kubeContext: kubeContext}
+}
+
+func (c *clusterClient) ResolvePod(ctx context.Context, connection model.Connection) (string, error) {
+	_, clientset, err := Clientset(c.kubeContext)
+	if err != nil {
+		return "", err
+	}
+	return ResolvePodName(ctx, clientset, connection)
+}
+
+func (c *clusterClient) Forwarder(ctx context.Context, connection model.Connection, podName string, stopChan, readyChan chan struct{}, out io.Writer) (Forwarder, error) {
+	config, clientset, err := Clientset(c.kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case connection.IsUDP():
+		return newUDPForwarder(ctx, config, clientset, connection, podName, stopChan, readyChan, out)
+	case connection.Relay:
+		return newRelayForwarder(ctx, config, clientset, connection, stopChan, readyChan, out)
+	case connection.LoadBalance:
+		return newBalancedForwarder(ctx, config, clientset, connection, stopChan, readyChan, out)
+	case useExec(ctx, clientset, connection, podName):
+		return newExecForwarder(ctx, config, clientset, connection, podName, stopChan, readyChan, out)
+	}
+	return newForwarder(config, clientset, connection.Namespace, podName, connection.BindAddresses(), connection.LocalPort, connection.RemoteServicePort, stopChan, readyChan, out)
+}
+
+func (c *clusterClient) Target() (ContextTarget, error) {
+	if c.kubeContext == "" {
+		current, err := CurrentContextTarget()
+		return current.Target, err
+	}
+	return TargetOf(c.kubeContext)
+}
+
+func (c *clusterClient) Reset() {
+	resetClient(c.kubeContext)
+}
@@ -0,0 +1,63 @@
+package kube
+
+import (
+	"path/filepath"
+	"sync"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeconfigFiles replaces the $KUBECONFIG the process started with once a reload sets
+// another list of files, and wakes the context watchers on every reload.
+var kubeconfigFiles = struct {
+	sync.Mutex
+	paths    []string
+	set      bool
+	reloaded chan struct{} // closed and replaced by every reload
+}{reloaded: make(chan struct{})}
+
+// KubeconfigPaths returns the files a KUBECONFIG value lists, ~/.kube/config when empty.
+func KubeconfigPaths(kubeconfig string) []string {
+	var paths []string
+	for _, path := range filepath.SplitList(kubeconfig) {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{clientcmd.RecommendedHomeFile}
+	}
+	return paths
+}
+
+// ReloadKubeconfig drops the cached clients so the kubeconfig files are read again, from
+// paths when not nil, and has the context watchers check the current context and watch
+// the new files right away.
+func ReloadKubeconfig(paths []string) {
+	kubeconfigFiles.Lock()
+	if paths != nil {
+		kubeconfigFiles.paths = append([]string(nil), paths...)
+		kubeconfigFiles.set = true
+	}
+	close(kubeconfigFiles.reloaded)
+	kubeconfigFiles.reloaded = make(chan struct{})
+	kubeconfigFiles.Unlock()
+
+	clients.Lock()
+	defer clients.Unlock()
+	clients.byContext = make(map[string]cachedClient)
+}
+
+// kubeconfigReloaded returns a channel closed by the next reload.
+func kubeconfigReloaded() <-chan struct{} {
+	kubeconfigFiles.Lock()
+	defer kubeconfigFiles.Unlock()
+	return kubeconfigFiles.reloaded
+}
+
+// kubeconfigPrecedence returns the files set by the last reload, if any.
+func kubeconfigPrecedence() ([]string, bool) {
+	kubeconfigFiles.Lock()
+	defer kubeconfigFiles.Unlock()
+	return append([]string(nil), kubeconfigFiles.paths...), kubeconfigFiles.set
+}
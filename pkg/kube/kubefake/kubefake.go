@@ -0,0 +1,195 @@
+// Package kubefake is a fake kube.Client, forwarding to local addresses instead of pods
+// so the forward orchestration runs without a cluster.
+package kubefake
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// Client resolves connections to the pods of Pods and forwards to the local address of
+// Backends for that pod. Set its fields before the forwards start, use SetPod to move a
+// connection to another pod while they run and Fail to drop the running forwards.
+type Client struct {
+	mu sync.Mutex
+	// Pods is the pod of each connection by its Target, e.g. "svc/db". A connection
+	// naming a pod without an entry resolves to it.
+	Pods map[string]string
+	// Backends is the address, e.g. an httptest server's, each pod's forwards reach.
+	Backends map[string]string
+	// ResolveErr and ForwardErr, when set, fail the pod resolution and the forwarders.
+	ResolveErr error
+	ForwardErr error
+	// ContextTarget is what Target returns.
+	ContextTarget kube.ContextTarget
+
+	resets  int
+	running map[*forwarder]bool
+}
+
+// New returns a client without pods.
+func New() *Client {
+	return &Client{
+		Pods:     make(map[string]string),
+		Backends: make(map[string]string),
+		running:  make(map[*forwarder]bool),
+	}
+}
+
+// Factory returns c for every kube context, as a manager.Options.Client.
+func (c *Client) Factory() func(kubeContext string) kube.Client {
+	return func(string) kube.Client { return c }
+}
+
+// SetPod makes the connections to target resolve to pod, serving backend.
+func (c *Client) SetPod(target, pod, backend string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Pods[target] = pod
+	c.Backends[pod] = backend
+}
+
+// Fail ends the forwards that are running with err, as a lost connection to the API
+// server does.
+func (c *Client) Fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for f := range c.running {
+		f.fail <- err
+		delete(c.running, f)
+	}
+}
+
+// Resets returns how often the forwards reset the client after a credential error.
+func (c *Client) Resets() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resets
+}
+
+func (c *Client) ResolvePod(ctx context.Context, connection model.Connection) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ResolveErr != nil {
+		return "", c.ResolveErr
+	}
+	if pod, ok := c.Pods[connection.Target()]; ok {
+		return pod, nil
+	}
+	if connection.PodName != "" {
+		return connection.PodName, nil
+	}
+	return "", fmt.Errorf("no pod found for service %s", connection.ServiceName)
+}
+
+func (c *Client) Forwarder(ctx context.Context, connection model.Connection, podName string, stopChan, readyChan chan struct{}, out io.Writer) (kube.Forwarder, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ForwardErr != nil {
+		return nil, c.ForwardErr
+	}
+	backend, ok := c.Backends[podName]
+	if !ok {
+		return nil, fmt.Errorf("pod %s has no backend", podName)
+	}
+	return &forwarder{
+		client:    c,
+		addresses: connection.BindAddresses(),
+		localPort: connection.LocalPort,
+		backend:   backend,
+		stopChan:  stopChan,
+		readyChan: readyChan,
+		out:       out,
+		fail:      make(chan error, 1),
+	}, nil
+}
+
+func (c *Client) Target() (kube.ContextTarget, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ContextTarget, nil
+}
+
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resets++
+}
+
+// forwarder pipes every connection to its local port to a backend address.
+type forwarder struct {
+	client    *Client
+	addresses []string
+	localPort int
+	backend   string
+	stopChan  <-chan struct{}
+	readyChan chan struct{}
+	out       io.Writer
+	fail      chan error
+}
+
+func (f *forwarder) ForwardPorts() error {
+	var listeners []net.Listener
+	for _, address := range f.addresses {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(f.localPort)))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		fmt.Fprintf(f.out, "Forwarding from %s -> %s\n", listener.Addr(), f.backend)
+		listeners = append(listeners, listener)
+	}
+	for _, listener := range listeners {
+		go f.accept(listener)
+	}
+	f.client.mu.Lock()
+	f.client.running[f] = true
+	f.client.mu.Unlock()
+	close(f.readyChan)
+
+	var err error
+	select {
+	case <-f.stopChan:
+		f.client.mu.Lock()
+		delete(f.client.running, f)
+		f.client.mu.Unlock()
+	case err = <-f.fail:
+	}
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	return err
+}
+
+func (f *forwarder) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener is closed when the forwarder stops.
+			return
+		}
+		go f.pipe(conn)
+	}
+}
+
+func (f *forwarder) pipe(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(f.out, "Handling connection for %d\n", f.localPort)
+	upstream, err := net.Dial("tcp", f.backend)
+	if err != nil {
+		fmt.Fprintf(f.out, "error forwarding port %d to %s: %v\n", f.localPort, f.backend, err)
+		return
+	}
+	defer upstream.Close()
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
@@ -0,0 +1,45 @@
+package kube
+
+import (
+	"net"
+	"strconv"
+)
+
+// minUnprivilegedPort is the lowest port a regular user can bind on most systems.
+const minUnprivilegedPort = 1024
+
+// CheckLocalPort verifies that the local port can be bound on address.
+func CheckLocalPort(address string, port int) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// CheckLocalUDPPort verifies that the local UDP port can be bound on address.
+func CheckLocalUDPPort(address string, port int) error {
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	return pc.Close()
+}
+
+// SuggestLocalPort returns the first free, unprivileged local port at or above preferred
+// that is not already in taken. It returns 0 if no port is available.
+func SuggestLocalPort(preferred int, taken map[int]bool) int {
+	port := preferred
+	if port < minUnprivilegedPort {
+		port += 10000
+	}
+	for ; port <= 65535; port++ {
+		if taken[port] {
+			continue
+		}
+		if CheckLocalPort(DefaultBindAddress, port) == nil {
+			return port
+		}
+	}
+	return 0
+}
@@ -4,22 +4,192 @@ import (
 	"context"
 	"fmt"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 )
 
-// lists the ports for all containers within a specified pod.
-func ListPorts(clientset *kubernetes.Clientset, podName, namespace string) ([]string, error) {
-	pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+// Kinds of containers declaring a ContainerPort besides the regular ones.
+const (
+	ContainerKindInit      = "init"      // e.g. a sidecar run as a restartable init container
+	ContainerKindEphemeral = "ephemeral" // added by kubectl debug
+)
+
+// ContainerPort is a port exposed by a container, along with the service ports routing to it.
+type ContainerPort struct {
+	Container    string
+	Kind         string // empty for regular containers, else ContainerKindInit or ContainerKindEphemeral
+	Name         string
+	Port         int32
+	Protocol     corev1.Protocol
+	ServicePorts []string // "service:port" entries targeting this container port
+}
+
+func (p ContainerPort) String() string {
+	return fmt.Sprintf("%s:%d/%s", p.Container, p.Port, p.Protocol)
+}
+
+// declaredPorts returns the ports of every container of pod, regular containers first,
+// then init and ephemeral containers.
+func declaredPorts(pod *corev1.Pod) []ContainerPort {
+	var ports []ContainerPort
+	add := func(container, kind string, declared []corev1.ContainerPort) {
+		for _, port := range declared {
+			ports = append(ports, ContainerPort{Container: container, Kind: kind, Name: port.Name, Port: port.ContainerPort, Protocol: port.Protocol})
+		}
+	}
+	for _, container := range pod.Spec.Containers {
+		add(container.Name, "", container.Ports)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		add(container.Name, ContainerKindInit, container.Ports)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		add(container.Name, ContainerKindEphemeral, container.Ports)
+	}
+	return ports
+}
+
+// lists the ports for all containers within a specified pod, and which service ports map to them.
+func ListPorts(ctx context.Context, clientset kubernetes.Interface, podName, namespace string) ([]ContainerPort, error) {
+	callCtx, cancel := requestContext(ctx)
+	defer cancel()
+	pod, err := clientset.CoreV1().Pods(namespace).Get(callCtx, podName, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	var ports []string
-	for _, container := range pod.Spec.Containers {
-		for _, port := range container.Ports {
-			ports = append(ports, fmt.Sprintf("%s:%d/%s", container.Name, port.ContainerPort, port.Protocol))
-		}
+	services, err := ListServices(ctx, clientset, namespace, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ports := declaredPorts(pod)
+	for i := range ports {
+		ports[i].ServicePorts = servicePortsFor(services, pod, ports[i])
 	}
 	return ports, nil
 }
+
+// ServicePortMapping is how a service port routes to the container ports of a pod the
+// service selects.
+type ServicePortMapping struct {
+	Service    string
+	Name       string
+	Port       int32
+	Protocol   corev1.Protocol
+	TargetPort intstr.IntOrString
+	Pod        string          // the pod Targets were read from, empty when the service has no ready pod
+	Targets    []ContainerPort // the container ports of Pod the service port routes to
+}
+
+// RemotePort returns the pod port a connection forwarding this service port sets as its
+// RemoteServicePort, or 0 for a named target port the pod doesn't declare.
+func (m ServicePortMapping) RemotePort() int32 {
+	switch {
+	case m.TargetPort.Type == intstr.String:
+		if len(m.Targets) > 0 {
+			return m.Targets[0].Port
+		}
+		return 0
+	case m.TargetPort.IntVal != 0:
+		return m.TargetPort.IntVal
+	default:
+		return m.Port
+	}
+}
+
+// ServicePortMappings lists the ports of a service with the container ports of one of its
+// ready pods each of them routes to.
+func ServicePortMappings(ctx context.Context, clientset kubernetes.Interface, namespace, serviceName string) ([]ServicePortMapping, error) {
+	callCtx, cancel := requestContext(ctx)
+	defer cancel()
+	svc, err := clientset.CoreV1().Services(namespace).Get(callCtx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return portMappings(ctx, clientset, svc, true)
+}
+
+// PortMappings is ServicePortMappings for a service already listed. The pod is only read
+// when a port has a named target port, which RemotePort needs it for.
+func PortMappings(ctx context.Context, clientset kubernetes.Interface, svc *corev1.Service) ([]ServicePortMapping, error) {
+	named := false
+	for _, sp := range svc.Spec.Ports {
+		if sp.TargetPort.Type == intstr.String {
+			named = true
+		}
+	}
+	return portMappings(ctx, clientset, svc, named)
+}
+
+// portMappings maps the ports of svc to the container ports of one of its ready pods
+// when withPod is set.
+func portMappings(ctx context.Context, clientset kubernetes.Interface, svc *corev1.Service, withPod bool) ([]ServicePortMapping, error) {
+	var pod *corev1.Pod
+	if withPod {
+		// A service without ready pods still maps its numeric target ports.
+		if podName, err := GetPodName(ctx, clientset, svc.Namespace, svc.Name); err == nil {
+			callCtx, cancel := requestContext(ctx)
+			defer cancel()
+			if pod, err = clientset.CoreV1().Pods(svc.Namespace).Get(callCtx, podName, metav1.GetOptions{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	mappings := make([]ServicePortMapping, 0, len(svc.Spec.Ports))
+	for _, sp := range svc.Spec.Ports {
+		mapping := ServicePortMapping{
+			Service:    svc.Name,
+			Name:       sp.Name,
+			Port:       sp.Port,
+			Protocol:   sp.Protocol,
+			TargetPort: sp.TargetPort,
+		}
+		if pod != nil {
+			mapping.Pod = pod.Name
+			for _, port := range declaredPorts(pod) {
+				if targetsContainerPort(sp, port) {
+					mapping.Targets = append(mapping.Targets, port)
+				}
+			}
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// servicePortsFor returns the service ports of services selecting pod whose target is the given container port.
+func servicePortsFor(services []corev1.Service, pod *corev1.Pod, port ContainerPort) []string {
+	var mapped []string
+	for _, svc := range services {
+		if len(svc.Spec.Selector) == 0 || !labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		for _, sp := range svc.Spec.Ports {
+			if targetsContainerPort(sp, port) {
+				mapped = append(mapped, fmt.Sprintf("%s:%d", svc.Name, sp.Port))
+			}
+		}
+	}
+	return mapped
+}
+
+// targetsContainerPort reports whether a service port routes to the container port.
+func targetsContainerPort(sp corev1.ServicePort, port ContainerPort) bool {
+	if sp.Protocol != port.Protocol {
+		return false
+	}
+	switch {
+	case sp.TargetPort.Type == intstr.String:
+		return sp.TargetPort.StrVal == port.Name
+	case sp.TargetPort.IntVal == 0:
+		// An unset targetPort defaults to the service port.
+		return sp.Port == port.Port
+	default:
+		return sp.TargetPort.IntVal == port.Port
+	}
+}
@@ -1,66 +1,155 @@
 package kube
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/rparaujo/kpfm/pkg/logging"
 	"github.com/rparaujo/kpfm/pkg/model"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/transport/spdy"
-	"k8s.io/client-go/util/homedir"
 )
 
-func SetupPortForward(connection model.Connection, wg *sync.WaitGroup, statusCh chan<- model.PortForwardStatus, stopChan chan struct{}) {
+// portForwardStatuses is the capacity SetupPortForward needs of its status channel:
+// Starting, PodResolved, Ready and a final status are never dropped, so they can't block
+// it even after the receiver stopped listening.
+const portForwardStatuses = 4
+
+// NewStatusChannel returns a channel for the statuses of one SetupPortForward call.
+func NewStatusChannel() chan model.PortForwardStatus {
+	return make(chan model.PortForwardStatus, portForwardStatuses)
+}
+
+// SetupPortForward resolves the pod of connection through client and forwards to it
+// until stopChan is closed, sending a status on statusCh, made by NewStatusChannel, for
+// every transition: Starting, PodResolved, Ready, ConnectionAccepted for local clients
+// and Error or Stopped when it ends. ConnectionAccepted statuses are dropped rather than
+// wait for the receiver.
+func SetupPortForward(ctx context.Context, connection model.Connection, client Client, wg *sync.WaitGroup, statusCh chan<- model.PortForwardStatus, stopChan chan struct{}) {
 	defer wg.Done()
 
-	kubeconfig := os.Getenv("KUBECONFIG")
-	if kubeconfig == "" && homedir.HomeDir() != "" {
-		kubeconfig = filepath.Join(homedir.HomeDir(), ".kube", "config")
+	send := func(status model.PortForwardStatus) {
+		status.Time = time.Now()
+		status.ServiceName = connection.ServiceName
+		statusCh <- status
+	}
+	end := func(err error) {
+		if err != nil {
+			send(model.PortForwardStatus{Type: model.PortForwardError, Err: err})
+		} else {
+			send(model.PortForwardStatus{Type: model.PortForwardStopped})
+		}
+	}
+
+	send(model.PortForwardStatus{Type: model.PortForwardStarting})
+	podName, err := client.ResolvePod(ctx, connection)
+	if IsCredentialError(err) {
+		// Expired credentials: rebuild the client, which runs the exec plugin again.
+		client.Reset()
+		podName, err = client.ResolvePod(ctx, connection)
 	}
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	if err != nil {
-		statusCh <- model.PortForwardStatus{ServiceName: connection.ServiceName, Err: err}
+		end(err)
 		return
 	}
+	logging.Connectionf(ctx, "Resolved %s/%s to pod %s", connection.Namespace, connection.Target(), podName)
+	send(model.PortForwardStatus{Type: model.PortForwardPodResolved, PodName: podName})
 
-	clientset, err := kubernetes.NewForConfig(config)
+	readyChan := make(chan struct{})
+	accepted := &acceptWriter{out: logging.ForwarderOutput(ctx), statusCh: statusCh, connection: connection}
+	fw, err := client.Forwarder(ctx, connection, podName, stopChan, readyChan, accepted)
 	if err != nil {
-		statusCh <- model.PortForwardStatus{ServiceName: connection.ServiceName, Err: err}
+		end(err)
 		return
 	}
 
-	// Determine the target pod name
-	var podName string
-	if connection.PodName != "" {
-		// Use the directly specified pod name
-		podName = connection.PodName
-	} else if connection.ServiceName != "" {
-		// Resolve the pod name from the service
-		podName, err = GetPodName(clientset, connection.Namespace, connection.ServiceName)
-		if err != nil {
-			statusCh <- model.PortForwardStatus{ServiceName: connection.ServiceName, Err: err}
-			return
+	// Errors client-go reports for the local port go to the connection's log too.
+	unregister := registerPortLog(connection.LocalPort, logging.FromContext(ctx))
+
+	// The forwarding is run in a separate goroutine so that it can be stopped by closing the stopChan
+	doneChan := make(chan struct{})
+	go func() {
+		err := fw.ForwardPorts()
+		unregister()
+		close(doneChan)
+		if IsCredentialError(err) {
+			client.Reset()
 		}
-	} else {
-		statusCh <- model.PortForwardStatus{ServiceName: connection.ServiceName, Err: fmt.Errorf("both ServiceName and PodName are empty")}
-		return
-	}
+		end(err)
+	}()
 
-	roundTripper, upgrader, err := spdy.RoundTripperFor(config)
-	if err != nil {
-		statusCh <- model.PortForwardStatus{ServiceName: connection.ServiceName, Err: err}
-		return
+	// Report once the local listeners are up
+	go func() {
+		select {
+		case <-readyChan:
+			if balanced, ok := fw.(*balancedForwarder); ok {
+				podName = balanced.PodNames()
+			}
+			send(model.PortForwardStatus{Type: model.PortForwardReady, PodName: podName})
+			accepted.setPod(podName)
+		case <-doneChan:
+		}
+	}()
+}
+
+// acceptWriter passes the forwarder's output on to out and sends a ConnectionAccepted
+// status for every "Handling connection for" line in it, the one line all forwarders
+// print per local connection.
+type acceptWriter struct {
+	out        io.Writer
+	statusCh   chan<- model.PortForwardStatus
+	connection model.Connection
+
+	mu  sync.Mutex
+	pod string // set once Ready was sent
+}
+
+// setPod starts the ConnectionAccepted statuses, which go to pod.
+func (w *acceptWriter) setPod(pod string) {
+	w.mu.Lock()
+	w.pod = pod
+	w.mu.Unlock()
+}
+
+func (w *acceptWriter) Write(p []byte) (int, error) {
+	if n := bytes.Count(p, []byte("Handling connection for ")); n > 0 {
+		w.mu.Lock()
+		for i := 0; i < n; i++ {
+			// Only between Ready and the final status, leaving room for the latter.
+			if w.pod == "" || len(w.statusCh) >= cap(w.statusCh)-1 {
+				break
+			}
+			select {
+			case w.statusCh <- model.PortForwardStatus{
+				Type:        model.PortForwardConnectionAccepted,
+				Time:        time.Now(),
+				ServiceName: w.connection.ServiceName,
+				PodName:     w.pod,
+			}:
+			default:
+			}
+		}
+		w.mu.Unlock()
 	}
+	return w.out.Write(p)
+}
+
+// loopbackAddresses are the local addresses internal forwards listen on.
+var loopbackAddresses = []string{"127.0.0.1", "::1"}
 
-	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", connection.Namespace, podName)
+// newForwarder builds a TCP forwarder from localPort on addresses to remotePort of a
+// pod, using the transport negotiated for the cluster.
+func newForwarder(config *rest.Config, clientset kubernetes.Interface, namespace, podName string, addresses []string, localPort, remotePort int, stopChan, readyChan chan struct{}, out io.Writer) (Forwarder, error) {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", namespace, podName)
 	serverURL := url.URL{
 		Scheme: "https",
 		Path:   path,
@@ -71,26 +160,67 @@ func SetupPortForward(connection model.Connection, wg *sync.WaitGroup, statusCh
 		Post().
 		RequestURI(serverURL.String())
 
-	ports := []string{fmt.Sprintf("%d:%d", connection.LocalPort, connection.RemoteServicePort)}
+	transport := negotiateTransport(config, req.URL(), remotePort)
+	logging.Verbosef("Port-forward to pod %s/%s uses the %s transport", namespace, podName, transport)
+	if transport == TransportWebSocket {
+		return &websocketForwarder{
+			config:     config,
+			pfURL:      req.URL(),
+			addresses:  addresses,
+			localPort:  localPort,
+			remotePort: remotePort,
+			stopChan:   stopChan,
+			readyChan:  readyChan,
+			failed:     make(chan error, 1),
+			out:        out,
+			errOut:     out,
+		}, nil
+	}
+
+	roundTripper, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, err
+	}
 
-	logWriter := io.MultiWriter(os.Stdout)
+	ports := []string{fmt.Sprintf("%d:%d", localPort, remotePort)}
 
-	forwarder, err := portforward.New(
+	return portforward.NewOnAddresses(
 		spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, "POST", req.URL()),
+		addresses,
 		ports,
 		stopChan,
-		make(chan struct{}),
-		logWriter,
-		logWriter,
+		readyChan,
+		out,
+		out,
 	)
-	if err != nil {
-		statusCh <- model.PortForwardStatus{ServiceName: connection.ServiceName, Err: err}
-		return
+}
+
+// ResolvePodName determines the target pod of a connection, either directly from PodName or through its service.
+func ResolvePodName(ctx context.Context, clientset kubernetes.Interface, connection model.Connection) (string, error) {
+	if connection.PodName != "" {
+		// Use the directly specified pod name
+		return connection.PodName, nil
+	}
+	if connection.Relay && connection.ServiceName != "" && !connection.IsUDP() {
+		// The relay pod is created when the forward starts.
+		return relayPodName(connection), nil
 	}
+	if connection.ServiceName != "" {
+		// Resolve the pod name from the service
+		pods, err := ServicePods(ctx, clientset, connection.Namespace, connection.ServiceName)
+		if err != nil {
+			return "", err
+		}
+		return SelectPod(pods, connection)
+	}
+	return "", fmt.Errorf("both ServiceName and PodName are empty")
+}
 
-	// The forwarding is run in a separate goroutine so that it can be stopped by closing the stopChan
-	go func() {
-		err := forwarder.ForwardPorts()
-		statusCh <- model.PortForwardStatus{ServiceName: connection.ServiceName, Err: err}
-	}()
+// ForwardPorts returns the "local:remote" port pair handed to the forwarder.
+func ForwardPorts(connection model.Connection) string {
+	ports := fmt.Sprintf("%d:%d", connection.LocalPort, connection.RemoteServicePort)
+	if connection.IsUDP() {
+		ports += "/udp"
+	}
+	return ports
 }
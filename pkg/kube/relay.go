@@ -0,0 +1,264 @@
+package kube
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+const (
+	defaultRelayImage    = model.DefaultRelayImage
+	defaultUDPRelayImage = model.DefaultUDPRelayImage
+	relayReadyTimeout    = 60 * time.Second
+)
+
+// relayOwnerLabel holds the relayOwner of a relay pod.
+const relayOwnerLabel = "kpfm.rparaujo.github.io/owner"
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// relayOwner tells the relay pods of this user on this machine from those of others
+// relaying the same target, which the first of them to stop would delete otherwise.
+var relayOwner = func() string {
+	host, _ := os.Hostname()
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s/%d", host, os.Getuid())))
+	return hex.EncodeToString(sum[:3])
+}()
+
+// relayForwarder forwards to a socat relay pod that connects on to a service's cluster
+// address, for services kpfm cannot resolve to a pod such as ExternalName services.
+type relayForwarder struct {
+	inner     Forwarder
+	clientset kubernetes.Interface
+	namespace string
+	relayPod  string
+	stopChan  <-chan struct{}
+	out       io.Writer
+}
+
+// newRelayForwarder starts (or reuses) the relay pod of a connection and prepares the
+// forward to it.
+func newRelayForwarder(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, connection model.Connection, stopChan, readyChan chan struct{}, out io.Writer) (Forwarder, error) {
+	if connection.ServiceName == "" {
+		return nil, errors.New("Relay needs a ServiceName")
+	}
+	target := fmt.Sprintf("%s.%s.svc", connection.ServiceName, connection.Namespace)
+	relayPod, err := ensureRelay(ctx, clientset, connection, target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start relay: %v", err)
+	}
+
+	inner, err := newForwarder(config, clientset, connection.Namespace, relayPod, connection.BindAddresses(), connection.LocalPort, connection.RemoteServicePort, stopChan, readyChan, out)
+	if err != nil {
+		return nil, err
+	}
+	return &relayForwarder{
+		inner:     inner,
+		clientset: clientset,
+		namespace: connection.Namespace,
+		relayPod:  relayPod,
+		stopChan:  stopChan,
+		out:       out,
+	}, nil
+}
+
+// ForwardPorts forwards to the relay pod, removing the pod once the forward is stopped.
+// A failed forward keeps it for the retry.
+func (f *relayForwarder) ForwardPorts() error {
+	err := f.inner.ForwardPorts()
+	select {
+	case <-f.stopChan:
+		deleteRelayPod(f.clientset, f.namespace, f.relayPod, f.out)
+	default:
+	}
+	return err
+}
+
+// deleteRelayPod removes a relay pod, reporting failures to out.
+func deleteRelayPod(clientset kubernetes.Interface, namespace, name string, out io.Writer) {
+	// The forward's context may be what ended it.
+	ctx, cancel := requestContext(context.Background())
+	defer cancel()
+	err := clientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		fmt.Fprintf(out, "Cannot delete relay pod %s/%s: %v\n", namespace, name, err)
+	}
+}
+
+// ensureRelay creates the relay pod for a connection, or reuses the one it left, and
+// waits for it to become ready. The relay accepts the TCP forward and connects to target
+// over the connection's protocol: socat for TCP, udpRelayScript for UDP.
+func ensureRelay(ctx context.Context, clientset kubernetes.Interface, connection model.Connection, target string) (string, error) {
+	port := connection.RemoteServicePort
+	name := relayPodName(connection)
+	pods := clientset.CoreV1().Pods(connection.Namespace)
+
+	kind := relayKind(connection)
+	container := corev1.Container{
+		Name:  "relay",
+		Image: connection.RelayImage,
+		Args: []string{
+			fmt.Sprintf("TCP-LISTEN:%d,fork,reuseaddr", port),
+			fmt.Sprintf("%s:%s:%d", strings.ToUpper(kind), target, port),
+		},
+		Ports: []corev1.ContainerPort{{ContainerPort: int32(port), Protocol: corev1.ProtocolTCP}},
+	}
+	if connection.IsUDP() {
+		// socat would lose the boundaries of the framed datagrams.
+		container.Command = []string{"python3", "-c", udpRelayScript}
+		container.Args = []string{strconv.Itoa(port), target, strconv.Itoa(port)}
+		if container.Image == "" {
+			container.Image = defaultUDPRelayImage
+		}
+	}
+	if container.Image == "" {
+		container.Image = defaultRelayImage
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: connection.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "kpfm-" + kind + "-relay",
+				"app.kubernetes.io/managed-by": "kpfm",
+				relayOwnerLabel:                relayOwner,
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyAlways,
+			Containers:    []corev1.Container{container},
+		},
+	}
+
+	callCtx, cancel := requestContext(ctx)
+	_, err := pods.Create(callCtx, pod, metav1.CreateOptions{})
+	cancel()
+	if apierrors.IsAlreadyExists(err) {
+		err = replaceStaleRelay(ctx, pods, pod)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(relayReadyTimeout)
+	for {
+		callCtx, cancel := requestContext(ctx)
+		current, err := pods.Get(callCtx, name, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		if current.Status.Phase == corev1.PodRunning && podReady(current) {
+			return name, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("relay pod %s/%s not ready after %s", connection.Namespace, name, relayReadyTimeout)
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// replaceStaleRelay reuses the existing relay pod named like pod when it runs the same
+// relay, and replaces it otherwise, e.g. when the pod it relays to got a new IP.
+func replaceStaleRelay(ctx context.Context, pods typedcorev1.PodInterface, pod *corev1.Pod) error {
+	deadline := time.Now().Add(relayReadyTimeout)
+	for {
+		callCtx, cancel := requestContext(ctx)
+		current, err := pods.Get(callCtx, pod.Name, metav1.GetOptions{})
+		cancel()
+		switch {
+		case apierrors.IsNotFound(err):
+			callCtx, cancel := requestContext(ctx)
+			_, err = pods.Create(callCtx, pod, metav1.CreateOptions{})
+			cancel()
+			if !apierrors.IsAlreadyExists(err) {
+				return err
+			}
+		case err != nil:
+			return err
+		case current.DeletionTimestamp != nil:
+			// Still terminating, created again once it is gone.
+		case sameRelay(current, pod):
+			return nil
+		default:
+			callCtx, cancel := requestContext(ctx)
+			err = pods.Delete(callCtx, pod.Name, metav1.DeleteOptions{})
+			cancel()
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("stale relay pod %s/%s not replaced after %s", pod.Namespace, pod.Name, relayReadyTimeout)
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sameRelay tells whether the relay pod current is ours and relays like want.
+func sameRelay(current, want *corev1.Pod) bool {
+	if current.Labels[relayOwnerLabel] != relayOwner || len(current.Spec.Containers) != 1 {
+		return false
+	}
+	c, w := current.Spec.Containers[0], want.Spec.Containers[0]
+	return c.Image == w.Image && reflect.DeepEqual(c.Command, w.Command) && reflect.DeepEqual(c.Args, w.Args)
+}
+
+// relayPodName derives a stable, DNS-compatible relay pod name for a connection, unique
+// to the relayOwner.
+func relayPodName(connection model.Connection) string {
+	target := connection.ServiceName
+	if connection.PodName != "" {
+		target = connection.PodName
+	}
+	suffix := fmt.Sprintf("-%d-%s", connection.RemoteServicePort, relayOwner)
+	name := fmt.Sprintf("kpfm-%s-%s", relayKind(connection), invalidNameChars.ReplaceAllString(strings.ToLower(target), "-"))
+	if len(name)+len(suffix) > 63 {
+		name = strings.TrimRight(name[:63-len(suffix)], "-")
+	}
+	return name + suffix
+}
+
+// relayKind is the protocol a connection's relay pod connects to its target with.
+func relayKind(connection model.Connection) string {
+	if connection.IsUDP() {
+		return "udp"
+	}
+	return "tcp"
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
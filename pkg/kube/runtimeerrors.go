@@ -0,0 +1,57 @@
+package kube
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+)
+
+// portErrorPattern matches the local port in the errors client-go's port forwarder
+// reports through runtime.HandleError, e.g. "an error occurred forwarding 5432 -> 5432".
+var portErrorPattern = regexp.MustCompile(`(?:port|forwarding) (\d+)(?: ->|:)`)
+
+// portLogs are the connection logs by the local port their forward listens on.
+var portLogs = struct {
+	sync.Mutex
+	m map[int]*logging.Buffer
+}{m: make(map[int]*logging.Buffer)}
+
+func init() {
+	runtime.ErrorHandlers = append(runtime.ErrorHandlers, logPortError)
+}
+
+// registerPortLog routes the client-go errors naming port to w until the returned
+// function is called. A nil w or zero port registers nothing.
+func registerPortLog(port int, w *logging.Buffer) func() {
+	if port == 0 || w == nil {
+		return func() {}
+	}
+	portLogs.Lock()
+	portLogs.m[port] = w
+	portLogs.Unlock()
+	return func() {
+		portLogs.Lock()
+		defer portLogs.Unlock()
+		if portLogs.m[port] == w {
+			delete(portLogs.m, port)
+		}
+	}
+}
+
+func logPortError(err error) {
+	match := portErrorPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return
+	}
+	port, _ := strconv.Atoi(match[1])
+	portLogs.Lock()
+	w := portLogs.m[port]
+	portLogs.Unlock()
+	if w != nil {
+		w.Printf("%v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package kube
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+var (
+	randMu sync.Mutex
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Pod health thresholds SelectPod prefers pods by.
+const (
+	podStableAfter      = 30 * time.Second // Ready for at least this long
+	podRestartThreshold = 3                // restarts within podRestartWindow that mark a pod as flapping
+	podRestartWindow    = 10 * time.Minute
+)
+
+// SelectPod picks the pod a connection forwards to among its service's pods, following
+// its PodOrdinal or PodSelection. Without either it takes the first pod the API listed.
+// Pods that crash loop are passed over, and pods Ready for a while are preferred.
+func SelectPod(pods []corev1.Pod, connection model.Connection) (string, error) {
+	if len(pods) == 0 {
+		return "", ErrNoPods
+	}
+	if connection.PodOrdinal != nil {
+		return selectOrdinal(pods, connection)
+	}
+
+	if connection.PodSelection == model.PodNamePrefix {
+		if connection.PodNamePrefix == "" {
+			return "", fmt.Errorf("PodSelection %s needs a PodNamePrefix", model.PodNamePrefix)
+		}
+		var matching []corev1.Pod
+		for _, pod := range pods {
+			if strings.HasPrefix(pod.Name, connection.PodNamePrefix) {
+				matching = append(matching, pod)
+			}
+		}
+		if len(matching) == 0 {
+			return "", fmt.Errorf("%w matching prefix %s", ErrNoPods, connection.PodNamePrefix)
+		}
+		pods = matching
+	}
+	pods = healthiest(pods, time.Now())
+
+	switch connection.PodSelection {
+	case model.PodNewest, model.PodOldest:
+		picked := pods[0]
+		for _, pod := range pods[1:] {
+			newer := picked.CreationTimestamp.Before(&pod.CreationTimestamp)
+			older := pod.CreationTimestamp.Before(&picked.CreationTimestamp)
+			if (connection.PodSelection == model.PodNewest && newer) || (connection.PodSelection == model.PodOldest && older) {
+				picked = pod
+			}
+		}
+		return picked.Name, nil
+	case model.PodRandom:
+		randMu.Lock()
+		defer randMu.Unlock()
+		return pods[random.Intn(len(pods))].Name, nil
+	}
+	return pods[0].Name, nil
+}
+
+// healthiest narrows pods to the ones that have been Ready for podStableAfter and don't
+// crash loop, falling back to the ones that don't crash loop, and to all of them when
+// every pod does.
+func healthiest(pods []corev1.Pod, now time.Time) []corev1.Pod {
+	var healthy, stable []corev1.Pod
+	for i := range pods {
+		if reason := crashLooping(&pods[i], now); reason != "" {
+			logging.Verbosef("Passing over pod %s/%s: %s", pods[i].Namespace, pods[i].Name, reason)
+			continue
+		}
+		healthy = append(healthy, pods[i])
+		if readySince(&pods[i]).Before(now.Add(-podStableAfter)) {
+			stable = append(stable, pods[i])
+		}
+	}
+	switch {
+	case len(stable) > 0:
+		return stable
+	case len(healthy) > 0:
+		return healthy
+	}
+	return pods
+}
+
+// crashLooping says why a pod looks about to die, or returns "" if it doesn't: one of
+// its containers is in CrashLoopBackOff or keeps restarting.
+func crashLooping(pod *corev1.Pod, now time.Time) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if waiting := status.State.Waiting; waiting != nil && waiting.Reason == "CrashLoopBackOff" {
+			return fmt.Sprintf("container %s is in CrashLoopBackOff", status.Name)
+		}
+		last := status.LastTerminationState.Terminated
+		if status.RestartCount >= podRestartThreshold && last != nil && now.Sub(last.FinishedAt.Time) < podRestartWindow {
+			return fmt.Sprintf("container %s restarted %d times, last %s ago", status.Name, status.RestartCount, now.Sub(last.FinishedAt.Time).Round(time.Second))
+		}
+	}
+	return ""
+}
+
+// readySince returns when a pod last became Ready, or the current time if it isn't.
+func readySince(pod *corev1.Pod) time.Time {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+			return cond.LastTransitionTime.Time
+		}
+	}
+	return time.Now()
+}
+
+// selectOrdinal returns the StatefulSet pod with the connection's PodOrdinal. The
+// ordinal is read from the pod-index label, or from the name on clusters without it.
+func selectOrdinal(pods []corev1.Pod, connection model.Connection) (string, error) {
+	ordinal := strconv.Itoa(*connection.PodOrdinal)
+	inStatefulSet := false
+	for _, pod := range pods {
+		set := statefulSetOf(pod)
+		if set == "" {
+			continue
+		}
+		inStatefulSet = true
+		if index, ok := pod.Labels[podIndexLabel]; ok {
+			if index == ordinal {
+				return pod.Name, nil
+			}
+		} else if pod.Name == set+"-"+ordinal {
+			return pod.Name, nil
+		}
+	}
+	if !inStatefulSet {
+		return "", fmt.Errorf("PodOrdinal needs a StatefulSet but the pods of service %s/%s aren't part of one", connection.Namespace, connection.ServiceName)
+	}
+	return "", fmt.Errorf("%w with ordinal %s", ErrNoPods, ordinal)
+}
+
+// podIndexLabel carries the ordinal of StatefulSet pods since Kubernetes 1.28.
+const podIndexLabel = "apps.kubernetes.io/pod-index"
+
+// statefulSetOf returns the name of the StatefulSet owning pod, or "".
+func statefulSetOf(pod corev1.Pod) string {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "StatefulSet" {
+			return owner.Name
+		}
+	}
+	return ""
+}
@@ -3,35 +3,199 @@ package kube
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// ErrNoPods is returned when a service currently routes to no ready pods, e.g. while it
+// is scaled down or being redeployed.
+var ErrNoPods = errors.New("no ready pods found for this service")
+
+// Backoff bounds between the lookups of WaitForPod.
+const (
+	podWaitInitialDelay = time.Second
+	podWaitMaxDelay     = 15 * time.Second
 )
 
 // GetPodName returns the name of the first Pod associated with a Service.
-func GetPodName(clientset *kubernetes.Clientset, namespace, serviceName string) (string, error) {
-	service, err := clientset.CoreV1().Services(namespace).Get(context.Background(), serviceName, metav1.GetOptions{})
+func GetPodName(ctx context.Context, clientset kubernetes.Interface, namespace, serviceName string) (string, error) {
+	pods, err := ServicePods(ctx, clientset, namespace, serviceName)
 	if err != nil {
 		return "", err
 	}
+	return pods[0].Name, nil
+}
+
+// ServicePods returns the ready pods a Service routes to according to its endpoints, or
+// ErrNoPods when there are none. Services with a selector keep the API's pod order.
+func ServicePods(ctx context.Context, clientset kubernetes.Interface, namespace, serviceName string) ([]corev1.Pod, error) {
+	callCtx, cancel := requestContext(ctx)
+	defer cancel()
+	service, err := clientset.CoreV1().Services(namespace).Get(callCtx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-	// need to handle multiple endpoints, subsets, and potential lack of endpoints.
-	if len(service.Spec.Selector) == 0 {
-		return "", errors.New("service has no selector")
+	if service.Spec.Type == corev1.ServiceTypeExternalName {
+		return nil, fmt.Errorf("service %s/%s is an ExternalName service for %s and has no pods; set Relay: true to forward through a relay pod", namespace, serviceName, service.Spec.ExternalName)
 	}
 
-	podList, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
-		LabelSelector: labels.Set(service.Spec.Selector).String(),
+	ready, err := readyEndpointPods(ctx, clientset, namespace, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if len(ready) == 0 && len(service.Spec.Selector) == 0 {
+		return nil, fmt.Errorf("service %s/%s has no selector and no ready pod endpoints; set Relay: true to forward to its endpoints through a relay pod", namespace, serviceName)
+	}
+	if len(ready) == 0 {
+		return nil, ErrNoPods
+	}
+
+	var pods []corev1.Pod
+	if len(service.Spec.Selector) > 0 {
+		callCtx, cancel := requestContext(ctx)
+		defer cancel()
+		podList, err := clientset.CoreV1().Pods(namespace).List(callCtx, metav1.ListOptions{
+			LabelSelector: labels.Set(service.Spec.Selector).String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range podList.Items {
+			if ready[pod.Name] {
+				pods = append(pods, pod)
+			}
+		}
+	} else {
+		// Custom endpoints: fetch the pods they name.
+		names := make([]string, 0, len(ready))
+		for name := range ready {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			callCtx, cancel := requestContext(ctx)
+			pod, err := clientset.CoreV1().Pods(namespace).Get(callCtx, name, metav1.GetOptions{})
+			cancel()
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			pods = append(pods, *pod)
+		}
+	}
+
+	if len(pods) == 0 {
+		return nil, ErrNoPods
+	}
+	return pods, nil
+}
+
+// WaitForPod polls with backoff until the service of a connection has a pod to forward to, its
+// WaitForPodTimeout expires or ctx is cancelled. It returns early on any other error,
+// leaving it to the forward setup to report.
+func WaitForPod(ctx context.Context, connection model.Connection, kubeContext string) {
+	if connection.ServiceName == "" || connection.WaitForPodTimeout <= 0 {
+		return
+	}
+	deadline := time.Now().Add(time.Duration(connection.WaitForPodTimeout))
+	delay := podWaitInitialDelay
+	for {
+		_, clientset, err := Clientset(kubeContext)
+		if err != nil {
+			return
+		}
+		if _, err := ResolvePodName(ctx, clientset, connection); !errors.Is(err, ErrNoPods) {
+			return
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		logging.Connectionf(ctx, "No pods for %s/%s yet, looking again in %s", connection.Namespace, connection.Target(), delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		delay *= 2
+		if delay > podWaitMaxDelay {
+			delay = podWaitMaxDelay
+		}
+	}
+}
+
+// ListServices returns the services in namespace matching the label selector.
+// An empty namespace lists services across all namespaces.
+func ListServices(ctx context.Context, clientset kubernetes.Interface, namespace, selector string) ([]corev1.Service, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+	list, err := clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return list.Items, nil
+}
 
-	if len(podList.Items) == 0 {
-		return "", errors.New("no pods found for this service")
+// ExpandAllServices returns one connection per TCP port of every service in the
+// namespace of an AllServices connection, forwarded from PortOffset plus the service port
+// to the port of the pods it targets.
+// Services without a selector are skipped since their endpoints rarely point at pods.
+func ExpandAllServices(ctx context.Context, clientset kubernetes.Interface, wildcard model.Connection) ([]model.Connection, error) {
+	services, err := ListServices(ctx, clientset, wildcard.Namespace, "")
+	if err != nil {
+		return nil, err
 	}
 
-	// Return the name of the first Pod
-	return podList.Items[0].Name, nil
+	var connections []model.Connection
+	for _, svc := range services {
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		mappings, err := PortMappings(ctx, clientset, &svc)
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range mappings {
+			if port.Protocol != "" && port.Protocol != corev1.ProtocolTCP {
+				continue
+			}
+			remotePort := port.RemotePort()
+			if remotePort == 0 {
+				// A later listing retries once a pod declares the named port.
+				logging.Verbosef("Skipping port %d of %s/%s: target port %s not declared by its pods", port.Port, svc.Namespace, svc.Name, port.TargetPort.String())
+				continue
+			}
+			connection := wildcard
+			connection.Name = ""
+			connection.Pinned = false // the services follow the current context
+			connection.AllServices = false
+			connection.PortOffset = 0
+			connection.ServiceName = svc.Name
+			connection.RemoteServicePort = int(remotePort)
+			connection.LocalPort = wildcard.PortOffset + int(port.Port)
+			connections = append(connections, connection)
+		}
+	}
+	return connections, nil
 }
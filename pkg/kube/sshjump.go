@@ -0,0 +1,164 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+const sshTunnelTimeout = 20 * time.Second
+
+// jumpHosts holds the configured SSH jump hosts per kube context and the ssh tunnels
+// opened through them, shared by every client of the same API server.
+var jumpHosts = struct {
+	sync.Mutex
+	byContext map[string]model.SSHJumpHost
+	tunnels   map[string]*sshTunnel
+}{byContext: make(map[string]model.SSHJumpHost), tunnels: make(map[string]*sshTunnel)}
+
+// sshTunnel is an `ssh -N -L` process forwarding a local port to an API server.
+type sshTunnel struct {
+	cmd       *exec.Cmd
+	localPort int
+	done      chan struct{}
+}
+
+func (t *sshTunnel) alive() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// SetJumpHosts registers the SSHJumpHost of every configured context, so clients for
+// those contexts reach the API server through it.
+func SetJumpHosts(cfg *model.Contexts) {
+	jumpHosts.Lock()
+	defer jumpHosts.Unlock()
+	for _, ctx := range cfg.Contexts {
+		if ctx.SSHJumpHost != nil {
+			jumpHosts.byContext[ctx.Name] = *ctx.SSHJumpHost
+		}
+	}
+}
+
+// CloseJumpHosts stops every ssh tunnel kpfm opened.
+func CloseJumpHosts() {
+	jumpHosts.Lock()
+	defer jumpHosts.Unlock()
+	for key, tunnel := range jumpHosts.tunnels {
+		tunnel.cmd.Process.Kill()
+		<-tunnel.done
+		delete(jumpHosts.tunnels, key)
+	}
+}
+
+// routeThroughJumpHost points config at a local ssh tunnel to its API server when
+// kubeContext has a jump host. The TLS server name is kept so certificates still verify.
+func routeThroughJumpHost(kubeContext string, config *rest.Config) (*sshTunnel, error) {
+	jumpHosts.Lock()
+	defer jumpHosts.Unlock()
+
+	jump, ok := jumpHosts.byContext[kubeContext]
+	if !ok {
+		return nil, nil
+	}
+
+	apiURL, err := url.Parse(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	apiPort := apiURL.Port()
+	if apiPort == "" {
+		apiPort = "443"
+	}
+	target := net.JoinHostPort(apiURL.Hostname(), apiPort)
+
+	key := jump.Host + " " + target
+	tunnel, ok := jumpHosts.tunnels[key]
+	if !ok || !tunnel.alive() {
+		tunnel, err = openSSHTunnel(jump, target)
+		if err != nil {
+			return nil, fmt.Errorf("cannot tunnel to %s through %s: %v", target, jump.Host, err)
+		}
+		jumpHosts.tunnels[key] = tunnel
+	}
+
+	if config.TLSClientConfig.ServerName == "" {
+		config.TLSClientConfig.ServerName = apiURL.Hostname()
+	}
+	apiURL.Host = net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.localPort))
+	config.Host = apiURL.String()
+	return tunnel, nil
+}
+
+// openSSHTunnel starts ssh forwarding a free local port to target and waits until the
+// port accepts connections.
+func openSSHTunnel(jump model.SSHJumpHost, target string) (*sshTunnel, error) {
+	localPort, err := freeLocalPort()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "BatchMode=yes",
+		"-o", "ServerAliveInterval=15",
+		"-L", fmt.Sprintf("127.0.0.1:%d:%s", localPort, target),
+	}
+	if jump.IdentityFile != "" {
+		args = append(args, "-i", jump.IdentityFile)
+	}
+	for _, option := range jump.Options {
+		args = append(args, "-o", option)
+	}
+	destination := jump.Host
+	if host, port, err := net.SplitHostPort(jump.Host); err == nil {
+		destination = host
+		args = append(args, "-p", port)
+	}
+	args = append(args, destination)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	tunnel := &sshTunnel{cmd: cmd, localPort: localPort, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(tunnel.done)
+	}()
+
+	deadline := time.Now().Add(sshTunnelTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-tunnel.done:
+			return nil, fmt.Errorf("ssh exited: %s", strings.TrimSpace(stderr.String()))
+		case <-time.After(100 * time.Millisecond):
+		}
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)))
+		if err == nil {
+			conn.Close()
+			logging.Verbosef("Opened ssh tunnel 127.0.0.1:%d -> %s via %s", localPort, target, jump.Host)
+			return tunnel, nil
+		}
+	}
+	cmd.Process.Kill()
+	return nil, fmt.Errorf("timed out waiting for ssh")
+}
@@ -0,0 +1,24 @@
+package kube
+
+import (
+	"context"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// requestTimeout bounds every single API call, so a hung API server fails the call
+// instead of wedging startup.
+var requestTimeout = model.DefaultRequestTimeout
+
+// SetRequestTimeout sets how long a single API call may take; zero keeps the default.
+func SetRequestTimeout(d time.Duration) {
+	if d > 0 {
+		requestTimeout = d
+	}
+}
+
+// requestContext returns ctx bounded by the request timeout, for one API call.
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, requestTimeout)
+}
@@ -0,0 +1,202 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+)
+
+const tunnelOpenTimeout = 30 * time.Second
+
+// Tunnels opens port-forwards to service ports on demand and shares them between the
+// connections made to the same service port.
+type Tunnels struct {
+	kubeContext string
+
+	mu   sync.Mutex
+	open map[string]*tunnel
+}
+
+// tunnel is a forward listening on an internal local port.
+type tunnel struct {
+	ready     chan struct{} // closed once the forward is up or failed to come up
+	err       error
+	localPort int
+	stopChan  chan struct{}
+	done      chan struct{}
+}
+
+// NewTunnels returns a tunnel pool for kubeContext (the current context when empty).
+func NewTunnels(kubeContext string) *Tunnels {
+	return &Tunnels{kubeContext: kubeContext, open: make(map[string]*tunnel)}
+}
+
+// Dial connects to port of a service, opening a forward to one of its pods if none is open.
+func (t *Tunnels) Dial(ctx context.Context, namespace, service string, port int) (net.Conn, error) {
+	key := fmt.Sprintf("%s/%s:%d", namespace, service, port)
+
+	t.mu.Lock()
+	tun, ok := t.open[key]
+	if ok {
+		select {
+		case <-tun.done:
+			// The forward went away, e.g. because its pod was deleted; open a new one.
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		tun = &tunnel{ready: make(chan struct{}), stopChan: make(chan struct{}), done: make(chan struct{})}
+		t.open[key] = tun
+		go t.start(tun, namespace, service, port)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-tun.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if tun.err != nil {
+		t.drop(key, tun)
+		return nil, tun.err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tun.localPort)))
+	if err != nil {
+		t.drop(key, tun)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Close stops every open forward.
+func (t *Tunnels) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, tun := range t.open {
+		close(tun.stopChan)
+		delete(t.open, key)
+	}
+}
+
+// drop forgets a tunnel so the next Dial opens a new one.
+func (t *Tunnels) drop(key string, tun *tunnel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open[key] == tun {
+		close(tun.stopChan)
+		delete(t.open, key)
+	}
+}
+
+// start opens the forward of a tunnel and closes tun.ready once it is usable.
+func (t *Tunnels) start(tun *tunnel, namespace, service string, port int) {
+	fail := func(err error) {
+		tun.err = err
+		close(tun.done)
+		close(tun.ready)
+	}
+
+	config, clientset, err := Clientset(t.kubeContext)
+	if err != nil {
+		fail(err)
+		return
+	}
+	// The tunnel outlives the dial that opened it, so only the per-call timeout applies.
+	podName, podPort, err := resolveServicePort(context.Background(), clientset, namespace, service, port)
+	if err != nil {
+		fail(err)
+		return
+	}
+	tun.localPort, err = freeLocalPort()
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	readyChan := make(chan struct{})
+	fw, err := newForwarder(config, clientset, namespace, podName, loopbackAddresses, tun.localPort, podPort, tun.stopChan, readyChan, io.Discard)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- fw.ForwardPorts()
+		close(tun.done)
+	}()
+
+	select {
+	case <-readyChan:
+		logging.Verbosef("Opened tunnel to %s/%s:%d via pod %s port %d", namespace, service, port, podName, podPort)
+		close(tun.ready)
+	case err := <-errChan:
+		if err == nil {
+			err = fmt.Errorf("forward to %s/%s closed", namespace, podName)
+		}
+		tun.err = err
+		close(tun.ready)
+	case <-time.After(tunnelOpenTimeout):
+		tun.err = fmt.Errorf("timed out forwarding to %s/%s", namespace, podName)
+		close(tun.ready)
+	}
+}
+
+// resolveServicePort picks a pod behind a service and the container port its service
+// port targets.
+func resolveServicePort(ctx context.Context, clientset kubernetes.Interface, namespace, service string, port int) (string, int, error) {
+	callCtx, cancel := requestContext(ctx)
+	defer cancel()
+	svc, err := clientset.CoreV1().Services(namespace).Get(callCtx, service, metav1.GetOptions{})
+	if err != nil {
+		return "", 0, err
+	}
+	var servicePort *corev1.ServicePort
+	for i, sp := range svc.Spec.Ports {
+		if int(sp.Port) == port && sp.Protocol != corev1.ProtocolUDP {
+			servicePort = &svc.Spec.Ports[i]
+		}
+	}
+	if servicePort == nil {
+		return "", 0, fmt.Errorf("service %s/%s has no TCP port %d", namespace, service, port)
+	}
+
+	podName, err := GetPodName(ctx, clientset, namespace, service)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch {
+	case servicePort.TargetPort.Type == intstr.String:
+		callCtx, cancel := requestContext(ctx)
+		defer cancel()
+		pod, err := clientset.CoreV1().Pods(namespace).Get(callCtx, podName, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, err
+		}
+		for _, cp := range declaredPorts(pod) {
+			if cp.Name == servicePort.TargetPort.StrVal {
+				return podName, int(cp.Port), nil
+			}
+		}
+		return "", 0, fmt.Errorf("pod %s/%s has no port named %s", namespace, podName, servicePort.TargetPort.StrVal)
+	case servicePort.TargetPort.IntVal != 0:
+		return podName, int(servicePort.TargetPort.IntVal), nil
+	default:
+		return podName, port, nil
+	}
+}
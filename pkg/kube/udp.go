@@ -0,0 +1,310 @@
+package kube
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+const (
+	udpSessionTimeout = 2 * time.Minute
+	udpMaxDatagram    = 64 * 1024
+)
+
+// udpRelayScript is the relay of UDP relay pods, run as python3 -c udpRelayScript
+// <listen port> <target host> <target port>. Like the udpForwarder, it frames each
+// datagram on the TCP leg with its length as 2 bytes big-endian, so datagrams keep
+// their boundaries over the stream. Every TCP connection gets its own UDP socket.
+const udpRelayScript = `import socket, struct, sys, threading
+
+port, host, target = int(sys.argv[1]), sys.argv[2], int(sys.argv[3])
+
+def read(c, n):
+    b = b''
+    while len(b) < n:
+        d = c.recv(n - len(b))
+        if not d:
+            raise EOFError
+        b += d
+    return b
+
+def stop(c, u):
+    for s in (c, u):
+        try:
+            s.shutdown(socket.SHUT_RDWR)
+        except OSError:
+            pass
+        s.close()
+
+def up(c, u):
+    try:
+        while True:
+            u.send(read(c, struct.unpack('>H', read(c, 2))[0]))
+    except Exception:
+        pass
+    stop(c, u)
+
+def down(c, u):
+    try:
+        while True:
+            d = u.recv(65535)
+            c.sendall(struct.pack('>H', len(d)) + d)
+    except Exception:
+        pass
+    stop(c, u)
+
+def serve(c):
+    try:
+        family, kind, proto, _, addr = socket.getaddrinfo(host, target, 0, socket.SOCK_DGRAM)[0]
+        u = socket.socket(family, kind, proto)
+        u.connect(addr)
+    except OSError as e:
+        print('cannot reach %s:%d: %s' % (host, target, e), flush=True)
+        c.close()
+        return
+    threading.Thread(target=down, args=(c, u), daemon=True).start()
+    up(c, u)
+
+l = socket.socket()
+l.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+l.bind(('', port))
+l.listen(64)
+while True:
+    c, _ = l.accept()
+    threading.Thread(target=serve, args=(c,), daemon=True).start()
+`
+
+// udpForwarder serves a local UDP port by relaying each peer's datagrams over a TCP
+// port-forward to a relay pod running udpRelayScript, which sends them on to the UDP
+// target. Datagrams are length-prefixed on the TCP leg to keep their boundaries.
+type udpForwarder struct {
+	clientset  kubernetes.Interface
+	namespace  string
+	relayPod   string
+	address    string // local address the UDP socket binds to
+	localPort  int
+	tcpPort    int
+	inner      Forwarder
+	innerStop  chan struct{}
+	innerReady chan struct{}
+	stopChan   <-chan struct{}
+	readyChan  chan struct{}
+	out        io.Writer
+
+	mu       sync.Mutex
+	sessions map[string]net.Conn
+}
+
+// newUDPForwarder starts (or reuses) the relay pod for a UDP connection and prepares the
+// TCP forward to it.
+func newUDPForwarder(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, connection model.Connection, podName string, stopChan, readyChan chan struct{}, out io.Writer) (Forwarder, error) {
+	target, err := udpTarget(ctx, clientset, connection, podName)
+	if err != nil {
+		return nil, err
+	}
+
+	relayPod, err := ensureRelay(ctx, clientset, connection, target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start UDP relay: %v", err)
+	}
+
+	tcpPort, err := freeLocalPort()
+	if err != nil {
+		return nil, err
+	}
+
+	innerStop := make(chan struct{})
+	innerReady := make(chan struct{})
+	inner, err := newForwarder(config, clientset, connection.Namespace, relayPod, loopbackAddresses, tcpPort, connection.RemoteServicePort, innerStop, innerReady, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpForwarder{
+		clientset:  clientset,
+		namespace:  connection.Namespace,
+		relayPod:   relayPod,
+		address:    connection.BindAddresses()[0],
+		localPort:  connection.LocalPort,
+		tcpPort:    tcpPort,
+		inner:      inner,
+		innerStop:  innerStop,
+		innerReady: innerReady,
+		stopChan:   stopChan,
+		readyChan:  readyChan,
+		out:        out,
+		sessions:   make(map[string]net.Conn),
+	}, nil
+}
+
+// ForwardPorts relays UDP traffic until stopChan is closed or the TCP forward fails.
+func (f *udpForwarder) ForwardPorts() error {
+	innerErr := make(chan error, 1)
+	go func() {
+		innerErr <- f.inner.ForwardPorts()
+	}()
+	defer close(f.innerStop)
+
+	select {
+	case <-f.innerReady:
+	case err := <-innerErr:
+		return err
+	case <-f.stopChan:
+		f.deleteRelay()
+		return nil
+	}
+
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(f.address, strconv.Itoa(f.localPort)))
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	fmt.Fprintf(f.out, "Forwarding from %s/udp -> pod %s/%s\n", pc.LocalAddr(), f.namespace, f.relayPod)
+	close(f.readyChan)
+
+	go f.serve(pc)
+
+	select {
+	case <-f.stopChan:
+		f.closeSessions()
+		f.deleteRelay()
+		return nil
+	case err := <-innerErr:
+		f.closeSessions()
+		if err == nil {
+			err = fmt.Errorf("UDP relay forward closed")
+		}
+		return err
+	}
+}
+
+// serve reads datagrams from local peers and writes them to each peer's TCP session.
+func (f *udpForwarder) serve(pc net.PacketConn) {
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, peer, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		session, err := f.session(pc, peer)
+		if err != nil {
+			fmt.Fprintf(f.out, "Cannot open UDP relay session for %s: %v\n", peer, err)
+			continue
+		}
+		session.SetDeadline(time.Now().Add(udpSessionTimeout))
+		if _, err := session.Write(frameDatagram(buf[:n])); err != nil {
+			f.dropSession(peer.String(), session)
+		}
+	}
+}
+
+// session returns the TCP session for peer, opening it on first use.
+func (f *udpForwarder) session(pc net.PacketConn, peer net.Addr) (net.Conn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if conn, ok := f.sessions[peer.String()]; ok {
+		return conn, nil
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(f.tcpPort)))
+	if err != nil {
+		return nil, err
+	}
+	f.sessions[peer.String()] = conn
+
+	// Send replies back to the peer until the session idles out.
+	go func() {
+		buf := make([]byte, udpMaxDatagram)
+		for {
+			n, err := readDatagram(conn, buf)
+			if err != nil {
+				f.dropSession(peer.String(), conn)
+				return
+			}
+			conn.SetDeadline(time.Now().Add(udpSessionTimeout))
+			pc.WriteTo(buf[:n], peer)
+		}
+	}()
+	return conn, nil
+}
+
+// frameDatagram prefixes a datagram with its length for the TCP leg.
+func frameDatagram(datagram []byte) []byte {
+	frame := make([]byte, 2+len(datagram))
+	binary.BigEndian.PutUint16(frame, uint16(len(datagram)))
+	copy(frame[2:], datagram)
+	return frame
+}
+
+// readDatagram reads the next length-prefixed datagram of the TCP leg into buf.
+func readDatagram(r io.Reader, buf []byte) (int, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (f *udpForwarder) dropSession(key string, conn net.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sessions[key] == conn {
+		delete(f.sessions, key)
+	}
+	conn.Close()
+}
+
+func (f *udpForwarder) closeSessions() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, conn := range f.sessions {
+		conn.Close()
+		delete(f.sessions, key)
+	}
+}
+
+func (f *udpForwarder) deleteRelay() {
+	deleteRelayPod(f.clientset, f.namespace, f.relayPod, f.out)
+}
+
+// udpTarget returns the in-cluster host the relay sends datagrams to.
+func udpTarget(ctx context.Context, clientset kubernetes.Interface, connection model.Connection, podName string) (string, error) {
+	if connection.PodName == "" && connection.ServiceName != "" {
+		return fmt.Sprintf("%s.%s.svc", connection.ServiceName, connection.Namespace), nil
+	}
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+	pod, err := clientset.CoreV1().Pods(connection.Namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("pod %s has no IP yet", podName)
+	}
+	return pod.Status.PodIP, nil
+}
+
+// freeLocalPort asks the OS for an unused local TCP port.
+func freeLocalPort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,313 @@
+package kube
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+)
+
+// Port-forward transports negotiated per cluster.
+const (
+	TransportWebSocket = "websocket"
+	TransportSPDY      = "spdy"
+)
+
+// Channel protocol spoken by the kubelet's WebSocket port-forward handler. Every
+// message is prefixed with its channel byte; each forwarded port uses a data and an
+// error channel, and the first message on each channel carries the port number.
+const (
+	websocketProtocol    = "v4.channel.k8s.io"
+	websocketDataChan    = 0
+	websocketErrorChan   = 1
+	websocketDialTimeout = 30 * time.Second
+)
+
+// websocketRetryAfter is how long a cluster that rejected the WebSocket handshake is
+// kept on SPDY before it is tried again.
+const websocketRetryAfter = 10 * time.Minute
+
+// negotiation is the transport chosen, or being chosen, for an API server host.
+type negotiation struct {
+	done      chan struct{} // closed once transport is set
+	transport string
+	expires   time.Time // zero when the choice holds for good
+}
+
+// expired tells whether the choice was made and has to be made again.
+func (n *negotiation) expired() bool {
+	select {
+	case <-n.done:
+		return !n.expires.IsZero() && time.Now().After(n.expires)
+	default:
+		return false
+	}
+}
+
+// negotiated caches the transport chosen for each API server host.
+var negotiated = struct {
+	sync.Mutex
+	byHost map[string]*negotiation
+}{byHost: make(map[string]*negotiation)}
+
+// negotiateTransport picks the port-forward transport for the cluster behind config.
+// It tries a WebSocket handshake against pfURL once per API server and falls back to
+// SPDY when the upgrade fails, trying again after websocketRetryAfter. Forwards to a
+// cluster being negotiated wait for the handshake, forwards to other clusters don't.
+func negotiateTransport(config *rest.Config, pfURL *url.URL, remotePort int) string {
+	negotiated.Lock()
+	n, ok := negotiated.byHost[config.Host]
+	if !ok || n.expired() {
+		n = &negotiation{done: make(chan struct{})}
+		negotiated.byHost[config.Host] = n
+		ok = false
+	}
+	negotiated.Unlock()
+	if ok {
+		<-n.done
+		return n.transport
+	}
+
+	n.transport = TransportWebSocket
+	ws, err := dialWebsocket(config, pfURL, remotePort)
+	if err != nil {
+		logging.Verbosef("WebSocket port-forward rejected by %s, falling back to SPDY: %v", config.Host, err)
+		n.transport = TransportSPDY
+		n.expires = time.Now().Add(websocketRetryAfter)
+	} else {
+		ws.Close()
+	}
+	logging.Verbosef("Negotiated %s port-forward transport for %s", n.transport, config.Host)
+	close(n.done)
+	return n.transport
+}
+
+// dialWebsocket opens a WebSocket port-forward stream to remotePort, authenticating
+// with the same TLS settings and auth wrappers client-go would use.
+func dialWebsocket(config *rest.Config, pfURL *url.URL, remotePort int) (*websocket.Conn, error) {
+	tc, err := config.TransportConfig()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := transport.TLSConfigFor(tc)
+	if err != nil {
+		return nil, err
+	}
+
+	location := *pfURL
+	switch location.Scheme {
+	case "https":
+		location.Scheme = "wss"
+	case "http":
+		location.Scheme = "ws"
+	}
+	query := location.Query()
+	query.Set("ports", strconv.Itoa(remotePort))
+	location.RawQuery = query.Encode()
+
+	header, err := authHeaders(tc, location.String())
+	if err != nil {
+		return nil, err
+	}
+
+	wsConfig, err := websocket.NewConfig(location.String(), "http://localhost")
+	if err != nil {
+		return nil, err
+	}
+	wsConfig.Protocol = []string{websocketProtocol}
+	wsConfig.Header = header
+	wsConfig.TlsConfig = tlsConfig
+	wsConfig.Dialer = &net.Dialer{Timeout: websocketDialTimeout}
+
+	ws, err := websocket.DialConfig(wsConfig)
+	if err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// authHeaders runs a request through the config's HTTP wrappers (bearer tokens,
+// exec plugins, impersonation...) and returns the headers they set.
+func authHeaders(tc *transport.Config, location string) (http.Header, error) {
+	var captured http.Header
+	rt, err := transport.HTTPWrappersForConfig(tc, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		captured = req.Header.Clone()
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return captured, nil
+}
+
+// websocketForwarder is the WebSocket counterpart of client-go's SPDY PortForwarder.
+// It opens one WebSocket stream per accepted local connection.
+type websocketForwarder struct {
+	config     *rest.Config
+	pfURL      *url.URL
+	addresses  []string
+	localPort  int
+	remotePort int
+	stopChan   <-chan struct{}
+	readyChan  chan struct{}
+	failed     chan error // receives the error that ends the forward, e.g. rejected credentials
+	out        io.Writer
+	errOut     io.Writer
+}
+
+// ForwardPorts listens on the local port until stopChan is closed, or until a stream is
+// refused because the credentials were rejected.
+func (f *websocketForwarder) ForwardPorts() error {
+	var listeners []net.Listener
+	for _, address := range f.addresses {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(f.localPort)))
+		if err != nil {
+			fmt.Fprintf(f.errOut, "Unable to listen on %s:%d: %v\n", address, f.localPort, err)
+			continue
+		}
+		fmt.Fprintf(f.out, "Forwarding from %s -> %d\n", listener.Addr(), f.remotePort)
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("unable to listen on any of the requested ports: [%d:%d]", f.localPort, f.remotePort)
+	}
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	for _, listener := range listeners {
+		go f.accept(listener)
+	}
+	if f.readyChan != nil {
+		close(f.readyChan)
+	}
+
+	select {
+	case <-f.stopChan:
+		return nil
+	case err := <-f.failed:
+		return err
+	}
+}
+
+func (f *websocketForwarder) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener is closed when the forwarder stops.
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// handle pipes a local connection through its own WebSocket stream.
+func (f *websocketForwarder) handle(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(f.out, "Handling connection for %d\n", f.localPort)
+
+	ws, err := dialWebsocket(f.config, f.pfURL, f.remotePort)
+	if err != nil {
+		fmt.Fprintf(f.errOut, "error creating WebSocket stream for port %d -> %d: %v\n", f.localPort, f.remotePort, err)
+		if credentialsRejected(f.config, err) {
+			select {
+			case f.failed <- fmt.Errorf("%w: %v", errCredentialsRejected, err):
+			default:
+			}
+		}
+		return
+	}
+	defer ws.Close()
+
+	done := make(chan struct{}, 2)
+
+	// Remote to local
+	go func() {
+		defer func() { done <- struct{}{} }()
+		var seen [2]bool
+		for {
+			var msg []byte
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+			if len(msg) == 0 {
+				continue
+			}
+			channel, data := msg[0], msg[1:]
+			if channel > websocketErrorChan {
+				continue
+			}
+			// Skip the port number announced at the start of each channel.
+			if !seen[channel] {
+				seen[channel] = true
+				if len(data) < 2 {
+					continue
+				}
+				data = data[2:]
+			}
+			if len(data) == 0 {
+				continue
+			}
+			if channel == websocketErrorChan {
+				fmt.Fprintf(f.errOut, "error forwarding port %d to pod: %s\n", f.remotePort, data)
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Local to remote
+	go func() {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				frame := append([]byte{websocketDataChan}, buf[:n]...)
+				if sendErr := websocket.Message.Send(ws, frame); sendErr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-f.stopChan:
+	}
+}
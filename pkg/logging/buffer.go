@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Line is one line of a connection's log.
+type Line struct {
+	Time time.Time
+	Text string
+}
+
+// Buffer keeps the last lines logged for one connection, and hands new ones to followers,
+// for `kpfm logs`. A nil Buffer discards what is written to it.
+type Buffer struct {
+	mu        sync.Mutex
+	lines     []Line
+	max       int
+	partial   []byte // the start of a line not terminated yet
+	followers map[chan Line]struct{}
+
+	suppressed atomic.Int64 // forwarder output lines the filter dropped
+}
+
+// NewBuffer returns a buffer keeping the last max lines.
+func NewBuffer(max int) *Buffer {
+	return &Buffer{max: max, followers: make(map[chan Line]struct{})}
+}
+
+// Write logs every complete line of p.
+func (b *Buffer) Write(p []byte) (int, error) {
+	if b == nil {
+		return len(p), nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.partial = append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+		b.add(strings.TrimRight(string(b.partial[:i]), "\r"))
+		b.partial = b.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Printf logs a message.
+func (b *Buffer) Printf(format string, args ...interface{}) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(fmt.Sprintf(format, args...))
+}
+
+// add appends a line and hands it to the followers, dropping it for those that fall
+// behind. b.mu must be held.
+func (b *Buffer) add(text string) {
+	line := Line{Time: time.Now(), Text: text}
+	b.lines = append(b.lines, line)
+	if len(b.lines) > b.max {
+		b.lines = b.lines[len(b.lines)-b.max:]
+	}
+	for ch := range b.followers {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// suppress counts a line the filter dropped.
+func (b *Buffer) suppress() {
+	if b != nil {
+		b.suppressed.Add(1)
+	}
+}
+
+// Suppressed returns how many forwarder output lines the filter dropped.
+func (b *Buffer) Suppressed() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.suppressed.Load()
+}
+
+// Follow returns the lines kept so far, a channel receiving the lines logged from now on
+// and a function ending the subscription.
+func (b *Buffer) Follow() ([]Line, <-chan Line, func()) {
+	ch := make(chan Line, 256)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := append([]Line(nil), b.lines...)
+	b.followers[ch] = struct{}{}
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.followers, ch)
+	}
+	return lines, ch, cancel
+}
+
+type bufferKey struct{}
+
+// WithBuffer returns a context carrying the log of the connection it is used for.
+func WithBuffer(ctx context.Context, b *Buffer) context.Context {
+	return context.WithValue(ctx, bufferKey{}, b)
+}
+
+// FromContext returns the connection log ctx carries, or nil.
+func FromContext(ctx context.Context) *Buffer {
+	b, _ := ctx.Value(bufferKey{}).(*Buffer)
+	return b
+}
+
+// ForwarderOutput is where forwarders write their per-connection chatter ("Forwarding
+// from...", "Handling connection for..."): the connection log ctx carries, and stdout
+// with -vv, less the lines the filter drops.
+func ForwarderOutput(ctx context.Context) io.Writer {
+	b := FromContext(ctx)
+	switch {
+	case Enabled(Debug) && b != nil:
+		return &filterWriter{w: io.MultiWriter(os.Stdout, b), b: b}
+	case Enabled(Debug):
+		return &filterWriter{w: os.Stdout}
+	case b != nil:
+		return &filterWriter{w: b, b: b}
+	}
+	return io.Discard
+}
+
+// Connectionf logs a message to the connection log ctx carries, and like Verbosef.
+func Connectionf(ctx context.Context, format string, args ...interface{}) {
+	FromContext(ctx).Printf(format, args...)
+	Verbosef(format, args...)
+}
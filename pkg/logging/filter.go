@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// handlingConnection matches the line client-go's port forwarder prints for every local
+// connection, which kpfm drops by default.
+var handlingConnection = regexp.MustCompile(`^Handling connection for \d+$`)
+
+// Never is a verbosity kpfm never reaches, for lines the filter always drops.
+const Never = Debug + 1
+
+// filter is the process-wide forwarder output filter set by SetFilter.
+var filter = struct {
+	sync.RWMutex
+	patterns []*regexp.Regexp
+	level    Level
+}{patterns: []*regexp.Regexp{handlingConnection}, level: Never}
+
+// SetFilter makes the forwarder output drop the "Handling connection for" lines, if
+// dropHandling, and the lines matching patterns, unless kpfm's verbosity reaches showFrom.
+func SetFilter(dropHandling bool, patterns []*regexp.Regexp, showFrom Level) {
+	filter.Lock()
+	defer filter.Unlock()
+	filter.patterns = nil
+	if dropHandling {
+		filter.patterns = append(filter.patterns, handlingConnection)
+	}
+	filter.patterns = append(filter.patterns, patterns...)
+	filter.level = showFrom
+}
+
+// dropped reports whether the filter drops line.
+func dropped(line []byte) bool {
+	filter.RLock()
+	defer filter.RUnlock()
+	if Enabled(filter.level) {
+		return false
+	}
+	for _, pattern := range filter.patterns {
+		if pattern.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterWriter passes the lines written to it on to w unless the filter drops them, in
+// which case they only count in b.
+type filterWriter struct {
+	w io.Writer
+	b *Buffer
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (f *filterWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partial = append(f.partial, p...)
+	for {
+		i := bytes.IndexByte(f.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := f.partial[:i+1]
+		f.partial = f.partial[i+1:]
+		if dropped(bytes.TrimRight(line, "\r\n")) {
+			f.b.suppress()
+		} else if _, err := f.w.Write(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
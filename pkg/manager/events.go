@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+)
+
+// EventType identifies a forward lifecycle transition.
+type EventType string
+
+const (
+	EventStarting       EventType = "starting"
+	EventRestarting     EventType = "restarting"
+	EventPodResolved    EventType = "pod-resolved" // the forward came back on a different pod
+	EventReady          EventType = "ready"
+	EventFailed         EventType = "failed"
+	EventStopped        EventType = "stopped"
+	EventIdle           EventType = "idle"
+	EventBroken         EventType = "broken"
+	EventPaused         EventType = "paused"
+	EventWaiting        EventType = "waiting" // held back until the connections it DependsOn are ready
+	EventContextChanged EventType = "context-changed"
+)
+
+// Event is published to subscribers on every lifecycle transition.
+type Event struct {
+	Type        EventType
+	Time        time.Time
+	Context     string
+	Name        string // the forward's name, as used by Status and the control commands
+	ServiceName string
+	Pod         string // the pod a ready forward goes to
+	Err         error
+	Labels      map[string]string // the Labels of the forward's connection
+}
+
+const (
+	// subscriberBuffer is how many events a slow subscriber may lag behind before events are dropped.
+	subscriberBuffer = 64
+	// historySize is how many past events SubscribeWithHistory returns.
+	historySize = 100
+)
+
+// subscriber is a subscription to the events of a Manager. Each subscriber gets every
+// event on a channel of its own, so the console, the event log, the state file and the
+// APIs don't take events from each other.
+type subscriber struct {
+	dropped int // events not delivered because the channel was full
+}
+
+// Subscribe returns a channel receiving every event published from now on, and a
+// function to cancel the subscription. Events are dropped for subscribers that fall
+// too far behind rather than blocking the manager, which is logged.
+func (m *Manager) Subscribe() (<-chan Event, func()) {
+	_, ch, cancel := m.SubscribeWithHistory()
+	return ch, cancel
+}
+
+// SubscribeWithHistory is like Subscribe but also returns the most recent events
+// (oldest first) published before the subscription started.
+func (m *Manager) SubscribeWithHistory() ([]Event, <-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	m.subMu.Lock()
+	m.subscribers[ch] = &subscriber{}
+	history := append([]Event(nil), m.history...)
+	m.subMu.Unlock()
+
+	cancel := func() {
+		m.subMu.Lock()
+		defer m.subMu.Unlock()
+		if _, ok := m.subscribers[ch]; ok {
+			delete(m.subscribers, ch)
+			close(ch)
+		}
+	}
+	return history, ch, cancel
+}
+
+func (m *Manager) publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	m.logEvent(event)
+	m.history = append(m.history, event)
+	if len(m.history) > historySize {
+		m.history = m.history[len(m.history)-historySize:]
+	}
+	for ch, sub := range m.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Logged on the first drop and every subscriberBuffer drops after it.
+			if sub.dropped%subscriberBuffer == 0 {
+				logging.Printf("An event subscriber is %d events behind, dropping %s event of %s (%d dropped so far)",
+					len(ch), event.Type, event.Name, sub.dropped+1)
+			}
+			sub.dropped++
+		}
+	}
+}
+
+// closeSubscribers ends every subscription once the manager has stopped.
+func (m *Manager) closeSubscribers() {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for ch := range m.subscribers {
+		delete(m.subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,133 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/hooks"
+	"github.com/rparaujo/kpfm/pkg/model"
+	"github.com/rparaujo/kpfm/pkg/proxy"
+)
+
+const tokenCommandTimeout = 30 * time.Second
+
+// injectOptions returns the HTTP mode of the proxy of a forward with Inject settings.
+func (m *Manager) injectOptions(name string, f *forward) (*proxy.HTTPOptions, error) {
+	inject, connection := *f.connection.Inject, f.connection
+	static := make(http.Header)
+	for key, value := range inject.Headers {
+		static.Set(key, value)
+	}
+	opts := &proxy.HTTPOptions{Header: func() (http.Header, error) { return static, nil }}
+
+	if inject.BearerTokenCommand != "" {
+		token := &bearerToken{
+			ttl: time.Duration(inject.TokenTTL),
+			fetch: func() (string, error) {
+				ctx, cancel := context.WithTimeout(m.ctx, tokenCommandTimeout)
+				defer cancel()
+				return hooks.Output(ctx, inject.BearerTokenCommand, "token", connection.KubeContext(f.context), name, connection)
+			},
+		}
+		opts.Header = func() (http.Header, error) {
+			value, err := token.get()
+			if err != nil {
+				return nil, err
+			}
+			header := static.Clone()
+			header.Set("Authorization", "Bearer "+value)
+			return header, nil
+		}
+		opts.Unauthorized = token.expire
+	}
+
+	if inject.SpeaksTLS() {
+		c, err := backendTLS(inject, connection)
+		if err != nil {
+			return nil, err
+		}
+		opts.UpstreamTLS = c
+	}
+	return opts, nil
+}
+
+// backendTLS returns the client configuration the proxy speaks to the backend with.
+func backendTLS(inject model.Inject, connection model.Connection) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: inject.CAFile == ""}
+	if inject.ClientCertFile != "" || inject.ClientKeyFile != "" {
+		certFile, err := config.ExpandHome(inject.ClientCertFile)
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err := config.ExpandHome(inject.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the client certificate: %v", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if inject.CAFile != "" {
+		caFile, err := config.ExpandHome(inject.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		c.ServerName = inject.ServerName
+		if c.ServerName == "" && connection.ServiceName != "" {
+			c.ServerName = connection.ServiceName + "." + connection.Namespace + ".svc"
+		}
+	}
+	return c, nil
+}
+
+// bearerToken caches the output of a token command for ttl.
+type bearerToken struct {
+	ttl   time.Duration
+	fetch func() (string, error)
+
+	mu      sync.Mutex
+	value   string
+	expires time.Time
+}
+
+func (t *bearerToken) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.value != "" && time.Now().Before(t.expires) {
+		return t.value, nil
+	}
+	value, err := t.fetch()
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		return "", errors.New("the token command printed nothing")
+	}
+	t.value, t.expires = value, time.Now().Add(t.ttl)
+	return value, nil
+}
+
+// expire makes the next request run the command again.
+func (t *bearerToken) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.value = ""
+}
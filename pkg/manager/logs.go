@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rparaujo/kpfm/pkg/logging"
+)
+
+// logLines is how many lines the log of each forward keeps.
+const logLines = 1000
+
+// Logs returns the log of the forward named name: its lifecycle events, forwarder
+// output, client-go errors and probe failures. It outlives the forward, so the log of a
+// forward that stopped can still be read.
+func (m *Manager) Logs(name string) (*logging.Buffer, error) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	b, ok := m.logs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownForward, name)
+	}
+	return b, nil
+}
+
+// connectionLog returns the log of the forward named name, creating it on first use.
+func (m *Manager) connectionLog(name string) *logging.Buffer {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	return m.logLocked(name)
+}
+
+// logLocked is connectionLog with m.subMu held.
+func (m *Manager) logLocked(name string) *logging.Buffer {
+	b, ok := m.logs[name]
+	if !ok {
+		b = logging.NewBuffer(logLines)
+		m.logs[name] = b
+	}
+	return b
+}
+
+// logEvent writes a forward's event to its log. m.subMu must be held.
+func (m *Manager) logEvent(event Event) {
+	if event.Name == "" {
+		return
+	}
+	parts := []string{string(event.Type)}
+	if event.Context != "" {
+		parts = append(parts, "context="+event.Context)
+	}
+	if event.Pod != "" {
+		parts = append(parts, "pod="+event.Pod)
+	}
+	if event.Err != nil {
+		parts = append(parts, "error="+event.Err.Error())
+	}
+	m.logLocked(event.Name).Printf("%s", strings.Join(parts, " "))
+}
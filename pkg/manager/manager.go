@@ -0,0 +1,1479 @@
+// Package manager runs the port-forwards configured for the active kube context, and the
+// pinned ones of other contexts, restarting failed forwards and following context changes.
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/hooks"
+	"github.com/rparaujo/kpfm/pkg/hosts"
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/model"
+	"github.com/rparaujo/kpfm/pkg/probe"
+	"github.com/rparaujo/kpfm/pkg/proxy"
+)
+
+const (
+	defaultCheckInterval = 10 * time.Second
+	defaultRetryDelay    = time.Second
+	wakeTimeout          = 30 * time.Second
+	stopHookTimeout      = 30 * time.Second
+)
+
+// Options tune how a Manager selects and supervises forwards.
+type Options struct {
+	// Context pins the manager to a kube context. When empty the manager follows the
+	// kubeconfig's current context.
+	Context string
+	// Filter selects the connections to run; nil runs all of them.
+	Filter func(model.Connection) bool
+	// CheckInterval is how often the current kube context is polled.
+	CheckInterval time.Duration
+	// WatchKubeconfig also reads the current kube context as soon as the kubeconfig
+	// files are written.
+	WatchKubeconfig bool
+	// RetryDelay is how long to wait before restarting a failed forward.
+	RetryDelay time.Duration
+	// ContextDebounce is how long a new current context must stay current before the
+	// forwards follow it, so that rapid flips don't tear everything down each time.
+	ContextDebounce time.Duration
+	// ConfirmContextSwitch, when set, is asked before the forwards follow a context
+	// change; they stay on the old context when it returns false.
+	ConfirmContextSwitch func(from, to string) bool
+	// Client returns the client the forwards of a kube context reach its cluster with,
+	// kube.NewClient by default.
+	Client func(kubeContext string) kube.Client
+}
+
+// State is the lifecycle state of a single forward.
+type State string
+
+const (
+	StateStarting State = "starting"
+	StateReady    State = "ready"
+	StateFailed   State = "failed"
+	StateIdle     State = "idle"    // not forwarding, Lazy or after IdleTimeout; set up on the next connection
+	StateBroken   State = "broken"  // failed MaxConsecutiveFailures times in a row, waiting for Retry
+	StatePaused   State = "paused"  // stopped by Pause, its local port released until Resume
+	StateWaiting  State = "waiting" // not started until the connections it DependsOn are ready
+)
+
+var (
+	// ErrUnknownForward is returned for operations on a forward the manager doesn't run.
+	ErrUnknownForward = errors.New("unknown forward")
+	// ErrForwardExists is returned by Add for a connection the manager already runs.
+	ErrForwardExists = errors.New("forward already exists")
+)
+
+// ForwardStatus is a snapshot of a forward returned by Status.
+type ForwardStatus struct {
+	// ID identifies the forward across snapshots and contexts, see ForwardID.
+	ID         string
+	Name       string // the name the forward is controlled by
+	Context    string
+	Connection model.Connection
+	State      State
+	Pod        string `json:",omitempty"` // the pod of the last ready generation, or the one a starting forward dials
+	LastError  string
+	Since      time.Time
+	Failures   int          // consecutive failures
+	Stats      *proxy.Stats `json:",omitempty"` // set when the forward runs behind a counting proxy
+	// Reachability is the last local dial of a ready forward, nil before the first one.
+	Reachability *Reachability `json:",omitempty"`
+	// SuppressedLogLines counts the forwarder output lines the log filter dropped.
+	SuppressedLogLines int64 `json:",omitempty"`
+
+	// Counters since the forward was started, to tell flapping forwards apart.
+	Restarts       int           // generations started after the first
+	TotalFailures  int           // failures, consecutive or not
+	LastFailure    string        `json:",omitempty"` // the last error, kept once the forward recovers
+	LastFailureAt  time.Time     // when it happened
+	LastReady      time.Time     // when the forward last became ready
+	LastConnection time.Time     // when a local client last connected through the forward
+	Uptime         time.Duration // how long the forward has been ready, 0 unless it is
+}
+
+// ForwardID returns the stable identifier of the forward called name on kubeContext,
+// "<kubeContext>/<name>". Names are unique among the forwards of a manager, but a
+// connection keeps its ID as the manager moves between contexts, which its name alone
+// doesn't tell apart from a connection of the same name of another context.
+func ForwardID(kubeContext, name string) string {
+	return kubeContext + "/" + name
+}
+
+// Manager keeps the forwards of the active kube context running.
+type Manager struct {
+	config *model.Contexts
+	opts   Options
+
+	mu          sync.Mutex
+	kubeContext string
+	target      kube.ContextTarget // what kubeContext pointed at when the forwards started
+	forwards    map[string]*forward
+	// Forwards added or removed at runtime, per kube context, applied on top of the config.
+	added     map[string][]model.Connection
+	removed   map[string]map[string]bool
+	paused    map[string]map[string]bool // forwards paused at runtime, per kube context
+	hostnames []string                   // hostnames currently written to the hosts file
+	// Forwards started for AllServices connections by namespace, and a function stopping
+	// the service listings of the current context.
+	discovered    map[string]map[string]bool
+	stopDiscovery context.CancelFunc
+
+	subMu       sync.Mutex
+	subscribers map[chan Event]*subscriber
+	history     []Event
+	logs        map[string]*logging.Buffer // per forward name, see Logs
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	throttle *throttle
+	updates  chan update
+	switches chan contextSwitch // SwitchContext requests
+	done     chan struct{}
+	setups   sync.WaitGroup
+	hooks    sync.WaitGroup
+	drains   sync.WaitGroup // stopped forwards draining their connections
+}
+
+// forward is the manager's record of one running connection.
+type forward struct {
+	context    string // the kube context the forward runs on
+	connection model.Connection
+	proxy      *proxy.Proxy
+	stopChan   chan struct{}
+	generation int
+	state      State
+	lastErr    error
+	since      time.Time
+	failures   int          // consecutive failures, reset once ready
+	launched   bool         // a generation was started before
+	refreshed  bool         // the forward was relaunched with fresh credentials since it was last ready
+	pod        string       // the pod of the last ready generation
+	waiters    []chan error // connections waiting for the forward to become ready
+	hookActive bool         // the OnReady hook of the ready generation is still running
+
+	// Counters since the forward was started. The last failure is kept once it recovers.
+	restarts      int // generations started after the first
+	totalFailures int
+	lastFailure   error
+	lastFailureAt time.Time
+	lastReady     time.Time // when the forward last became ready
+	// lastConnection is when a local client last connected.
+	lastConnection time.Time
+
+	// Per-generation state: the port the forward listens on and a context cancelled
+	// when the generation ends, which stops its prober.
+	port         int
+	resolvedPod  string        // the pod the generation dials, known before it is ready
+	reachability *Reachability // the last local dial of the ready generation
+	genCtx       context.Context
+	genCancel    context.CancelFunc
+}
+
+// update carries a status from a forward's generation, a retry request, or a request
+// from the forward's proxy to the manager loop.
+type update struct {
+	name       string
+	generation int
+	status     model.PortForwardStatus
+	retry      bool
+
+	forward *forward   // the forward an idle or wake request comes from
+	idle    bool       // the proxy saw no traffic for IdleTimeout
+	wake    chan error // a new local connection waits for the forward
+	rearm   chan error // Retry was called for the forward
+	restart chan error // Restart was called for the forward
+	pause   chan error // Pause was called for the forward
+	resume  chan error // Resume was called for the forward
+
+	connection model.Connection // the connection to start for an add request
+	add        chan error
+	remove     chan error
+
+	discovery *discovery // services listed for an AllServices connection
+
+	reachability *Reachability // the outcome of a reachability check or health probe
+	probePassed  bool          // the first probe of a generation gated by its probe passed
+
+	readyHook         bool  // the OnReady hook of the generation finished
+	hookErr           error // and how
+	dependencyTimeout bool  // the forward waited DependsOnTimeout for its dependencies
+}
+
+// New returns a Manager for the given configuration. Call Start to bring the forwards up.
+func New(cfg *model.Contexts, opts Options) *Manager {
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = defaultCheckInterval
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+	if opts.Client == nil {
+		opts.Client = kube.NewClient
+	}
+	var concurrency int
+	var stagger time.Duration
+	if cfg.Startup != nil {
+		concurrency, stagger = cfg.Startup.Concurrency, time.Duration(cfg.Startup.Stagger)
+	}
+	return &Manager{
+		config:      cfg,
+		opts:        opts,
+		forwards:    make(map[string]*forward),
+		added:       make(map[string][]model.Connection),
+		removed:     make(map[string]map[string]bool),
+		paused:      make(map[string]map[string]bool),
+		discovered:  make(map[string]map[string]bool),
+		subscribers: make(map[chan Event]*subscriber),
+		logs:        make(map[string]*logging.Buffer),
+		throttle:    newThrottle(concurrency, stagger),
+		updates:     make(chan update),
+		switches:    make(chan contextSwitch),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start brings up the forwards of the active context and supervises them until ctx is
+// cancelled or Stop is called.
+func (m *Manager) Start(ctx context.Context) error {
+	if m.ctx != nil {
+		return errors.New("manager already started")
+	}
+
+	kubeContext := m.opts.Context
+	if kubeContext == "" {
+		current, err := kube.GetCurrentContext()
+		if err != nil {
+			return err
+		}
+		kubeContext = current
+	}
+
+	// Kept to tell when the context is changed to point elsewhere under the same name.
+	target, _ := m.opts.Client(kubeContext).Target()
+
+	m.ctx, m.cancel = context.WithCancel(ctx)
+
+	// A pinned context has nothing to follow.
+	var contextCh chan kube.ContextChange
+	if m.opts.Context == "" {
+		contextCh = make(chan kube.ContextChange)
+		if m.opts.ContextDebounce > 0 || m.opts.ConfirmContextSwitch != nil {
+			changes := make(chan kube.ContextChange)
+			go kube.WatchContextChanges(m.ctx, changes, m.opts.CheckInterval, m.opts.WatchKubeconfig)
+			go m.gateContextChanges(changes, contextCh)
+		} else {
+			go kube.WatchContextChanges(m.ctx, contextCh, m.opts.CheckInterval, m.opts.WatchKubeconfig)
+		}
+	}
+
+	m.mu.Lock()
+	m.kubeContext = kubeContext
+	m.target = target
+	m.startPinned()
+	m.startAll()
+	m.startWaiting()
+	m.mu.Unlock()
+
+	go m.run(contextCh)
+	return nil
+}
+
+// Stop tears down every forward and waits for the manager to finish.
+func (m *Manager) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.done
+}
+
+// Done is closed once the manager has stopped.
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+// Context returns the kube context the manager is currently forwarding for.
+func (m *Manager) Context() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.kubeContext
+}
+
+// Status returns a snapshot of every forward, sorted by name.
+func (m *Manager) Status() []ForwardStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]ForwardStatus, 0, len(m.forwards))
+	for name, f := range m.forwards {
+		status := ForwardStatus{
+			ID:         ForwardID(f.context, name),
+			Name:       name,
+			Context:    f.context,
+			Connection: f.connection,
+			State:      f.state,
+			Pod:        f.pod,
+			Since:      f.since,
+			Failures:   f.failures,
+
+			Restarts:       f.restarts,
+			TotalFailures:  f.totalFailures,
+			LastFailureAt:  f.lastFailureAt,
+			LastReady:      f.lastReady,
+			LastConnection: f.lastConnection,
+		}
+		if f.lastErr != nil {
+			status.LastError = f.lastErr.Error()
+		}
+		if f.lastFailure != nil {
+			status.LastFailure = f.lastFailure.Error()
+		}
+		if f.state == StateReady {
+			status.Uptime = time.Since(f.since)
+			status.Reachability = f.reachability
+		}
+		if f.state == StateStarting && f.resolvedPod != "" {
+			status.Pod = f.resolvedPod
+		}
+		if f.proxy != nil {
+			stats := f.proxy.Stats()
+			status.Stats = &stats
+		}
+		status.SuppressedLogLines = m.connectionLog(name).Suppressed()
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].Connection.LocalPort < statuses[j].Connection.LocalPort
+	})
+	return statuses
+}
+
+// Retry re-arms a broken (or failed) forward and restarts it immediately.
+func (m *Manager) Retry(name string) error {
+	reply := make(chan error, 1)
+	select {
+	case m.updates <- update{name: name, rearm: reply}:
+	case <-m.done:
+		return errors.New("manager stopped")
+	}
+	return <-reply
+}
+
+// Restart tears a forward down and brings it up again, whatever its state.
+func (m *Manager) Restart(name string) error {
+	reply := make(chan error, 1)
+	select {
+	case m.updates <- update{name: name, restart: reply}:
+	case <-m.done:
+		return errors.New("manager stopped")
+	}
+	return <-reply
+}
+
+// Pause stops a forward and releases its local port while keeping it configured, until
+// Resume is called. The forward stays paused when the manager returns to the context.
+func (m *Manager) Pause(name string) error {
+	reply := make(chan error, 1)
+	select {
+	case m.updates <- update{name: name, pause: reply}:
+	case <-m.done:
+		return errors.New("manager stopped")
+	}
+	return <-reply
+}
+
+// Resume brings a paused forward up again.
+func (m *Manager) Resume(name string) error {
+	reply := make(chan error, 1)
+	select {
+	case m.updates <- update{name: name, resume: reply}:
+	case <-m.done:
+		return errors.New("manager stopped")
+	}
+	return <-reply
+}
+
+// ReloadKubeconfig reads the kubeconfig files again, the files in paths from now on when
+// not nil, and follows the current context they set. Forwards already up keep running
+// unless their context changed or now points elsewhere.
+func (m *Manager) ReloadKubeconfig(paths []string) {
+	if paths != nil {
+		logging.Infof("Reloading the kubeconfig from %s", strings.Join(paths, string(filepath.ListSeparator)))
+	} else {
+		logging.Infof("Reloading the kubeconfig")
+	}
+	kube.ReloadKubeconfig(paths)
+}
+
+// Add starts a forward for connection on the current context. It lasts until removed and
+// comes back whenever the manager returns to that context.
+func (m *Manager) Add(connection model.Connection) error {
+	return m.request(update{name: connection.DisplayName(), connection: connection, add: make(chan error, 1)})
+}
+
+// Remove stops a forward and keeps it stopped on the current context, even if it is configured.
+func (m *Manager) Remove(name string) error {
+	return m.request(update{name: name, remove: make(chan error, 1)})
+}
+
+// request sends an add or remove request to the manager loop and waits for its reply.
+func (m *Manager) request(u update) error {
+	reply := u.add
+	if reply == nil {
+		reply = u.remove
+	}
+	select {
+	case m.updates <- u:
+	case <-m.done:
+		return errors.New("manager stopped")
+	}
+	return <-reply
+}
+
+// run is the manager loop; it owns all state transitions.
+func (m *Manager) run(contextCh <-chan kube.ContextChange) {
+	defer close(m.done)
+	defer m.closeSubscribers()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			m.mu.Lock()
+			m.stopAll()
+			m.syncHosts()
+			m.mu.Unlock()
+			m.setups.Wait()
+			m.drains.Wait()
+			m.hooks.Wait()
+			return
+
+		case change := <-contextCh:
+			m.switchContext(change)
+
+		case request := <-m.switches:
+			request.reply <- m.switchTo(request.change)
+
+		case u := <-m.updates:
+			m.handle(u)
+		}
+	}
+}
+
+// gateContextChanges passes the context changes from in on to out once they have lasted
+// ContextDebounce and ConfirmContextSwitch accepted them. A context that now points
+// elsewhere isn't asked about, as the forwards can't stay where they were.
+func (m *Manager) gateContextChanges(in <-chan kube.ContextChange, out chan<- kube.ContextChange) {
+	var pending kube.ContextChange
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case pending = <-in:
+			settled = time.After(m.opts.ContextDebounce)
+			continue
+		case <-settled:
+			settled = nil
+		}
+
+		// The context the forwards run on, which SwitchContext may have moved them to.
+		m.mu.Lock()
+		active := kube.ContextChange{Name: m.kubeContext, Target: m.target}
+		m.mu.Unlock()
+		if pending == active {
+			// Flipped back within the debounce window, or already switched to.
+			continue
+		}
+		if pending.Name != active.Name && m.opts.ConfirmContextSwitch != nil && !m.opts.ConfirmContextSwitch(active.Name, pending.Name) {
+			logging.Printf("Keeping the forwards on context %s", active.Name)
+			continue
+		}
+		select {
+		case out <- pending:
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// switchContext replaces the forwards of the current context with those of the new one,
+// or restarts them when the context now points at another cluster, user or namespace,
+// reporting whether anything changed. Pinned forwards keep running.
+func (m *Manager) switchContext(change kube.ContextChange) bool {
+	newContext := change.Name
+	m.mu.Lock()
+	same := newContext == m.kubeContext && change.Target == m.target
+	retarget := newContext == m.kubeContext
+	m.mu.Unlock()
+	if same {
+		return false
+	}
+	if retarget {
+		logging.Printf("Context %s now points at cluster %s (%s), user %s, namespace %q; restarting its forwards",
+			newContext, change.Target.Cluster, change.Target.Server, change.Target.User, change.Target.Namespace)
+	}
+	m.publish(Event{Type: EventContextChanged, Context: newContext})
+
+	m.mu.Lock()
+	m.stopFollowing()
+	m.syncHosts()
+	m.mu.Unlock()
+	m.setups.Wait() // Wait for all port forwards to stop
+
+	m.mu.Lock()
+	m.kubeContext = newContext
+	m.target = change.Target
+	m.startAll()
+	m.startWaiting()
+	m.mu.Unlock()
+	return true
+}
+
+// ContextSwitch is what SwitchContext did.
+type ContextSwitch struct {
+	Context string
+	Stopped []string // the forwards of the previous context, stopped
+	Started []string // the forwards of Context, started
+}
+
+// contextSwitch is a SwitchContext request to the manager loop.
+type contextSwitch struct {
+	change kube.ContextChange
+	reply  chan ContextSwitch
+}
+
+// SwitchContext moves the forwards to kubeContext right away, as when the context
+// watcher sees it become the current context, without asking ConfirmContextSwitch.
+// Pinned forwards keep running, and forwards pinned to a context with Options.Context
+// can't be switched.
+func (m *Manager) SwitchContext(kubeContext string) (ContextSwitch, error) {
+	if m.opts.Context != "" {
+		return ContextSwitch{}, fmt.Errorf("the forwards are pinned to context %s", m.opts.Context)
+	}
+	target, err := m.opts.Client(kubeContext).Target()
+	if err != nil {
+		return ContextSwitch{}, err
+	}
+	request := contextSwitch{change: kube.ContextChange{Name: kubeContext, Target: target}, reply: make(chan ContextSwitch, 1)}
+	select {
+	case m.switches <- request:
+	case <-m.done:
+		return ContextSwitch{}, errors.New("manager stopped")
+	}
+	return <-request.reply, nil
+}
+
+// switchTo runs switchContext for a SwitchContext request.
+func (m *Manager) switchTo(change kube.ContextChange) ContextSwitch {
+	result := ContextSwitch{Context: change.Name}
+	before := m.followingForwards()
+	if m.switchContext(change) {
+		result.Stopped, result.Started = before, m.followingForwards()
+	}
+	return result
+}
+
+// followingForwards returns the sorted names of the forwards following the current
+// context that aren't paused.
+func (m *Manager) followingForwards() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name, f := range m.forwards {
+		if !f.connection.Pinned && f.state != StatePaused {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handle applies a forward status or retry request to the manager state.
+func (m *Manager) handle(u update) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.syncHosts()
+	defer m.startWaiting()
+
+	if u.discovery != nil {
+		m.syncDiscovered(u.discovery)
+		return
+	}
+
+	f, ok := m.forwards[u.name]
+	if u.add != nil {
+		if ok {
+			u.add <- fmt.Errorf("%w: %s", ErrForwardExists, u.name)
+			return
+		}
+		m.added[m.kubeContext] = append(m.added[m.kubeContext], u.connection)
+		delete(m.removed[m.kubeContext], u.name)
+		delete(m.paused[m.kubeContext], u.name)
+		m.startForward(m.kubeContext, u.name, u.connection)
+		u.add <- nil
+		return
+	}
+	if u.remove != nil {
+		if !ok {
+			u.remove <- fmt.Errorf("%w: %s", ErrUnknownForward, u.name)
+			return
+		}
+		m.stopForward(u.name, f)
+		added := m.added[f.context][:0]
+		for _, connection := range m.added[f.context] {
+			if connection.DisplayName() != u.name {
+				added = append(added, connection)
+			}
+		}
+		m.added[f.context] = added
+		if m.removed[f.context] == nil {
+			m.removed[f.context] = make(map[string]bool)
+		}
+		m.removed[f.context][u.name] = true
+		delete(m.paused[f.context], u.name)
+		u.remove <- nil
+		return
+	}
+	if u.pause != nil {
+		if !ok {
+			u.pause <- fmt.Errorf("%w: %s", ErrUnknownForward, u.name)
+			return
+		}
+		m.pause(u.name, f)
+		if m.paused[f.context] == nil {
+			m.paused[f.context] = make(map[string]bool)
+		}
+		m.paused[f.context][u.name] = true
+		u.pause <- nil
+		return
+	}
+	if u.resume != nil || u.restart != nil {
+		reply := u.resume
+		if reply == nil {
+			reply = u.restart
+		}
+		if !ok {
+			reply <- fmt.Errorf("%w: %s", ErrUnknownForward, u.name)
+			return
+		}
+		if u.resume != nil && f.state != StatePaused {
+			reply <- nil
+			return
+		}
+		// Restarting a paused forward resumes it.
+		delete(m.paused[f.context], u.name)
+		if f.state == StateReady || f.state == StateStarting {
+			f.endGeneration()
+		}
+		f.failures = 0
+		if u.resume != nil && m.lazy(f.connection) {
+			m.listenLazily(u.name, f)
+		} else {
+			m.start(u.name, f)
+		}
+		reply <- nil
+		return
+	}
+	if u.rearm != nil {
+		if !ok {
+			u.rearm <- fmt.Errorf("%w: %s", ErrUnknownForward, u.name)
+			return
+		}
+		f.failures = 0
+		if f.state == StateBroken || f.state == StateFailed {
+			m.start(u.name, f)
+		}
+		u.rearm <- nil
+		return
+	}
+	if u.forward != nil {
+		if !ok || f != u.forward {
+			if u.wake != nil {
+				u.wake <- errors.New("forward stopped")
+			}
+			return
+		}
+		if u.wake != nil {
+			m.wake(u.name, f, u.wake)
+		} else if u.idle {
+			m.sleep(u.name, f)
+		}
+		return
+	}
+	if !ok || f.generation != u.generation {
+		// Stale update from a forward that has since been stopped or restarted.
+		return
+	}
+
+	if u.retry {
+		if f.state == StateFailed {
+			m.start(u.name, f)
+		}
+		return
+	}
+	if u.readyHook {
+		if u.hookErr != nil {
+			// Dependents keep waiting: whatever the hook prepares for them isn't there.
+			logging.Printf("OnReady hook of %s failed, not starting the forwards depending on it", u.name)
+			return
+		}
+		f.hookActive = false
+		return
+	}
+	if u.reachability != nil {
+		if f.state == StateReady {
+			f.reachability = u.reachability
+		}
+		return
+	}
+	if u.probePassed {
+		if f.state == StateStarting {
+			m.ready(u.name, f, f.resolvedPod, time.Now())
+		}
+		return
+	}
+	if u.dependencyTimeout {
+		if f.state == StateWaiting {
+			m.fail(u.name, f, fmt.Errorf("%s not ready after %s", strings.Join(m.pendingDependencies(f), ", "), time.Duration(f.connection.DependsOnTimeout)))
+		}
+		return
+	}
+
+	switch u.status.Type {
+	case model.PortForwardStarting:
+		// launch already marked the forward as starting.
+
+	case model.PortForwardPodResolved:
+		f.resolvedPod = u.status.PodName
+
+	case model.PortForwardConnectionAccepted:
+		f.lastConnection = u.status.Time
+
+	case model.PortForwardReady:
+		if p := f.connection.Probe; p != nil {
+			go m.runProbe(f.genCtx, u.name, f.generation, f.port, probe.SettingsFor(*p), p.GateReady)
+			if p.GateReady {
+				// Starting until the probe passes.
+				f.resolvedPod = u.status.PodName
+				return
+			}
+		} else if interval := time.Duration(m.config.ReachabilityInterval); interval > 0 && !f.connection.IsUDP() {
+			go m.checkReachability(f.genCtx, u.name, f.generation, f.port, interval)
+		}
+		m.ready(u.name, f, u.status.PodName, u.status.Time)
+
+	case model.PortForwardError:
+		if u.status.Unhealthy {
+			// The forward itself is still running; stop it before restarting.
+			f.endGeneration()
+		}
+		if kube.IsCredentialError(u.status.Err) && !f.refreshed {
+			// The kube client was dropped along with the expired credentials; reconnect
+			// right away instead of counting a failure.
+			logging.Printf("Credentials for %s were rejected, reconnecting with fresh ones: %v", u.name, u.status.Err)
+			f.refreshed = true
+			m.launch(u.name, f)
+			return
+		}
+		m.fail(u.name, f, u.status.Err)
+
+	case model.PortForwardStopped:
+		if f.connection.RestartPolicy == model.RestartAlways {
+			// The forward ended without an error, e.g. when the connection to the pod was closed.
+			m.fail(u.name, f, errors.New("forward ended"))
+		}
+	}
+}
+
+// ready marks a forward generation as ready, going to pod since at. m.mu must be held.
+func (m *Manager) ready(name string, f *forward, pod string, at time.Time) {
+	f.state = StateReady
+	f.lastErr = nil
+	f.failures = 0
+	f.refreshed = false
+	f.since = at
+	f.lastReady = f.since
+	if f.pod != "" && f.pod != pod {
+		m.publish(Event{Type: EventPodResolved, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels, Pod: pod})
+	}
+	f.pod = pod
+	m.publish(Event{Type: EventReady, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels, Pod: f.pod})
+	f.release(nil)
+	f.hookActive = f.connection.OnReady != ""
+	if f.hookActive {
+		m.runHook(f.connection.OnReady, "ready", name, f)
+	}
+}
+
+// wake makes sure an idle forward is re-established and replies once it is ready. m.mu must be held.
+func (m *Manager) wake(name string, f *forward, reply chan error) {
+	switch f.state {
+	case StateReady:
+		reply <- nil
+	case StateIdle:
+		f.waiters = append(f.waiters, reply)
+		m.start(name, f)
+	case StateBroken:
+		reply <- errors.New("forward is broken")
+	case StatePaused:
+		reply <- errors.New("forward is paused")
+	default:
+		f.waiters = append(f.waiters, reply)
+	}
+}
+
+// pause stops a forward's current generation, if any, and closes its proxy so the local
+// port is free. m.mu must be held.
+func (m *Manager) pause(name string, f *forward) {
+	if f.state == StatePaused {
+		return
+	}
+	if f.state == StateReady || f.state == StateStarting {
+		f.endGeneration()
+	} else {
+		// Invalidate a pending retry.
+		f.generation++
+	}
+	if f.proxy != nil {
+		f.proxy.Close()
+		f.proxy = nil
+	}
+	f.release(errors.New("forward paused"))
+	f.state = StatePaused
+	f.lastErr = nil
+	f.since = time.Now()
+	m.publish(Event{Type: EventPaused, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels})
+}
+
+// sleep tears down an idle forward while its proxy keeps listening. m.mu must be held.
+func (m *Manager) sleep(name string, f *forward) {
+	if f.state != StateReady {
+		return
+	}
+	f.endGeneration()
+	f.state = StateIdle
+	f.since = time.Now()
+	m.publish(Event{Type: EventIdle, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels})
+}
+
+// endGeneration stops the running forward generation; its final status will be ignored.
+func (f *forward) endGeneration() {
+	close(f.stopChan)
+	f.stopChan = make(chan struct{})
+	f.generation++
+	if f.genCancel != nil {
+		f.genCancel()
+	}
+}
+
+// release answers every connection waiting for the forward.
+func (f *forward) release(err error) {
+	for _, waiter := range f.waiters {
+		waiter <- err
+	}
+	f.waiters = nil
+}
+
+// fail marks a forward as failed and schedules its restart, or marks it broken once it
+// failed MaxConsecutiveFailures times in a row. The connection's RetryDelay and
+// MaxConsecutiveFailures override the manager's; its RestartPolicy may rule out the
+// restart or the circuit breaker. m.mu must be held.
+func (m *Manager) fail(name string, f *forward, err error) {
+	f.state = StateFailed
+	f.lastErr = err
+	f.failures++
+	f.since = time.Now()
+	f.totalFailures++
+	f.lastFailure, f.lastFailureAt = err, f.since
+	m.publish(Event{Type: EventFailed, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels, Err: err})
+	f.release(err)
+
+	policy := f.connection.RestartPolicy
+	if policy == model.RestartNever {
+		logging.Verbosef("Not restarting %s, its restart policy is never", name)
+		return
+	}
+	limit := m.config.MaxConsecutiveFailures
+	if f.connection.MaxConsecutiveFailures > 0 {
+		limit = f.connection.MaxConsecutiveFailures
+	}
+	if policy != model.RestartAlways && limit > 0 && f.failures >= limit {
+		f.state = StateBroken
+		m.publish(Event{Type: EventBroken, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels, Err: err})
+		return
+	}
+	delay := m.opts.RetryDelay
+	if f.connection.RetryDelay > 0 {
+		delay = time.Duration(f.connection.RetryDelay)
+	}
+	logging.Verbosef("Retrying %s in %s after %d consecutive failure(s)", name, delay, f.failures)
+	go m.scheduleRetry(name, f.generation, delay)
+}
+
+// scheduleRetry asks the manager loop to restart a failed forward after delay.
+func (m *Manager) scheduleRetry(name string, generation int, delay time.Duration) {
+	select {
+	case <-time.After(delay):
+	case <-m.ctx.Done():
+		return
+	}
+	select {
+	case m.updates <- update{name: name, generation: generation, retry: true}:
+	case <-m.ctx.Done():
+	}
+}
+
+// startPinned starts the selected pinned connections of every context; they run until the
+// manager stops. Pinned AllServices connections follow the current context like the
+// others. m.mu must be held.
+func (m *Manager) startPinned() {
+	for _, ctx := range m.config.Contexts {
+		for _, connection := range ctx.Connections {
+			if !connection.Pinned || connection.AllServices || (m.opts.Filter != nil && !m.opts.Filter(connection)) {
+				continue
+			}
+			m.startForward(ctx.Name, connection.DisplayName(), connection)
+		}
+	}
+}
+
+// startAll starts every selected connection of the current context that isn't pinned,
+// along with those added at runtime, and begins listing the services of AllServices
+// connections. m.mu must be held.
+func (m *Manager) startAll() {
+	discoveryCtx, stopDiscovery := context.WithCancel(m.ctx)
+	m.stopDiscovery = stopDiscovery
+	for _, ctx := range m.config.Contexts {
+		if ctx.Name != m.kubeContext {
+			continue
+		}
+		for _, connection := range ctx.Connections {
+			if m.opts.Filter != nil && !m.opts.Filter(connection) {
+				continue
+			}
+			if connection.AllServices {
+				go m.watchServices(discoveryCtx, m.kubeContext, connection)
+				continue
+			}
+			if connection.Pinned || m.removed[m.kubeContext][connection.DisplayName()] {
+				continue
+			}
+			m.startForward(m.kubeContext, connection.DisplayName(), connection)
+		}
+	}
+	for _, connection := range m.added[m.kubeContext] {
+		m.startForward(m.kubeContext, connection.DisplayName(), connection)
+	}
+}
+
+// startForward tracks and launches a forward for connection on kubeContext under name, or
+// only tracks it when it was paused on that context. Forwards with dependencies wait for
+// startWaiting, as those may not be tracked yet. A forward already running under name, a
+// pinned one of another context, is left alone. m.mu must be held.
+func (m *Manager) startForward(kubeContext, name string, connection model.Connection) {
+	if existing, ok := m.forwards[name]; ok {
+		if existing.context != kubeContext {
+			logging.Printf("Not forwarding %s on context %s: the pinned forward of context %s has that name", name, kubeContext, existing.context)
+		}
+		return
+	}
+	f := &forward{
+		context:    kubeContext,
+		connection: connection,
+		stopChan:   make(chan struct{}),
+	}
+	m.forwards[name] = f
+	m.connectionLog(name)
+	if m.paused[kubeContext][name] {
+		f.state = StatePaused
+		f.since = time.Now()
+		return
+	}
+	if m.lazy(connection) {
+		// Its dependencies are waited for once a client connects.
+		m.listenLazily(name, f)
+		return
+	}
+	if len(connection.DependsOn) > 0 {
+		m.wait(name, f)
+		return
+	}
+	m.launch(name, f)
+}
+
+// start launches a forward whose dependencies are ready, or makes it wait for them.
+// m.mu must be held.
+func (m *Manager) start(name string, f *forward) {
+	if len(m.pendingDependencies(f)) > 0 {
+		m.wait(name, f)
+		return
+	}
+	m.launch(name, f)
+}
+
+// wait holds a forward back until startWaiting finds its dependencies ready, failing it
+// after DependsOnTimeout. m.mu must be held.
+func (m *Manager) wait(name string, f *forward) {
+	if f.state == StateWaiting {
+		return
+	}
+	f.generation++ // invalidates a pending retry
+	f.state = StateWaiting
+	f.since = time.Now()
+	m.publish(Event{Type: EventWaiting, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels})
+	go m.scheduleDependencyTimeout(name, f.generation, time.Duration(f.connection.DependsOnTimeout))
+}
+
+// startWaiting launches the waiting forwards whose dependencies became ready. m.mu must be held.
+func (m *Manager) startWaiting() {
+	for name, f := range m.forwards {
+		if f.state == StateWaiting && len(m.pendingDependencies(f)) == 0 {
+			m.launch(name, f)
+		}
+	}
+}
+
+// pendingDependencies returns the dependencies of a forward that aren't ready (or idle) yet,
+// or whose OnReady hook is still running. Dependencies the manager doesn't run, e.g. left out
+// by the start filters, are not waited for. m.mu must be held.
+func (m *Manager) pendingDependencies(f *forward) []string {
+	var pending []string
+	for _, name := range f.connection.DependsOn {
+		if dependency, ok := m.forwards[name]; ok && (dependency.state != StateReady && dependency.state != StateIdle || dependency.hookActive) {
+			pending = append(pending, name)
+		}
+	}
+	return pending
+}
+
+// scheduleDependencyTimeout tells the manager loop when a forward has waited for its
+// dependencies for timeout.
+func (m *Manager) scheduleDependencyTimeout(name string, generation int, timeout time.Duration) {
+	select {
+	case <-time.After(timeout):
+	case <-m.ctx.Done():
+		return
+	}
+	select {
+	case m.updates <- update{name: name, generation: generation, dependencyTimeout: true}:
+	case <-m.ctx.Done():
+	}
+}
+
+// stopAll stops and forgets every forward. m.mu must be held.
+func (m *Manager) stopAll() {
+	m.stopFollowing()
+	for name, f := range m.forwards {
+		m.stopForward(name, f)
+	}
+}
+
+// stopFollowing stops and forgets the forwards of the current context, leaving the pinned
+// ones running. m.mu must be held.
+func (m *Manager) stopFollowing() {
+	if m.stopDiscovery != nil {
+		m.stopDiscovery()
+	}
+	m.discovered = make(map[string]map[string]bool)
+	for name, f := range m.forwards {
+		if !f.connection.Pinned {
+			m.stopForward(name, f)
+		}
+	}
+}
+
+// stopForward stops and forgets a forward. With a DrainTimeout its proxy stops
+// accepting connections right away but the tunnel is only torn down once the open ones
+// ended or the timeout passed. m.mu must be held.
+func (m *Manager) stopForward(name string, f *forward) {
+	if timeout := time.Duration(f.connection.DrainTimeout); timeout > 0 && f.proxy != nil {
+		m.drains.Add(1)
+		go m.drain(name, f.proxy, f.proxy.Drain(), f.stopChan, f.genCancel, timeout)
+	} else {
+		close(f.stopChan)
+		if f.genCancel != nil {
+			f.genCancel()
+		}
+		if f.proxy != nil {
+			f.proxy.Close()
+		}
+	}
+	f.release(errors.New("forward stopped"))
+	delete(m.forwards, name)
+	m.publish(Event{Type: EventStopped, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels})
+	if f.connection.OnStop != "" {
+		m.runHook(f.connection.OnStop, "stop", name, f)
+	}
+}
+
+// drain waits for the connections still open through the proxy of a stopped forward
+// to end, for up to timeout, then closes the proxy and tears down its tunnel.
+func (m *Manager) drain(name string, p *proxy.Proxy, drained <-chan struct{}, stopChan chan struct{}, cancel context.CancelFunc, timeout time.Duration) {
+	defer m.drains.Done()
+	log := m.connectionLog(name)
+	if open := p.Stats().ActiveConnections; open > 0 {
+		log.Printf("Draining %d connection(s) for up to %s", open, timeout)
+		select {
+		case <-drained:
+		case <-time.After(timeout):
+			cut := p.Stats().ActiveConnections
+			log.Printf("Closing %d connection(s) still open after %s", cut, timeout)
+			logging.Printf("%s: closing %d connection(s) still open after draining for %s", name, cut, timeout)
+		}
+	}
+	p.Close()
+	close(stopChan)
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// launch starts a new generation of a forward. m.mu must be held.
+func (m *Manager) launch(name string, f *forward) {
+	f.generation++
+	f.resolvedPod = ""
+	f.reachability = nil
+	f.state = StateStarting
+	f.since = time.Now()
+	if f.launched {
+		f.restarts++
+		m.publish(Event{Type: EventRestarting, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels})
+	} else {
+		m.publish(Event{Type: EventStarting, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels})
+	}
+	f.launched = true
+	if f.genCancel != nil {
+		f.genCancel()
+	}
+	f.genCtx, f.genCancel = context.WithCancel(logging.WithBuffer(m.ctx, m.connectionLog(name)))
+
+	// The forward listens on an internal port behind a local proxy that owns the
+	// configured LocalPort, or LocalSocket, when traffic stats, keepalive, idle timeouts,
+	// lazy starts, TLS, Inject, rate limits, OwnerOnly, Interface or draining are enabled.
+	connection := f.connection
+	if m.needsProxy(connection) {
+		if err := m.openProxy(name, f); err != nil {
+			m.fail(name, f, err)
+			return
+		}
+		connection.LocalPort = f.proxy.UpstreamPort()
+		connection.Address, connection.Interface = "", "" // only the proxy listens on the bind address
+	}
+	f.port = connection.LocalPort
+
+	// The channel has room for every status that can't be dropped, so SetupPortForward
+	// never blocks on it even after the manager stopped listening.
+	statusCh := kube.NewStatusChannel()
+	m.setups.Add(1)
+	// The generation's context bounds its API calls, so stopping it abandons a slow lookup.
+	go func(ctx context.Context, stopChan chan struct{}) {
+		// Waiting for a pod to show up doesn't hold a startup slot.
+		kube.WaitForPod(ctx, connection, connection.KubeContext(f.context))
+		if !m.throttle.acquire(ctx, stopChan) {
+			// Stopped before it got a slot: nothing will be sent, let relay return.
+			close(statusCh)
+			m.setups.Done()
+			return
+		}
+		kube.SetupPortForward(ctx, connection, m.opts.Client(connection.KubeContext(f.context)), &m.setups, statusCh, stopChan)
+	}(f.genCtx, f.stopChan)
+	go m.relay(name, f.generation, statusCh)
+}
+
+// openProxy starts the local proxy of a forward unless it is already listening.
+// m.mu must be held.
+func (m *Manager) openProxy(name string, f *forward) error {
+	if f.proxy != nil {
+		return nil
+	}
+	opts, err := m.proxyOptions(name, f)
+	if err != nil {
+		return err
+	}
+	p, err := proxy.Listen(f.connection.LocalPort, opts)
+	if err != nil {
+		return err
+	}
+	f.proxy = p
+	return nil
+}
+
+// listenLazily opens the proxy of a Lazy forward and leaves it idle: its first local
+// connection wakes it up. m.mu must be held.
+func (m *Manager) listenLazily(name string, f *forward) {
+	f.generation++ // invalidates a pending retry
+	if err := m.openProxy(name, f); err != nil {
+		m.fail(name, f, err)
+		return
+	}
+	f.state = StateIdle
+	f.since = time.Now()
+	m.publish(Event{Type: EventIdle, Context: f.context, Name: name, ServiceName: f.connection.ServiceName, Labels: f.connection.Labels})
+}
+
+// runHook runs a hook command of a forward in the background; the manager waits for
+// running hooks before it reports done. m.mu must be held.
+func (m *Manager) runHook(command, event, name string, f *forward) {
+	kubeContext, connection, generation := f.connection.KubeContext(f.context), f.connection, f.generation
+	m.hooks.Add(1)
+	go func() {
+		defer m.hooks.Done()
+		ctx := m.ctx
+		if event == "stop" {
+			// Teardown hooks outlive the manager's context, which may be what stopped the forward.
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(context.Background(), stopHookTimeout)
+			defer cancel()
+		}
+		err := hooks.Run(ctx, command, event, kubeContext, name, connection)
+		if err != nil {
+			logging.Printf("%v", err)
+		}
+		if event == "ready" {
+			// Forwards depending on this one wait for the hook.
+			select {
+			case m.updates <- update{name: name, generation: generation, readyHook: true, hookErr: err}:
+			case <-m.ctx.Done():
+			}
+		}
+	}()
+}
+
+// syncHosts points the Hostname of every forward that is up (or idle behind its proxy)
+// at the loopback address, removing entries of forwards that went down. m.mu must be held.
+func (m *Manager) syncHosts() {
+	var names []string
+	for _, f := range m.forwards {
+		if f.connection.Hostname != "" && (f.state == StateReady || f.state == StateIdle) {
+			names = append(names, f.connection.Hostname)
+		}
+	}
+	sort.Strings(names)
+	if equalStrings(names, m.hostnames) {
+		return
+	}
+	if err := hosts.Set(names); err != nil {
+		logging.Printf("Could not update the hosts file: %v", err)
+		return
+	}
+	m.hostnames = names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lazy reports whether connection is only forwarded once a local client connects,
+// which takes a proxy to accept the connection.
+func (m *Manager) lazy(connection model.Connection) bool {
+	return connection.Lazy && !connection.IsUDP()
+}
+
+// needsProxy reports whether a connection runs behind a local proxy.
+func (m *Manager) needsProxy(connection model.Connection) bool {
+	if connection.IsUDP() {
+		return false
+	}
+	return m.config.TrafficStats || connection.KeepAlive > 0 || connection.IdleTimeout > 0 || connection.TLS != nil ||
+		connection.Inject != nil || connection.RateLimit != nil || connection.OwnerOnly ||
+		connection.LocalSocket != "" || connection.Interface != "" || connection.DrainTimeout > 0 || connection.Lazy
+}
+
+// proxyOptions wires a forward's proxy back into the manager loop.
+func (m *Manager) proxyOptions(name string, f *forward) (proxy.Options, error) {
+	opts := proxy.Options{
+		Addresses:   f.connection.BindAddresses(),
+		KeepAlive:   time.Duration(f.connection.KeepAlive),
+		IdleTimeout: time.Duration(f.connection.IdleTimeout),
+		OnIdle: func() {
+			select {
+			case m.updates <- update{name: name, forward: f, idle: true}:
+			case <-m.ctx.Done():
+			}
+		},
+		EnsureUpstream: func() error {
+			reply := make(chan error, 1)
+			select {
+			case m.updates <- update{name: name, forward: f, wake: reply}:
+			case <-m.ctx.Done():
+				return m.ctx.Err()
+			}
+			select {
+			case err := <-reply:
+				return err
+			case <-time.After(wakeTimeout):
+				return errors.New("timed out waiting for the forward")
+			case <-m.ctx.Done():
+				return m.ctx.Err()
+			}
+		},
+	}
+	if iface := f.connection.Interface; iface != "" {
+		log := m.connectionLog(name)
+		opts.Addresses = nil
+		opts.Rebind = func() ([]string, error) {
+			addresses, err := model.InterfaceAddresses(iface)
+			if err == nil && len(addresses) == 0 {
+				err = fmt.Errorf("interface %s has no address", iface)
+			}
+			return addresses, err
+		}
+		opts.OnRebind = func(addresses []string) {
+			log.Printf("Interface %s addresses changed, now listening on %s", iface, strings.Join(addresses, ", "))
+			logging.Infof("%s: interface %s addresses changed, now listening on %s", name, iface, strings.Join(addresses, ", "))
+		}
+		opts.OnListenError = func(address string, err error) {
+			log.Printf("Cannot listen on %s of interface %s, trying again: %v", address, iface, err)
+			logging.Printf("%s: cannot listen on %s of interface %s, trying again: %v", name, address, iface, err)
+		}
+	}
+	if f.connection.LocalSocket != "" {
+		socket, err := config.ExpandHome(f.connection.LocalSocket)
+		if err != nil {
+			return opts, err
+		}
+		opts.Socket = socket
+	}
+	if f.connection.OwnerOnly {
+		log := m.connectionLog(name)
+		opts.OwnerOnly = true
+		opts.OnRefused = func(remote net.Addr, err error) {
+			log.Printf("Refused the connection from %s: %v", remote, err)
+			logging.Verbosef("%s: refused the connection from %s: %v", name, remote, err)
+		}
+	}
+	if limit := f.connection.RateLimit; limit != nil {
+		opts.UploadRate, opts.DownloadRate = int64(limit.Upload), int64(limit.Download)
+	}
+	if f.connection.TLS != nil {
+		c, err := tlsConfig(name, f.connection)
+		if err != nil {
+			return opts, fmt.Errorf("cannot set up TLS: %v", err)
+		}
+		opts.TLS = c
+	}
+	if f.connection.Inject != nil {
+		h, err := m.injectOptions(name, f)
+		if err != nil {
+			return opts, err
+		}
+		opts.HTTP = h
+	}
+	return opts, nil
+}
+
+// gateInterval is how often a probe gating a forward's readiness checks until it passes.
+const gateInterval = time.Second
+
+// runProbe checks a forward generation whose local port is up until it ends, reporting
+// it as failed after FailureThreshold consecutive probe failures. With gate, it checks
+// right away and every gateInterval until a check passes, which makes the forward ready.
+func (m *Manager) runProbe(ctx context.Context, name string, generation int, port int, settings probe.Settings, gate bool) {
+	failures := 0
+	for first := true; ; first = false {
+		if !gate || !first {
+			wait := settings.Interval
+			if gate && wait > gateInterval {
+				wait = gateInterval
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		err := probe.Check(ctx, port, settings)
+		if ctx.Err() == nil {
+			m.reportReachability(ctx, name, generation, err)
+		}
+		if err == nil {
+			if failures > 0 {
+				logging.FromContext(ctx).Printf("health probe passed after %d failure(s)", failures)
+			}
+			failures = 0
+			if gate {
+				gate = false
+				select {
+				case m.updates <- update{name: name, generation: generation, probePassed: true}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		failures++
+		logging.FromContext(ctx).Printf("health probe failed (%d/%d): %v", failures, settings.FailureThreshold, err)
+		if failures < settings.FailureThreshold {
+			continue
+		}
+
+		status := model.PortForwardStatus{
+			Type:        model.PortForwardError,
+			Time:        time.Now(),
+			ServiceName: name,
+			Err:         fmt.Errorf("health probe failed %d times: %v", failures, err),
+			Unhealthy:   true,
+		}
+		select {
+		case m.updates <- update{name: name, generation: generation, status: status}:
+		case <-ctx.Done():
+		}
+		return
+	}
+}
+
+// relay forwards the statuses of one forward generation to the manager loop until the
+// final one, or until statusCh is closed by a setup stopped before it started. Ready or
+// the generation ending ends its setup, freeing its startup slot.
+func (m *Manager) relay(name string, generation int, statusCh <-chan model.PortForwardStatus) {
+	settingUp := true
+	for {
+		select {
+		case status, ok := <-statusCh:
+			if !ok {
+				return
+			}
+			if settingUp && status.Type != model.PortForwardStarting && status.Type != model.PortForwardPodResolved {
+				m.throttle.release()
+				settingUp = false
+			}
+			select {
+			case m.updates <- update{name: name, generation: generation, status: status}:
+			case <-m.ctx.Done():
+				return
+			}
+			if status.Final() {
+				return
+			}
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
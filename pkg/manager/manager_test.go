@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/kube/kubefake"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// TestForwardRestartsAfterFailure drives a forward through starting, ready and failed
+// and checks that it is restarted on the same pod and serves again.
+func TestForwardRestartsAfterFailure(t *testing.T) {
+	backend := echoServer(t)
+	client := kubefake.New()
+	client.SetPod("svc/db", "db-0", backend)
+
+	port := freePort(t)
+	cfg := &model.Contexts{Contexts: []model.Context{{
+		Name: "test",
+		Connections: []model.Connection{{
+			ServiceName:       "db",
+			Namespace:         "default",
+			LocalPort:         port,
+			RemoteServicePort: 5432,
+		}},
+	}}}
+	m := New(cfg, Options{Context: "test", RetryDelay: 10 * time.Millisecond, Client: client.Factory()})
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	waitFor(t, events, EventStarting)
+	if ready := waitFor(t, events, EventReady); ready.Pod != "db-0" {
+		t.Errorf("ready on pod %q, want db-0", ready.Pod)
+	}
+	checkEcho(t, port)
+
+	dropped := errors.New("connection to the API server lost")
+	client.Fail(dropped)
+	if failed := waitFor(t, events, EventFailed); !errors.Is(failed.Err, dropped) {
+		t.Errorf("failed with %v, want %v", failed.Err, dropped)
+	}
+
+	waitFor(t, events, EventRestarting)
+	waitFor(t, events, EventReady)
+	checkEcho(t, port)
+
+	status := m.Status()
+	if len(status) != 1 {
+		t.Fatalf("got %d forwards, want 1", len(status))
+	}
+	if f := status[0]; f.State != StateReady || f.Restarts != 1 || f.TotalFailures != 1 || f.Failures != 0 {
+		t.Errorf("got state %s, %d restarts, %d failures, %d consecutive, want ready, 1, 1, 0",
+			f.State, f.Restarts, f.TotalFailures, f.Failures)
+	}
+}
+
+// waitFor skips events until one of type want arrives.
+func waitFor(t *testing.T, events <-chan Event, want EventType) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == want {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", want)
+		}
+	}
+}
+
+// echoServer returns the address of a server echoing what it reads.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// checkEcho checks that a message sent to the local port comes back.
+func checkEcho(t *testing.T, port int) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("got %q back, want ping", buf)
+	}
+}
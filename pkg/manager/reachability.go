@@ -0,0 +1,52 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+	"github.com/rparaujo/kpfm/pkg/probe"
+)
+
+// Reachability is the outcome of the last local dial of a ready forward, telling a
+// tunnel that is up but reaches nothing apart from one that is down.
+type Reachability struct {
+	Reachable bool
+	CheckedAt time.Time
+	Error     string `json:",omitempty"` // why the dial failed
+}
+
+// reachabilityCheck is the dial of the reachability checks, the TCP health probe.
+var reachabilityCheck = probe.Settings{Timeout: model.DefaultProbeTimeout}
+
+// checkReachability dials a ready forward generation right away and then every
+// interval until it ends, reporting each outcome to the manager loop. Forwards with a
+// health probe report the outcomes of the probe instead.
+func (m *Manager) checkReachability(ctx context.Context, name string, generation int, port int, interval time.Duration) {
+	for {
+		err := probe.Check(ctx, port, reachabilityCheck)
+		if ctx.Err() != nil {
+			return
+		}
+		m.reportReachability(ctx, name, generation, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// reportReachability sends the outcome of a check of a forward generation to the
+// manager loop.
+func (m *Manager) reportReachability(ctx context.Context, name string, generation int, err error) {
+	r := &Reachability{Reachable: err == nil, CheckedAt: time.Now()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	select {
+	case m.updates <- update{name: name, generation: generation, reachability: r}:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,67 @@
+package manager
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// throttle limits how many forwards are set up at once and spaces out their starts, so
+// large configs don't trip API server rate limits or session caps. Setting up covers the
+// pod lookup and the dial, up to the forward's first status.
+type throttle struct {
+	slots   chan struct{} // nil when the concurrency is unlimited
+	stagger time.Duration
+
+	mu   sync.Mutex
+	next time.Time // the earliest start of the next setup
+}
+
+func newThrottle(concurrency int, stagger time.Duration) *throttle {
+	t := &throttle{stagger: stagger}
+	if concurrency > 0 {
+		t.slots = make(chan struct{}, concurrency)
+	}
+	return t
+}
+
+// acquire waits for a free setup slot and the forward's turn. It returns false, holding
+// nothing, when stop is closed or ctx is cancelled first.
+func (t *throttle) acquire(ctx context.Context, stop <-chan struct{}) bool {
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+		case <-stop:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if t.stagger <= 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	start := t.next
+	if now := time.Now(); start.Before(now) {
+		start = now
+	}
+	t.next = start.Add(t.stagger)
+	t.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(start)):
+		return true
+	case <-stop:
+	case <-ctx.Done():
+	}
+	t.release()
+	return false
+}
+
+// release frees the slot taken by a successful acquire.
+func (t *throttle) release() {
+	if t.slots != nil {
+		<-t.slots
+	}
+}
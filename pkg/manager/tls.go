@@ -0,0 +1,48 @@
+package manager
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rparaujo/kpfm/pkg/config"
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/model"
+	"github.com/rparaujo/kpfm/pkg/proxy"
+)
+
+// tlsConfig returns how the proxy of the forward named name terminates TLS: with the
+// connection's certificate, or with a self-signed one kept in config.CertDir.
+func tlsConfig(name string, connection model.Connection) (*tls.Config, error) {
+	t := connection.TLS
+	if t.CertFile != "" || t.KeyFile != "" {
+		certFile, err := config.ExpandHome(t.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err := config.ExpandHome(t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return proxy.ServerTLS(certFile, keyFile)
+	}
+
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host := connection.LocalHost(); host != "localhost" {
+		hosts = append(hosts, host)
+	}
+	if connection.Hostname != "" {
+		hosts = append(hosts, connection.Hostname)
+	}
+	hosts = append(hosts, t.Hosts...)
+	base := filepath.Join(config.CertDir(), strings.NewReplacer("/", "_", string(os.PathSeparator), "_", ":", "_").Replace(name))
+	c, created, err := proxy.SelfSignedTLS(base+".crt", base+".key", hosts)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		logging.Infof("Serving %s with the new self-signed certificate %s.crt", name, base)
+	}
+	return c, nil
+}
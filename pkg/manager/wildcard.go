@@ -0,0 +1,101 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/logging"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// serviceSyncInterval is how often the services behind an AllServices connection are listed.
+const serviceSyncInterval = 15 * time.Second
+
+// discovery carries the services currently found for an AllServices connection.
+type discovery struct {
+	kubeContext string
+	source      string // the namespace of the AllServices connection
+	connections []model.Connection
+}
+
+// watchServices lists the services of an AllServices connection until ctx is cancelled
+// and hands every result to the manager loop.
+func (m *Manager) watchServices(ctx context.Context, kubeContext string, wildcard model.Connection) {
+	ticker := time.NewTicker(serviceSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		_, clientset, err := kube.Clientset(wildcard.KubeContext(kubeContext))
+		if err == nil {
+			var connections []model.Connection
+			connections, err = kube.ExpandAllServices(ctx, clientset, wildcard)
+			if err == nil {
+				d := &discovery{kubeContext: kubeContext, source: wildcard.Namespace, connections: connections}
+				select {
+				case m.updates <- update{discovery: d}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if err != nil {
+			logging.Printf("Cannot list services in namespace %s: %v", wildcard.Namespace, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// syncDiscovered starts forwards for new services of an AllServices connection and
+// stops those whose service is gone. m.mu must be held.
+func (m *Manager) syncDiscovered(d *discovery) {
+	if d.kubeContext != m.kubeContext {
+		// Listed before a context change.
+		return
+	}
+
+	ports := make(map[string]int)
+	for _, connection := range d.connections {
+		ports[connection.DisplayName()]++
+	}
+	wanted := make(map[string]model.Connection)
+	for _, connection := range d.connections {
+		name := connection.DisplayName()
+		if ports[name] > 1 {
+			name = fmt.Sprintf("%s:%d", name, connection.RemoteServicePort)
+		}
+		wanted[name] = connection
+	}
+
+	current := m.discovered[d.source]
+	for name := range current {
+		if _, ok := wanted[name]; ok {
+			continue
+		}
+		if f, ok := m.forwards[name]; ok {
+			m.stopForward(name, f)
+		}
+		delete(current, name)
+	}
+	if current == nil {
+		current = make(map[string]bool)
+		m.discovered[d.source] = current
+	}
+	for name, connection := range wanted {
+		if current[name] || m.removed[m.kubeContext][name] {
+			continue
+		}
+		if _, ok := m.forwards[name]; ok {
+			// Also configured explicitly.
+			continue
+		}
+		current[name] = true
+		m.startForward(d.kubeContext, name, connection)
+	}
+}
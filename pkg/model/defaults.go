@@ -0,0 +1,172 @@
+package model
+
+import "time"
+
+// Built-in values of settings left unset in the config, filled in by ApplyDefaults.
+const (
+	DefaultRelayImage            = "alpine/socat"
+	DefaultUDPRelayImage         = "python:3-alpine"
+	DefaultProbeInterval         = 10 * time.Second
+	DefaultProbeTimeout          = 2 * time.Second
+	DefaultProbeFailureThreshold = 3
+	DefaultDNSListen             = "127.0.0.1:1053"
+	DefaultClusterDomain         = "cluster.local"
+	DefaultRouterListen          = "127.0.0.1:8000"
+	DefaultRouterDomain          = "localhost"
+	DefaultDashboardListen       = "127.0.0.1:7070"
+	DefaultRESTListen            = "127.0.0.1:7072"
+	DefaultDependsOnTimeout      = 5 * time.Minute
+	DefaultRequestTimeout        = 30 * time.Second
+	DefaultEventLogMaxSizeMB     = 10
+	DefaultEventLogMaxFiles      = 3
+	DefaultTokenTTL              = 5 * time.Minute
+	DefaultReachabilityInterval  = 30 * time.Second
+)
+
+// Defaults holds connection settings applied to every connection that leaves them
+// unset. A context's Defaults take precedence over the top-level ones.
+type Defaults struct {
+	Namespace              string        `yaml:"Namespace,omitempty"`
+	Address                string        `yaml:"Address,omitempty"`
+	RetryDelay             Duration      `yaml:"RetryDelay,omitempty"`
+	MaxConsecutiveFailures int           `yaml:"MaxConsecutiveFailures,omitempty"`
+	RestartPolicy          RestartPolicy `yaml:"RestartPolicy,omitempty"`
+	WaitForPodTimeout      Duration      `yaml:"WaitForPodTimeout,omitempty"`
+	OwnerOnly              bool          `yaml:"OwnerOnly,omitempty"`
+	DrainTimeout           Duration      `yaml:"DrainTimeout,omitempty"`
+	IdleTimeout            Duration      `yaml:"IdleTimeout,omitempty"`
+	Lazy                   bool          `yaml:"Lazy,omitempty"`
+}
+
+// apply fills the fields of connection that are unset from d.
+func (d *Defaults) apply(connection *Connection) {
+	if d == nil {
+		return
+	}
+	if connection.Namespace == "" {
+		connection.Namespace = d.Namespace
+	}
+	if connection.Address == "" {
+		connection.Address = d.Address
+	}
+	if connection.RetryDelay == 0 {
+		connection.RetryDelay = d.RetryDelay
+	}
+	if connection.MaxConsecutiveFailures == 0 {
+		connection.MaxConsecutiveFailures = d.MaxConsecutiveFailures
+	}
+	if connection.RestartPolicy == "" {
+		connection.RestartPolicy = d.RestartPolicy
+	}
+	if connection.WaitForPodTimeout == 0 {
+		connection.WaitForPodTimeout = d.WaitForPodTimeout
+	}
+	if d.OwnerOnly {
+		connection.OwnerOnly = true
+	}
+	if connection.DrainTimeout == 0 {
+		connection.DrainTimeout = d.DrainTimeout
+	}
+	if connection.IdleTimeout == 0 {
+		connection.IdleTimeout = d.IdleTimeout
+	}
+	if d.Lazy {
+		connection.Lazy = true
+	}
+}
+
+// ApplyDefaults fills every unset setting of the config: connection settings from the
+// context's Defaults, then from the top-level Defaults, then the built-in values, and the
+// built-in values of the enabled feature blocks. It is the one place config defaults
+// are decided.
+func (c *Contexts) ApplyDefaults() {
+	for i := range c.Contexts {
+		ctx := &c.Contexts[i]
+		for j := range ctx.Connections {
+			connection := &ctx.Connections[j]
+			ctx.Defaults.apply(connection)
+			c.Defaults.apply(connection)
+			connection.applyDefaults()
+		}
+	}
+
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = Duration(DefaultRequestTimeout)
+	}
+	if c.ReachabilityInterval == 0 {
+		c.ReachabilityInterval = Duration(DefaultReachabilityInterval)
+	}
+	if c.DNS != nil {
+		setDefault(&c.DNS.Listen, DefaultDNSListen)
+		setDefault(&c.DNS.ClusterDomain, DefaultClusterDomain)
+	}
+	if c.HTTPRouter != nil {
+		setDefault(&c.HTTPRouter.Listen, DefaultRouterListen)
+		setDefault(&c.HTTPRouter.Domain, DefaultRouterDomain)
+	}
+	if c.Dashboard != nil {
+		setDefault(&c.Dashboard.Listen, DefaultDashboardListen)
+	}
+	if c.REST != nil {
+		setDefault(&c.REST.Listen, DefaultRESTListen)
+	}
+	if c.EventLog != nil {
+		c.EventLog.ApplyDefaults()
+	}
+	for i := range c.Webhooks {
+		if c.Webhooks[i].Format == "" {
+			c.Webhooks[i].Format = WebhookJSON
+		}
+	}
+}
+
+// applyDefaults fills the built-in values of the connection's unset settings.
+func (c *Connection) applyDefaults() {
+	if c.IsUDP() {
+		setDefault(&c.RelayImage, DefaultUDPRelayImage)
+	} else if c.Relay {
+		setDefault(&c.RelayImage, DefaultRelayImage)
+	}
+	if c.RestartPolicy == "" {
+		c.RestartPolicy = RestartOnFailure
+	}
+	if len(c.DependsOn) > 0 && c.DependsOnTimeout <= 0 {
+		c.DependsOnTimeout = Duration(DefaultDependsOnTimeout)
+	}
+	if c.Probe != nil {
+		c.Probe.ApplyDefaults()
+	}
+	if c.Inject != nil && c.Inject.BearerTokenCommand != "" && c.Inject.TokenTTL <= 0 {
+		c.Inject.TokenTTL = Duration(DefaultTokenTTL)
+	}
+}
+
+// ApplyDefaults fills the unset settings of the probe with the built-in values.
+func (p *Probe) ApplyDefaults() {
+	if p.Interval <= 0 {
+		p.Interval = Duration(DefaultProbeInterval)
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = Duration(DefaultProbeTimeout)
+	}
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = DefaultProbeFailureThreshold
+	}
+}
+
+// ApplyDefaults fills the unset sizes of the event log with the built-in values. The
+// location defaults to the state directory, which the config package knows.
+func (e *EventLog) ApplyDefaults() {
+	if e.MaxSizeMB <= 0 {
+		e.MaxSizeMB = DefaultEventLogMaxSizeMB
+	}
+	if e.MaxFiles <= 0 {
+		e.MaxFiles = DefaultEventLogMaxFiles
+	}
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
@@ -1,25 +1,831 @@
 package model
 
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Connection struct {
-	ServiceName       string `yaml:"ServiceName,omitempty"`
-	PodName           string `yaml:"PodName,omitempty"`
-	RemoteServicePort int    `yaml:"RemoteServicePort,omitempty"`
-	RemotePodPort     int    `yaml:"RemotePodPort,omitempty"` // Using a pointer to allow for empty values
-	Namespace         string `yaml:"Namespace"`
-	LocalPort         int    `yaml:"LocalPort"`
+	Name              string   `yaml:"Name,omitempty"` // how the connection is referred to, defaults to the service or pod name
+	ServiceName       string   `yaml:"ServiceName,omitempty"`
+	PodName           string   `yaml:"PodName,omitempty"`
+	RemoteServicePort int      `yaml:"RemoteServicePort,omitempty"`
+	RemotePodPort     int      `yaml:"RemotePodPort,omitempty"` // Using a pointer to allow for empty values
+	Namespace         string   `yaml:"Namespace"`
+	LocalPort         int      `yaml:"LocalPort"` // assigned and remembered by kpfm when unset
+	Tags              []string `yaml:"Tags,omitempty"`
+	Protocol          string   `yaml:"Protocol,omitempty"`    // TCP (default) or UDP
+	RelayImage        string   `yaml:"RelayImage,omitempty"`  // image of relay pods: with socat for Relay, python3 for UDP
+	KeepAlive         Duration `yaml:"KeepAlive,omitempty"`   // TCP keepalive period on local connections
+	IdleTimeout       Duration `yaml:"IdleTimeout,omitempty"` // tear the forward down after this long without traffic
+	Lazy              bool     `yaml:"Lazy,omitempty"`        // only set up the forward when a local client first connects
+	Probe             *Probe   `yaml:"Probe,omitempty"`
+	Hostname          string   `yaml:"Hostname,omitempty"` // added to the hosts file as 127.0.0.1 while the forward is up
+	OnReady           string   `yaml:"OnReady,omitempty"`  // shell command run each time the forward becomes ready
+	OnStop            string   `yaml:"OnStop,omitempty"`   // shell command run when the forward is torn down
+	Address           string   `yaml:"Address,omitempty"`  // local bind address, defaults to localhost
+	// AllServices forwards every TCP port of every service in Namespace to PortOffset
+	// plus the service port, following services as they come and go.
+	AllServices bool `yaml:"AllServices,omitempty"`
+	PortOffset  int  `yaml:"PortOffset,omitempty"`
+	// RetryDelay and MaxConsecutiveFailures override the manager's retry policy.
+	RetryDelay             Duration      `yaml:"RetryDelay,omitempty"`
+	MaxConsecutiveFailures int           `yaml:"MaxConsecutiveFailures,omitempty"`
+	RestartPolicy          RestartPolicy `yaml:"RestartPolicy,omitempty"`
+	// Pinned keeps the connection forwarded on its own context whatever the current
+	// context is, e.g. for a shared observability cluster.
+	Pinned bool `yaml:"Pinned,omitempty"`
+	// Context forwards to that kube context's cluster instead of the one of the Contexts
+	// entry the connection is listed under, while still starting and stopping with it.
+	Context string `yaml:"Context,omitempty"`
+	// DependsOn names connections that must be ready, their OnReady hooks done, before
+	// this one starts. It fails if they aren't within DependsOnTimeout.
+	DependsOn        []string `yaml:"DependsOn,omitempty"`
+	DependsOnTimeout Duration `yaml:"DependsOnTimeout,omitempty"`
+	// WaitForPodTimeout keeps looking for a pod of the service, with backoff, for up to
+	// that long when it has none, e.g. while scaled down, instead of failing right away.
+	WaitForPodTimeout Duration `yaml:"WaitForPodTimeout,omitempty"`
+	// PodSelection picks which of the service's pods is forwarded to, by default the
+	// first one the API lists. PodNamePrefix goes with name-prefix.
+	PodSelection  PodSelection `yaml:"PodSelection,omitempty"`
+	PodNamePrefix string       `yaml:"PodNamePrefix,omitempty"`
+	// PodOrdinal targets the StatefulSet pod with that ordinal, e.g. 0 for mydb-0.
+	PodOrdinal *int `yaml:"PodOrdinal,omitempty"`
+	// LoadBalance spreads local connections round-robin over forwards to every ready
+	// pod of the service instead of forwarding to a single one.
+	LoadBalance bool `yaml:"LoadBalance,omitempty"`
+	// Relay forwards through a socat relay pod (RelayImage) connecting on to the service's
+	// cluster address, for ExternalName services and endpoints that aren't pods.
+	Relay bool `yaml:"Relay,omitempty"`
+	// Interface binds the local port to the addresses of that network interface, e.g.
+	// tailscale0 to share a forward over a tailnet only, following them as they change.
+	Interface string `yaml:"Interface,omitempty"`
+	// Transport is how the traffic reaches the pod, through pods/portforward by default.
+	Transport Transport `yaml:"Transport,omitempty"`
+	// LocalSocket serves the forward on a unix socket at that path instead of a local
+	// TCP port, only accessible to the user running kpfm.
+	LocalSocket string `yaml:"LocalSocket,omitempty"`
+	// TLS serves the local port over HTTPS, terminating TLS in front of the forward, for
+	// local clients that refuse to speak plaintext.
+	TLS *TLS `yaml:"TLS,omitempty"`
+	// Inject puts an HTTP proxy in front of the forward that adds headers to every
+	// request or presents a client certificate to the backend, so services behind auth
+	// can be called from local tools as is.
+	Inject *Inject `yaml:"Inject,omitempty"`
+	// RateLimit caps the traffic of the forward, summed over its local connections, so a
+	// bulk copy can't saturate a link shared with other forwards.
+	RateLimit *RateLimit `yaml:"RateLimit,omitempty"`
+	// OwnerOnly refuses local connections from other users than the one running kpfm,
+	// for multi-user hosts. It is only supported on Linux.
+	OwnerOnly bool `yaml:"OwnerOnly,omitempty"`
+	// DrainTimeout, when the forward is stopped or the context changes, stops accepting
+	// local connections but gives the open ones up to that long to finish before the
+	// tunnel is torn down.
+	DrainTimeout Duration `yaml:"DrainTimeout,omitempty"`
+	// Labels are free-form key/value metadata, e.g. team: payments, shown in status,
+	// metrics and events and matched by the --selector flags.
+	Labels map[string]string `yaml:"Labels,omitempty"`
+}
+
+// TLS configures the certificate a connection's local port is served with. Without
+// CertFile and KeyFile kpfm generates a self-signed certificate for localhost, the
+// connection's Hostname and Hosts, and keeps it across restarts so it can be trusted once.
+type TLS struct {
+	CertFile string   `yaml:"CertFile,omitempty"` // PEM certificate chain
+	KeyFile  string   `yaml:"KeyFile,omitempty"`  // PEM private key of CertFile
+	Hosts    []string `yaml:"Hosts,omitempty"`    // extra names and addresses of the self-signed certificate
+}
+
+// KubeContext returns the kube context the connection dials when listed under owner:
+// its Context, or owner when it has none.
+func (c Connection) KubeContext(owner string) string {
+	if c.Context != "" {
+		return c.Context
+	}
+	return owner
+}
+
+// LocalHost returns the host clients reach the connection's local port on.
+func (c Connection) LocalHost() string {
+	if c.Interface != "" {
+		if addresses, err := InterfaceAddresses(c.Interface); err == nil && len(addresses) > 0 {
+			return addresses[0]
+		}
+		return c.Interface
+	}
+	switch c.Address {
+	case "", "localhost", "0.0.0.0", "::":
+		return "localhost"
+	}
+	return c.Address
+}
+
+// LocalAddr returns where clients reach the connection: its LocalSocket, or the
+// host:port of its local port.
+func (c Connection) LocalAddr() string {
+	if c.LocalSocket != "" {
+		return c.LocalSocket
+	}
+	return net.JoinHostPort(c.LocalHost(), strconv.Itoa(c.LocalPort))
+}
+
+// BindAddresses returns the local addresses the connection listens on: 127.0.0.1 and
+// ::1 for localhost, the configured Address, or the current addresses of Interface.
+func (c Connection) BindAddresses() []string {
+	if c.Interface != "" {
+		if addresses, err := InterfaceAddresses(c.Interface); err == nil && len(addresses) > 0 {
+			return addresses
+		}
+		// Binding the interface name fails, telling why.
+		return []string{c.Interface}
+	}
+	if c.Address == "" || c.Address == "localhost" {
+		return []string{"127.0.0.1", "::1"}
+	}
+	return []string{c.Address}
+}
+
+// Inject configures the HTTP proxy of a connection. A backend speaking TLS is only
+// verified against CAFile; the tunnel to it is authenticated by the API server already.
+type Inject struct {
+	Headers map[string]string `yaml:"Headers,omitempty"` // set on every request
+	// BearerTokenCommand is a shell command printing a token sent as "Authorization:
+	// Bearer <token>". The token is reused for TokenTTL, or until the backend answers 401.
+	BearerTokenCommand string   `yaml:"BearerTokenCommand,omitempty"`
+	TokenTTL           Duration `yaml:"TokenTTL,omitempty"`
+	// BackendTLS speaks HTTPS to the backend, which presenting a client certificate implies.
+	BackendTLS     bool   `yaml:"BackendTLS,omitempty"`
+	ClientCertFile string `yaml:"ClientCertFile,omitempty"`
+	ClientKeyFile  string `yaml:"ClientKeyFile,omitempty"`
+	CAFile         string `yaml:"CAFile,omitempty"`
+	ServerName     string `yaml:"ServerName,omitempty"` // verified against CAFile, defaults to <ServiceName>.<Namespace>.svc
+}
+
+// SpeaksTLS reports whether the proxy speaks HTTPS to the backend.
+func (i Inject) SpeaksTLS() bool {
+	return i.BackendTLS || i.ClientCertFile != "" || i.CAFile != ""
+}
+
+// RateLimit is in bytes per second in each direction; 0 leaves a direction unlimited.
+type RateLimit struct {
+	Upload   ByteSize `yaml:"Upload,omitempty"`   // from local clients to the cluster
+	Download ByteSize `yaml:"Download,omitempty"` // from the cluster to local clients
+}
+
+// InterfaceAddresses returns the IPv4 and non link-local IPv6 addresses of the network
+// interface called name, IPv4 first.
+func InterfaceAddresses(name string) ([]string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 []string
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			v4 = append(v4, ipnet.IP.String())
+		} else {
+			v6 = append(v6, ipnet.IP.String())
+		}
+	}
+	return append(v4, v6...), nil
+}
+
+// Probe configures active health probing of a forward's local port. With GRPC it calls
+// the standard gRPC health service, with HTTPPath it requests the path, and otherwise it
+// only checks that a TCP connection is accepted and not immediately dropped.
+type Probe struct {
+	Interval         Duration `yaml:"Interval,omitempty"`
+	Timeout          Duration `yaml:"Timeout,omitempty"`
+	FailureThreshold int      `yaml:"FailureThreshold,omitempty"` // consecutive failures before restarting
+	HTTPPath         string   `yaml:"HTTPPath,omitempty"`
+	ExpectStatus     int      `yaml:"ExpectStatus,omitempty"` // defaults to any status below 400
+	GRPC             bool     `yaml:"GRPC,omitempty"`
+	GRPCService      string   `yaml:"GRPCService,omitempty"` // the service checked over GRPC, the whole server when empty
+	GateReady        bool     `yaml:"GateReady,omitempty"`   // the forward is only ready once a probe passed
+}
+
+// DisplayName returns the name a connection is referred to by in status, logs, hooks and
+// control commands: Name, or else its service or pod name.
+func (c Connection) DisplayName() string {
+	switch {
+	case c.Name != "":
+		return c.Name
+	case c.ServiceName != "":
+		return c.ServiceName
+	}
+	return c.PodName
+}
+
+// Answers reports whether name refers to the connection, by its Name or by its service
+// or pod name.
+func (c Connection) Answers(name string) bool {
+	return name != "" && (name == c.Name || name == c.ServiceName || name == c.PodName)
+}
+
+// IsUDP reports whether the connection forwards UDP traffic through an in-cluster relay.
+func (c Connection) IsUDP() bool {
+	return strings.EqualFold(c.Protocol, "udp")
+}
+
+// Target returns the kubectl-style target of the connection, e.g. "svc/postgresql" or "pod/keycloak-0".
+func (c Connection) Target() string {
+	if c.AllServices {
+		return "svc/*"
+	}
+	if c.PodName != "" {
+		return "pod/" + c.PodName
+	}
+	return "svc/" + c.ServiceName
+}
+
+// FormatLabels renders labels sorted by key as "key=value,key=value", or "" when there
+// are none.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + labels[key]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// MetricLabelName turns a Labels key into the Prometheus label name metrics export it as:
+// label_<key>, with the characters Prometheus doesn't allow, such as the dots and slashes
+// of Kubernetes-style keys, replaced with underscores.
+func MetricLabelName(key string) string {
+	name := []byte("label_" + key)
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// CheckLabels returns an error when two Labels keys would be exported as the same
+// MetricLabelName.
+func (c Connection) CheckLabels() error {
+	keys := make([]string, 0, len(c.Labels))
+	for key := range c.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := make(map[string]string)
+	for _, key := range keys {
+		name := MetricLabelName(key)
+		if first, ok := seen[name]; ok {
+			return fmt.Errorf("labels %s and %s are both exported as %s, rename one of them", first, key, name)
+		}
+		seen[name] = key
+	}
+	return nil
+}
+
+// HasAnyTag reports whether the connection carries at least one of tags.
+// An empty tags list matches every connection.
+func (c Connection) HasAnyTag(tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, want := range tags {
+		for _, have := range c.Tags {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type Context struct {
-	Name        string       `yaml:"Name"`
+	Name string `yaml:"Name"`
+	// Inherit names a context whose connections this one forwards too. A connection
+	// of this context with the name of an inherited one overrides the fields it sets,
+	// e.g. only Namespace and LocalPort, instead of adding a connection.
+	Inherit     string       `yaml:"Inherit,omitempty"`
 	Connections []Connection `yaml:"Connections"`
+	SSHJumpHost *SSHJumpHost `yaml:"SSHJumpHost,omitempty"`
+	Defaults    *Defaults    `yaml:"Defaults,omitempty"`
+	// Impersonate and ImpersonateGroups make every API call of the context, forwards
+	// included, act as that user or service account, e.g.
+	// system:serviceaccount:dev:port-forwarder.
+	Impersonate       string       `yaml:"Impersonate,omitempty"`
+	ImpersonateGroups []string     `yaml:"ImpersonateGroups,omitempty"`
+	Credentials       *Credentials `yaml:"Credentials,omitempty"`
+	// Use adds the connections of templates, instantiated with parameters of the
+	// context, after its own Connections.
+	Use []TemplateUse `yaml:"Use,omitempty"`
+}
+
+// Template is a list of connections parameterized with ${name} placeholders, which
+// contexts instantiate with Use instead of repeating the same connections. Parameters
+// holds the placeholders with their default values; those left empty must be set by
+// every Use. The connections are kept as written since their placeholders may stand
+// for numbers, and only decoded once instantiated.
+type Template struct {
+	Name        string                   `yaml:"Name"`
+	Parameters  map[string]string        `yaml:"Parameters,omitempty"`
+	Connections []map[string]interface{} `yaml:"Connections"`
+}
+
+// TemplateUse instantiates a Template in a context.
+type TemplateUse struct {
+	Template   string            `yaml:"Template"`
+	Parameters map[string]string `yaml:"Parameters,omitempty"`
+}
+
+// Credentials replace the kubeconfig user's credentials for a context, e.g. with the
+// token of a service account allowed only pods/portforward. Either a token or a client
+// certificate and key are set.
+type Credentials struct {
+	Token          string `yaml:"Token,omitempty"`
+	TokenFile      string `yaml:"TokenFile,omitempty"` // reread as it rotates
+	ClientCertFile string `yaml:"ClientCertFile,omitempty"`
+	ClientKeyFile  string `yaml:"ClientKeyFile,omitempty"`
+}
+
+// SSHJumpHost reaches a context's API server through an ssh tunnel to a bastion that
+// kpfm opens with the system ssh client.
+type SSHJumpHost struct {
+	Host         string   `yaml:"Host"` // [user@]host[:port]
+	IdentityFile string   `yaml:"IdentityFile,omitempty"`
+	Options      []string `yaml:"Options,omitempty"` // extra ssh -o options, e.g. StrictHostKeyChecking=accept-new
 }
 
 // Define a struct to hold the entire collection of contexts.
 type Contexts struct {
-	Contexts []Context `yaml:"Contexts"`
+	// Version is the layout of the config file, see `kpfm config migrate`. Files
+	// without it predate versioning.
+	Version              int        `yaml:"Version,omitempty"`
+	Contexts             []Context  `yaml:"Contexts"`
+	Templates            []Template `yaml:"Templates,omitempty"`
+	DesktopNotifications bool       `yaml:"DesktopNotifications,omitempty"`
+	TrafficStats         bool       `yaml:"TrafficStats,omitempty"` // count traffic through a local proxy in front of each forward
+	// MaxConsecutiveFailures marks a connection as broken after that many failures in a
+	// row and stops retrying it until `kpfm retry`. Zero retries forever.
+	MaxConsecutiveFailures int            `yaml:"MaxConsecutiveFailures,omitempty"`
+	RequestTimeout         Duration       `yaml:"RequestTimeout,omitempty"`       // how long a single Kubernetes API call may take
+	ReachabilityInterval   Duration       `yaml:"ReachabilityInterval,omitempty"` // how often ready forwards without a Probe are dialed, negative for never
+	DNS                    *DNS           `yaml:"DNS,omitempty"`
+	HTTPRouter             *HTTPRouter    `yaml:"HTTPRouter,omitempty"`
+	Dashboard              *Dashboard     `yaml:"Dashboard,omitempty"`
+	GRPC                   *GRPC          `yaml:"GRPC,omitempty"`
+	REST                   *REST          `yaml:"REST,omitempty"`
+	Defaults               *Defaults      `yaml:"Defaults,omitempty"`
+	ContextSwitch          *ContextSwitch `yaml:"ContextSwitch,omitempty"`
+	Startup                *Startup       `yaml:"Startup,omitempty"`
+	EnvFile                *EnvFile       `yaml:"EnvFile,omitempty"`
+	EventLog               *EventLog      `yaml:"EventLog,omitempty"`
+	StateFile              *StateFile     `yaml:"StateFile,omitempty"`
+	LogFilter              *LogFilter     `yaml:"LogFilter,omitempty"`
+	Webhooks               []Webhook      `yaml:"Webhooks,omitempty"`
+}
+
+// Webhook is an HTTP endpoint notified when a forward is marked broken and when it
+// recovers.
+type Webhook struct {
+	URL      string            `yaml:"URL"`
+	Format   WebhookFormat     `yaml:"Format,omitempty"`   // payload shape, json by default
+	Template string            `yaml:"Template,omitempty"` // Go template of the message text
+	Headers  map[string]string `yaml:"Headers,omitempty"`  // e.g. Authorization: Bearer ...
+}
+
+// EventLog tunes the log of lifecycle events `kpfm start` appends to as JSON lines,
+// which is on by default.
+type EventLog struct {
+	Disabled  bool   `yaml:"Disabled,omitempty"`
+	Path      string `yaml:"Path,omitempty"`      // defaults to events.log in the state directory
+	MaxSizeMB int    `yaml:"MaxSizeMB,omitempty"` // rotate the file once it grows past this size
+	MaxFiles  int    `yaml:"MaxFiles,omitempty"`  // rotated files kept next to it
+}
+
+// StateFile tunes the JSON file `kpfm start` keeps up to date with the state of every
+// forward while it runs, for shell prompts and status lines. It is on by default.
+type StateFile struct {
+	Disabled bool   `yaml:"Disabled,omitempty"`
+	Path     string `yaml:"Path,omitempty"` // defaults to state.json in the state directory
+}
+
+// LogFilter drops repetitive lines of the forwarders' output from the connection logs
+// and the -vv output, keeping a count of them in the forward's status. By default it
+// drops the "Handling connection for" line printed for every local connection.
+type LogFilter struct {
+	KeepHandlingConnection bool     `yaml:"KeepHandlingConnection,omitempty"`
+	Patterns               []string `yaml:"Patterns,omitempty"` // regular expressions of further lines to drop
+	// ShowFrom is the verbosity showing the dropped lines anyway: normal, verbose or
+	// debug. Unset drops them at every verbosity.
+	ShowFrom LogLevel `yaml:"ShowFrom,omitempty"`
 }
 
+// LogLevel is a kpfm verbosity named in the config file.
+type LogLevel string
+
+const (
+	LogLevelNormal  LogLevel = "normal"
+	LogLevelVerbose LogLevel = "verbose" // -v
+	LogLevelDebug   LogLevel = "debug"   // -vv
+)
+
+// LogLevels lists the valid LogLevel values.
+var LogLevels = []LogLevel{LogLevelNormal, LogLevelVerbose, LogLevelDebug}
+
+func (l *LogLevel) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	for _, valid := range LogLevels {
+		if strings.EqualFold(s, string(valid)) {
+			*l = valid
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid log level %q: must be normal, verbose or debug", s)
+}
+
+// EnvFile keeps a file up to date with the local endpoints of the active forwards.
+type EnvFile struct {
+	Path     string `yaml:"Path"`               // e.g. ~/src/app/.env
+	Template string `yaml:"Template,omitempty"` // Go template file to render instead of KPFM_<NAME>_* variables
+}
+
+// Startup throttles how forwards are set up, for large configs that would otherwise trip
+// API server client rate limits or session caps by dialing everything at once.
+type Startup struct {
+	Concurrency int      `yaml:"Concurrency,omitempty"` // forwards resolving and dialing at the same time, unlimited when zero
+	Stagger     Duration `yaml:"Stagger,omitempty"`     // minimum delay between the starts of two setups
+}
+
+// ContextSwitch tunes how the forwards follow changes of the current kube context.
+type ContextSwitch struct {
+	Debounce      Duration `yaml:"Debounce,omitempty"`      // how long a new context must stay current before the forwards follow it
+	Confirm       string   `yaml:"Confirm,omitempty"`       // ask before following: terminal or desktop
+	CheckInterval Duration `yaml:"CheckInterval,omitempty"` // how often the current context is polled, 10s by default
+	Watch         bool     `yaml:"Watch,omitempty"`         // also react to writes to the kubeconfig files right away
+}
+
+// ActiveConnections returns the connections forwarded while contextName is current: its
+// own and the pinned connections of the other contexts. Those get the context they are
+// listed under as their Context, so KubeContext(contextName) is the one they dial.
+func (c *Contexts) ActiveConnections(contextName string) []Connection {
+	var connections []Connection
+	for _, ctx := range c.Contexts {
+		for _, connection := range ctx.Connections {
+			if ctx.Name == contextName || (connection.Pinned && !connection.AllServices) {
+				connection.Context = connection.KubeContext(ctx.Name)
+				connections = append(connections, connection)
+			}
+		}
+	}
+	return connections
+}
+
+// CheckLocalPorts returns an error naming the first two connections active on contextName
+// that would listen on the same local port. Connections rejected by filter are ignored,
+// as are AllServices connections and those without a LocalPort, whose ports are only
+// known once the services are listed or the ports assigned.
+func (c *Contexts) CheckLocalPorts(contextName string, filter func(Connection) bool) error {
+	type listener struct {
+		udp     bool
+		address string
+		port    int
+	}
+	seen := make(map[listener]Connection)
+	for _, connection := range c.ActiveConnections(contextName) {
+		if connection.AllServices || connection.LocalPort == 0 || (filter != nil && !filter(connection)) {
+			continue
+		}
+		l := listener{udp: connection.IsUDP(), address: connection.LocalHost(), port: connection.LocalPort}
+		if first, ok := seen[l]; ok {
+			return fmt.Errorf("connections %s/%s and %s/%s in context %s both use local port %d",
+				first.Namespace, first.Target(), connection.Namespace, connection.Target(), contextName, connection.LocalPort)
+		}
+		seen[l] = connection
+	}
+	return nil
+}
+
+// CheckNames returns an error when two connections active on contextName would be known
+// by the same name, e.g. the same service forwarded twice without a Name to tell them
+// apart. Connections rejected by filter and AllServices connections are ignored.
+func (c *Contexts) CheckNames(contextName string, filter func(Connection) bool) error {
+	seen := make(map[string]bool)
+	for _, connection := range c.ActiveConnections(contextName) {
+		if connection.AllServices || (filter != nil && !filter(connection)) {
+			continue
+		}
+		name := connection.DisplayName()
+		if seen[name] {
+			return fmt.Errorf("two connections in context %s are named %s, set a distinct Name on them", contextName, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// CheckLabels returns an error when two Labels keys of a connection would be exported as
+// the same Prometheus label name, e.g. team.name and team/name, which fails the scrape.
+func (c *Contexts) CheckLabels() error {
+	for _, ctx := range c.Contexts {
+		for _, connection := range ctx.Connections {
+			if err := connection.CheckLabels(); err != nil {
+				return fmt.Errorf("connection %s in context %s: %v", connection.DisplayName(), ctx.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckDependencies returns an error when a connection active on contextName depends on
+// a connection that isn't, or when connections depend on each other in a cycle.
+func (c *Contexts) CheckDependencies(contextName string) error {
+	dependencies := make(map[string][]string)
+	for _, connection := range c.ActiveConnections(contextName) {
+		if !connection.AllServices {
+			dependencies[connection.DisplayName()] = connection.DependsOn
+		}
+	}
+	for name, dependsOn := range dependencies {
+		for _, dependency := range dependsOn {
+			if _, ok := dependencies[dependency]; !ok {
+				return fmt.Errorf("connection %s in context %s depends on %s, which is not a connection of that context", name, contextName, dependency)
+			}
+		}
+	}
+
+	// Depth-first search, reporting the first cycle found as a path.
+	const (
+		visiting = 1
+		done     = 2
+	)
+	marks := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return fmt.Errorf("connections in context %s depend on each other: %s", contextName, strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		case done:
+			return nil
+		}
+		marks[name] = visiting
+		path = append(path, name)
+		for _, dependency := range dependencies[name] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[name] = done
+		return nil
+	}
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// REST enables the token protected REST API.
+type REST struct {
+	Listen string `yaml:"Listen,omitempty"` // loopback address, defaults to 127.0.0.1:7072
+	Token  string `yaml:"Token,omitempty"`  // generated and written next to the control socket when empty
+}
+
+// GRPC enables the gRPC control API.
+type GRPC struct {
+	Listen string `yaml:"Listen,omitempty"` // unix://<path> or loopback host:port, defaults to a socket next to the control socket
+	Token  string `yaml:"Token,omitempty"`  // required on host:port, generated and written next to the control socket when empty
+}
+
+// Dashboard enables the local web dashboard.
+type Dashboard struct {
+	Listen string `yaml:"Listen,omitempty"` // loopback address, defaults to 127.0.0.1:7070
+}
+
+// HTTPRouter enables a reverse proxy on a single local port that routes requests for
+// <name>.<Domain> to the forward of the service or pod called name.
+type HTTPRouter struct {
+	Listen string `yaml:"Listen,omitempty"` // defaults to 127.0.0.1:8000
+	Domain string `yaml:"Domain,omitempty"` // defaults to localhost
+}
+
+// DNS enables a local DNS stub resolving <service>.<namespace>.svc.<ClusterDomain> names
+// of forwarded services to 127.0.0.1.
+type DNS struct {
+	Listen        string `yaml:"Listen,omitempty"`        // UDP address, defaults to 127.0.0.1:1053
+	ClusterDomain string `yaml:"ClusterDomain,omitempty"` // defaults to cluster.local
+}
+
+// PortForwardStatusType is the lifecycle transition of a forward generation a
+// PortForwardStatus reports.
+type PortForwardStatusType string
+
+const (
+	PortForwardStarting           PortForwardStatusType = "starting"            // looking up the pod
+	PortForwardPodResolved        PortForwardStatusType = "pod-resolved"        // the pod is known, dialing it
+	PortForwardReady              PortForwardStatusType = "ready"               // the local listeners are up
+	PortForwardConnectionAccepted PortForwardStatusType = "connection-accepted" // a local client connected
+	PortForwardError              PortForwardStatusType = "error"               // the generation failed with Err
+	PortForwardStopped            PortForwardStatusType = "stopped"             // the generation ended without an error
+)
+
+// PortForwardStatus reports a transition of a forward generation, from Starting to
+// Error or Stopped, which end it.
 type PortForwardStatus struct {
+	Type        PortForwardStatusType
+	Time        time.Time
 	ServiceName string
+	PodName     string // the pod the forward goes to, set from PodResolved on
+	Unhealthy   bool   // set when Err comes from a failed health probe of a running forward
 	Err         error
 }
+
+// Final reports whether the status ends its generation.
+func (s PortForwardStatus) Final() bool {
+	return s.Type == PortForwardError || s.Type == PortForwardStopped
+}
+
+// Duration is a time.Duration written in config files as a string like "30s" or "5m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// Transport is how a connection's traffic reaches its pod.
+type Transport string
+
+const (
+	TransportPortForward Transport = "portforward" // pods/portforward, the default
+	TransportExec        Transport = "exec"        // pods/exec of socat or nc in the pod's container, for clusters denying port-forwards
+	TransportAuto        Transport = "auto"        // port-forward, or exec when the user may not port-forward to the pod
+)
+
+// Transports lists the valid Transport values.
+var Transports = []Transport{TransportPortForward, TransportExec, TransportAuto}
+
+func (t *Transport) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	for _, valid := range Transports {
+		if strings.EqualFold(s, string(valid)) {
+			*t = valid
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid transport %q: must be portforward, exec or auto", s)
+}
+
+// ByteSize is a number of bytes written in config files as an integer or a string like
+// "512KB" or "10MB". Units are powers of 1024: K and KiB are 1024 like KB.
+type ByteSize int64
+
+var byteUnits = map[string]int64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+}
+
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	trimmed := strings.TrimSpace(s)
+	i := strings.IndexFunc(trimmed, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(trimmed)
+	}
+	number, err := strconv.ParseFloat(trimmed[:i], 64)
+	unit, ok := byteUnits[strings.ToUpper(strings.TrimSpace(trimmed[i:]))]
+	if err != nil || !ok || number < 0 {
+		return fmt.Errorf("invalid size %q: must be a number of bytes like 512KB or 10MB", s)
+	}
+	*b = ByteSize(number * float64(unit))
+	return nil
+}
+
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	return int64(b), nil
+}
+
+// RestartPolicy decides whether a forward that went down is brought back.
+type RestartPolicy string
+
+const (
+	RestartOnFailure RestartPolicy = "on-failure" // retry failures until MaxConsecutiveFailures, the default
+	RestartAlways    RestartPolicy = "always"     // retry failures and forwards that ended cleanly, never giving up
+	RestartNever     RestartPolicy = "never"      // leave a failed forward down until it is retried or restarted
+)
+
+// RestartPolicies lists the valid policies.
+var RestartPolicies = []RestartPolicy{RestartOnFailure, RestartAlways, RestartNever}
+
+func (p *RestartPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	for _, policy := range RestartPolicies {
+		if strings.EqualFold(s, string(policy)) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid restart policy %q: must be always, on-failure or never", s)
+}
+
+// PodSelection decides which pod of a service a connection forwards to.
+type PodSelection string
+
+const (
+	PodNewest     PodSelection = "newest"      // the most recently created pod, e.g. the canary of a rollout
+	PodOldest     PodSelection = "oldest"      // the longest running pod
+	PodRandom     PodSelection = "random"      // any pod, spreading forwards over the replicas
+	PodNamePrefix PodSelection = "name-prefix" // the first pod whose name starts with PodNamePrefix
+)
+
+// PodSelections lists the valid selections.
+var PodSelections = []PodSelection{PodNewest, PodOldest, PodRandom, PodNamePrefix}
+
+func (p *PodSelection) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	for _, selection := range PodSelections {
+		if strings.EqualFold(s, string(selection)) {
+			*p = selection
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid pod selection %q: must be newest, oldest, random or name-prefix", s)
+}
+
+// WebhookFormat decides the shape of the payload posted to a webhook.
+type WebhookFormat string
+
+const (
+	WebhookJSON  WebhookFormat = "json"  // the message along with the event, the default
+	WebhookSlack WebhookFormat = "slack" // a Slack incoming webhook payload, also understood by Mattermost and Rocket.Chat
+)
+
+// WebhookFormats lists the valid formats.
+var WebhookFormats = []WebhookFormat{WebhookJSON, WebhookSlack}
+
+func (f *WebhookFormat) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	for _, format := range WebhookFormats {
+		if strings.EqualFold(s, string(format)) {
+			*f = format
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid webhook format %q: must be json or slack", s)
+}
@@ -0,0 +1,98 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// SchemaID is where the published JSON Schema of the config file lives.
+const SchemaID = "https://raw.githubusercontent.com/rparaujo/kpfm/main/schema/config.schema.json"
+
+var (
+	durationType = reflect.TypeOf(Duration(0))
+	byteSizeType = reflect.TypeOf(ByteSize(0))
+)
+
+// enums holds the valid values of the config's enumerated string types.
+var enums = map[reflect.Type]interface{}{
+	reflect.TypeOf(RestartPolicy("")): RestartPolicies,
+	reflect.TypeOf(PodSelection("")):  PodSelections,
+	reflect.TypeOf(WebhookFormat("")): WebhookFormats,
+	reflect.TypeOf(Transport("")):     Transports,
+	reflect.TypeOf(LogLevel("")):      LogLevels,
+}
+
+// JSONSchema returns a JSON Schema of the config file for editors to validate and
+// complete it with. Properties use the canonical lowerCamelCase keys; kpfm reads keys
+// case-insensitively.
+func JSONSchema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(Contexts{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = SchemaID
+	schema["title"] = "kpfm config"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		return map[string]interface{}{
+			"type":    "string",
+			"pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		}
+	}
+	if t == byteSizeType {
+		return map[string]interface{}{
+			"type":    []string{"integer", "string"},
+			"pattern": `^[0-9]+(\.[0-9]+)? *([kKmMgG]([iI]?[bB])?|[bB])?$`,
+		}
+	}
+	if values, ok := enums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": values}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if f.PkgPath != "" || name == "" || name == "-" {
+				continue
+			}
+			properties[LowerCamel(name)] = schemaFor(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return map[string]interface{}{}
+}
+
+// LowerCamel returns name with its leading upper-case run lowered, keeping the last
+// letter of an initialism before a word: ServiceName becomes serviceName, HTTPPath
+// httpPath, DNS dns.
+func LowerCamel(name string) string {
+	runes := []rune(name)
+	i := 0
+	for i < len(runes) && runes[i] >= 'A' && runes[i] <= 'Z' {
+		i++
+	}
+	if i > 1 && i < len(runes) {
+		i--
+	}
+	if i == 0 {
+		i = 1
+	}
+	return strings.ToLower(string(runes[:i])) + string(runes[i:])
+}
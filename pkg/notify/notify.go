@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Send shows a desktop notification through the platform's notification daemon.
+// It uses osascript on macOS, notify-send on Linux and a PowerShell balloon tip on Windows.
+func Send(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux":
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return fmt.Errorf("notify-send not found: %v", err)
+		}
+		cmd = exec.Command("notify-send", "--app-name=kpfm", title, message)
+	case "windows":
+		script := "Add-Type -AssemblyName System.Windows.Forms; " +
+			"$n = New-Object System.Windows.Forms.NotifyIcon; " +
+			"$n.Icon = [System.Drawing.SystemIcons]::Information; $n.Visible = $true; " +
+			fmt.Sprintf("$n.ShowBalloonTip(5000, %s, %s, 'Info'); Start-Sleep -Seconds 6; $n.Dispose()",
+				powerShellString(title), powerShellString(message))
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		// The script keeps the icon alive until the balloon has been shown; don't wait for it.
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		go cmd.Wait()
+		return nil
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	return cmd.Run()
+}
+
+// Confirm asks a yes/no question in a desktop dialog and reports whether it was
+// accepted. It uses osascript on macOS, zenity on Linux and a PowerShell message box on
+// Windows.
+func Confirm(title, message string) (bool, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display dialog %s with title %s buttons {\"No\", \"Yes\"} default button \"Yes\"",
+			appleScriptString(message), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux":
+		if _, err := exec.LookPath("zenity"); err != nil {
+			return false, fmt.Errorf("zenity not found: %v", err)
+		}
+		cmd = exec.Command("zenity", "--question", "--title="+title, "--text="+message)
+	case "windows":
+		script := "Add-Type -AssemblyName System.Windows.Forms; " +
+			fmt.Sprintf("if ([System.Windows.Forms.MessageBox]::Show(%s, %s, 'YesNo') -ne 'Yes') { exit 1 }",
+				powerShellString(message), powerShellString(title))
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	default:
+		return false, fmt.Errorf("desktop dialogs are not supported on %s", runtime.GOOS)
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Declined or closed.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// powerShellString quotes s as a PowerShell single-quoted string literal.
+func powerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,111 @@
+// Package ports assigns local ports to connections that leave LocalPort unset and
+// remembers them, so a service keeps its port across restarts.
+package ports
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rparaujo/kpfm/pkg/kube"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// Assign sets LocalPort on every connection without one, reusing the port recorded in
+// the state file at path and recording ports picked for new connections. A new
+// connection gets its remote port (plus 10000 when privileged), or the next free port
+// not used by another connection of the same context.
+func Assign(contexts *model.Contexts, path string) error {
+	return assign(contexts, path, true)
+}
+
+// Plan sets LocalPort like Assign but doesn't record the ports picked for new
+// connections, for dry runs.
+func Plan(contexts *model.Contexts, path string) error {
+	return assign(contexts, path, false)
+}
+
+func assign(contexts *model.Contexts, path string, record bool) error {
+	assigned, err := load(path)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for i := range contexts.Contexts {
+		ctx := &contexts.Contexts[i]
+
+		taken := make(map[int]bool)
+		for _, connection := range ctx.Connections {
+			if connection.LocalPort != 0 {
+				taken[connection.LocalPort] = true
+			}
+		}
+		for _, connection := range ctx.Connections {
+			if port, ok := assigned[key(ctx.Name, connection)]; ok && connection.LocalPort == 0 {
+				taken[port] = true
+			}
+		}
+
+		for j := range ctx.Connections {
+			connection := &ctx.Connections[j]
+			if connection.LocalPort != 0 || connection.AllServices || connection.LocalSocket != "" {
+				continue
+			}
+			k := key(ctx.Name, *connection)
+			port, ok := assigned[k]
+			if !ok {
+				port = kube.SuggestLocalPort(connection.RemoteServicePort, taken)
+				if port == 0 {
+					return fmt.Errorf("no free local port for %s in context %s", connection.Target(), ctx.Name)
+				}
+				assigned[k] = port
+				taken[port] = true
+				changed = true
+			}
+			connection.LocalPort = port
+		}
+	}
+
+	if !changed || !record {
+		return nil
+	}
+	return save(path, assigned)
+}
+
+// key identifies a connection in the state file.
+func key(kubeContext string, connection model.Connection) string {
+	return fmt.Sprintf("%s/%s/%s:%d", kubeContext, connection.Namespace, connection.Target(), connection.RemoteServicePort)
+}
+
+func load(path string) (map[string]int, error) {
+	assigned := make(map[string]int)
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return assigned, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &assigned); err != nil {
+		return nil, fmt.Errorf("invalid port state file %s: %v", path, err)
+	}
+	return assigned, nil
+}
+
+func save(path string, assigned map[string]int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(assigned, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,133 @@
+// Package probe checks that a forwarded local port actually reaches its backend.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+// dropWindow is how long a TCP probe waits to see whether the forward drops the
+// connection, which is what client-go does when the backend is unreachable.
+const dropWindow = 500 * time.Millisecond
+
+// Settings are the effective probe settings of a connection, with defaults applied.
+type Settings struct {
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+	HTTPPath         string
+	ExpectStatus     int
+	GRPC             bool
+	GRPCService      string
+}
+
+// SettingsFor applies the defaults to a connection's probe configuration.
+func SettingsFor(p model.Probe) Settings {
+	p.ApplyDefaults()
+	return Settings{
+		Interval:         time.Duration(p.Interval),
+		Timeout:          time.Duration(p.Timeout),
+		FailureThreshold: p.FailureThreshold,
+		HTTPPath:         p.HTTPPath,
+		ExpectStatus:     p.ExpectStatus,
+		GRPC:             p.GRPC,
+		GRPCService:      p.GRPCService,
+	}
+}
+
+// Check probes the local port once.
+func Check(ctx context.Context, port int, s Settings) error {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	if s.GRPC {
+		return checkGRPC(ctx, port, s)
+	}
+	if s.HTTPPath != "" {
+		return checkHTTP(ctx, port, s)
+	}
+	return checkTCP(ctx, port)
+}
+
+// checkTCP dials the port and fails if the forward accepts but then drops the connection.
+func checkTCP(ctx context.Context, port int) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(dropWindow))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	switch {
+	case err == nil:
+		// The backend spoke first.
+		return nil
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// Still open: the backend is waiting for the client.
+		return nil
+	case err == io.EOF:
+		return errors.New("connection dropped by the forward")
+	default:
+		return err
+	}
+}
+
+func checkHTTP(ctx context.Context, port int, s Settings) error {
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", port, s.HTTPPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if s.ExpectStatus != 0 && resp.StatusCode != s.ExpectStatus {
+		return fmt.Errorf("GET %s returned %d, expected %d", s.HTTPPath, resp.StatusCode, s.ExpectStatus)
+	}
+	if s.ExpectStatus == 0 && resp.StatusCode >= 400 {
+		return fmt.Errorf("GET %s returned %d", s.HTTPPath, resp.StatusCode)
+	}
+	return nil
+}
+
+// checkGRPC calls the Check method of the gRPC health checking protocol, which fails
+// unless the service reports SERVING.
+func checkGRPC(ctx context.Context, port int, s Settings) error {
+	conn, err := grpc.DialContext(ctx, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: s.GRPCService})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		if s.GRPCService == "" {
+			return fmt.Errorf("gRPC health check returned %s", resp.Status)
+		}
+		return fmt.Errorf("gRPC health check of %s returned %s", s.GRPCService, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HTTPOptions make a proxy forward local connections as HTTP requests instead of piping
+// them, so headers can be added and the upstream spoken to over TLS.
+type HTTPOptions struct {
+	// Header returns the headers set on every request; an error fails the request.
+	Header func() (http.Header, error)
+	// Unauthorized is called when the upstream answers 401, e.g. to refresh a token.
+	Unauthorized func()
+	// UpstreamTLS, when set, speaks TLS to the upstream forward with this configuration.
+	UpstreamTLS *tls.Config
+}
+
+// httpProxy is the reverse proxy serving the connections of a proxy in HTTP mode.
+type httpProxy struct {
+	server    *http.Server
+	transport *http.Transport
+}
+
+func newHTTPProxy(upstreamPort int, opts HTTPOptions) *httpProxy {
+	scheme := "http"
+	if opts.UpstreamTLS != nil {
+		scheme = "https"
+	}
+	host := net.JoinHostPort("127.0.0.1", strconv.Itoa(upstreamPort))
+	transport := &http.Transport{
+		TLSClientConfig:     opts.UpstreamTLS,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	reverse := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme, req.URL.Host = scheme, host
+		},
+		Transport:     transport,
+		FlushInterval: -1, // keep server-sent events streaming
+		ModifyResponse: func(resp *http.Response) error {
+			if resp.StatusCode == http.StatusUnauthorized && opts.Unauthorized != nil {
+				opts.Unauthorized()
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			http.Error(w, "kpfm: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if opts.Header != nil {
+			header, err := opts.Header()
+			if err != nil {
+				http.Error(w, "kpfm: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+			for name, values := range header {
+				req.Header[name] = values
+			}
+		}
+		reverse.ServeHTTP(w, req)
+	})
+	return &httpProxy{
+		server:    &http.Server{Handler: handler, ReadHeaderTimeout: time.Minute},
+		transport: transport,
+	}
+}
+
+// serve handles the requests of conn until it is closed.
+func (h *httpProxy) serve(conn net.Conn) {
+	listener := &connListener{conn: conn, closed: make(chan struct{})}
+	h.server.Serve(listener)
+}
+
+// connListener hands a single connection to an http.Server, then blocks the next Accept
+// until that connection is closed so that Serve returns with it.
+type connListener struct {
+	conn   net.Conn
+	once   sync.Once
+	taken  bool
+	closed chan struct{}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	if !l.taken {
+		l.taken = true
+		return &closeNotifyConn{Conn: l.conn, l: l}, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// closeNotifyConn closes its listener along with the connection.
+type closeNotifyConn struct {
+	net.Conn
+	l *connListener
+}
+
+func (c *closeNotifyConn) Close() error {
+	err := c.Conn.Close()
+	c.l.Close()
+	return err
+}
+
+// countingConn counts the traffic of a local connection read and written by the HTTP
+// proxy, records activity and applies the rate limits.
+type countingConn struct {
+	net.Conn
+	p *Proxy
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b[:chunk(c.p.up, len(b))])
+	if n > 0 {
+		c.p.touch()
+		c.p.bytesIn.Add(int64(n))
+		if werr := c.p.wait(c.p.up, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		size := chunk(c.p.down, len(b)-written)
+		if err := c.p.wait(c.p.down, size); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(b[written : written+size])
+		if n > 0 {
+			c.p.touch()
+			c.p.bytesOut.Add(int64(n))
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
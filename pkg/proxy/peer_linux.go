@@ -0,0 +1,95 @@
+//go:build linux
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// peerUID returns the user owning the local end of a connection accepted on a loopback
+// listener, looked up by address in /proc/net/tcp and /proc/net/tcp6.
+func peerUID(conn net.Conn) (int, error) {
+	remote, ok1 := conn.RemoteAddr().(*net.TCPAddr)
+	local, ok2 := conn.LocalAddr().(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return 0, errors.New("not a TCP connection")
+	}
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		uid, found, err := findSocket(table, remote, local)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return uid, nil
+		}
+	}
+	return 0, fmt.Errorf("%s is not a connection from this host", remote)
+}
+
+// findSocket returns the uid column of the row of table connected from local to remote.
+func findSocket(table string, local, remote *net.TCPAddr) (int, bool, error) {
+	file, err := os.Open(table)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		l, lerr := parseProcAddr(fields[1])
+		r, rerr := parseProcAddr(fields[2])
+		if lerr != nil || rerr != nil || !sameAddr(l, local) || !sameAddr(r, remote) {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[7])
+		if err != nil {
+			return 0, false, err
+		}
+		return uid, true, nil
+	}
+	return 0, false, scanner.Err()
+}
+
+// parseProcAddr parses an address of /proc/net/tcp{,6}: the IP as hex 32-bit words in
+// host (little-endian) order, a colon and the port in hex.
+func parseProcAddr(s string) (*net.TCPAddr, error) {
+	host, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	raw, err := hex.DecodeString(host)
+	if err != nil || len(raw)%4 != 0 {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	return &net.TCPAddr{IP: net.IP(raw), Port: int(p)}, nil
+}
+
+func sameAddr(a, b *net.TCPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
+
+// checkOwnerOnly reports whether refusing other users' connections is supported.
+func checkOwnerOnly() error {
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+var errOwnerOnly = errors.New("OwnerOnly is only supported on Linux")
+
+func peerUID(conn net.Conn) (int, error) {
+	return 0, errOwnerOnly
+}
+
+func checkOwnerOnly() error {
+	return errOwnerOnly
+}
@@ -0,0 +1,545 @@
+// Package proxy implements the local TCP proxy kpfm can place in front of a forward.
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Stats are the traffic counters of a proxy.
+type Stats struct {
+	BytesIn           int64         // bytes sent by local clients
+	BytesOut          int64         // bytes received from the forward
+	ActiveConnections int64         // connections currently open
+	TotalConnections  int64         // connections accepted since start
+	ConnectionTime    time.Duration // summed duration of closed connections
+	LongestConnection time.Duration // duration of the longest closed connection
+}
+
+// Options tune how a proxy handles local connections.
+type Options struct {
+	// Addresses are the local addresses to listen on, 127.0.0.1 and ::1 when empty.
+	Addresses []string
+	// Socket, when set, is the path of a unix socket listened on instead of the port,
+	// accessible to the user running the proxy only.
+	Socket string
+	// KeepAlive enables TCP keepalive with this period on local connections.
+	KeepAlive time.Duration
+	// IdleTimeout closes every connection and calls OnIdle once no traffic flowed for this long.
+	IdleTimeout time.Duration
+	// OnIdle is called when the proxy became idle.
+	OnIdle func()
+	// Rebind, when set, returns the addresses to listen on instead of Addresses. It is
+	// called again every RebindInterval, moving the listeners when the addresses change,
+	// e.g. those of a network interface, and reporting the new ones to OnRebind.
+	Rebind         func() ([]string, error)
+	RebindInterval time.Duration
+	OnRebind       func(addresses []string)
+	// OnListenError is called when one of the Rebind addresses can't be listened on, once
+	// until it can. The address is tried again on every check.
+	OnListenError func(address string, err error)
+	// EnsureUpstream is called before a connection is piped and must return once the
+	// upstream forward accepts connections.
+	EnsureUpstream func() error
+	// TLS, when set, terminates TLS on local connections, piping the plaintext to the
+	// upstream forward.
+	TLS *tls.Config
+	// HTTP, when set, proxies local connections as HTTP requests.
+	HTTP *HTTPOptions
+	// UploadRate and DownloadRate cap the bytes per second flowing from local clients
+	// and back to them, summed over all connections; 0 leaves a direction unlimited.
+	UploadRate   int64
+	DownloadRate int64
+	// OwnerOnly refuses connections from other users than the one running the proxy,
+	// calling OnRefused with the reason.
+	OwnerOnly bool
+	OnRefused func(remote net.Addr, err error)
+}
+
+// Proxy listens on a local port and pipes every connection to an upstream forward,
+// counting the traffic that flows through it.
+type Proxy struct {
+	opts         Options
+	upstreamPort int
+	port         int
+	lastActivity atomic.Int64 // unix nanoseconds
+	idle         atomic.Bool
+	done         chan struct{}
+	ctx          context.Context // cancelled by Close
+	cancel       context.CancelFunc
+	http         *httpProxy // set in HTTP mode
+	up, down     *rate.Limiter
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	active   atomic.Int64
+	total    atomic.Int64
+
+	mu      sync.Mutex
+	connSum time.Duration
+	connMax time.Duration
+	conns   map[net.Conn]struct{}
+	closed  bool
+	drained chan struct{} // set by Drain, closed once no connection is open
+
+	listeners map[string]net.Listener // by address, guarded by mu
+	failing   map[string]bool         // Rebind addresses reported to OnListenError
+}
+
+// Listen starts a proxy on port of opts.Addresses, or on opts.Socket. The upstream forward is expected on
+// UpstreamPort, a free port picked by Listen.
+func Listen(port int, opts Options) (*Proxy, error) {
+	if opts.OwnerOnly {
+		if err := checkOwnerOnly(); err != nil {
+			return nil, err
+		}
+	}
+	upstreamPort, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		opts:         opts,
+		upstreamPort: upstreamPort,
+		port:         port,
+		conns:        make(map[net.Conn]struct{}),
+		listeners:    make(map[string]net.Listener),
+		failing:      make(map[string]bool),
+		done:         make(chan struct{}),
+		up:           newLimiter(opts.UploadRate),
+		down:         newLimiter(opts.DownloadRate),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	if opts.HTTP != nil {
+		p.http = newHTTPProxy(upstreamPort, *opts.HTTP)
+	}
+	p.touch()
+	if opts.Socket != "" {
+		listener, err := listenSocket(opts.Socket)
+		if err != nil {
+			p.cancel()
+			return nil, err
+		}
+		p.serve(opts.Socket, listener)
+	} else {
+		addresses := opts.Addresses
+		if opts.Rebind != nil {
+			if addresses, err = opts.Rebind(); err != nil {
+				p.cancel()
+				return nil, err
+			}
+		}
+		if len(addresses) == 0 && opts.Rebind == nil {
+			addresses = []string{"127.0.0.1", "::1"}
+		}
+		var listenErr error
+		for _, address := range addresses {
+			if err := p.rebindListen(address); err != nil && listenErr == nil {
+				listenErr = err
+			}
+		}
+		if len(p.listeners) == 0 {
+			p.cancel()
+			if listenErr != nil {
+				return nil, fmt.Errorf("unable to listen on local port %d: %w", port, listenErr)
+			}
+			return nil, fmt.Errorf("unable to listen on local port %d", port)
+		}
+		if opts.Rebind != nil {
+			go p.watchAddresses()
+		}
+	}
+
+	if opts.IdleTimeout > 0 {
+		go p.watchIdle()
+	}
+	return p, nil
+}
+
+// UpstreamPort is the local port the forward behind the proxy must listen on.
+func (p *Proxy) UpstreamPort() int {
+	return p.upstreamPort
+}
+
+// Stats returns a snapshot of the proxy's counters.
+func (p *Proxy) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{
+		BytesIn:           p.bytesIn.Load(),
+		BytesOut:          p.bytesOut.Load(),
+		ActiveConnections: p.active.Load(),
+		TotalConnections:  p.total.Load(),
+		ConnectionTime:    p.connSum,
+		LongestConnection: p.connMax,
+	}
+}
+
+// Close stops listening and closes every open connection.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	p.cancel()
+	for _, listener := range p.listeners {
+		listener.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	if p.http != nil {
+		p.http.transport.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Drain stops listening, freeing the local port, and returns a channel closed once no
+// connection is open through the proxy anymore. The proxy keeps piping the open ones
+// until then or until Close.
+func (p *Proxy) Drain() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.drained != nil {
+		return p.drained
+	}
+	p.drained = make(chan struct{})
+	for address, listener := range p.listeners {
+		listener.Close()
+		delete(p.listeners, address)
+	}
+	if len(p.conns) == 0 {
+		close(p.drained)
+	}
+	return p.drained
+}
+
+// listen starts accepting connections on the proxy's port of address.
+func (p *Proxy) listen(address string) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(p.port)))
+	if err != nil {
+		return err
+	}
+	p.serve(address, listener)
+	return nil
+}
+
+// serve accepts the connections of listener, known by address.
+func (p *Proxy) serve(address string, listener net.Listener) {
+	if p.opts.TLS != nil {
+		listener = tls.NewListener(listener, p.opts.TLS)
+	}
+	p.mu.Lock()
+	p.listeners[address] = listener
+	p.mu.Unlock()
+	go p.accept(listener)
+}
+
+// watchAddresses moves the listeners whenever Rebind returns other addresses.
+func (p *Proxy) watchAddresses() {
+	interval := p.opts.RebindInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		addresses, err := p.opts.Rebind()
+		if err != nil {
+			// The interface went away; it may come back.
+			addresses = nil
+		}
+		if p.rebind(addresses) && p.opts.OnRebind != nil {
+			p.opts.OnRebind(addresses)
+		}
+	}
+}
+
+// rebind closes the listeners of addresses no longer wanted and listens on the new
+// ones, reporting whether anything changed. Existing connections are left open.
+func (p *Proxy) rebind(addresses []string) bool {
+	wanted := make(map[string]bool)
+	for _, address := range addresses {
+		wanted[address] = true
+	}
+	changed := false
+	p.mu.Lock()
+	if p.closed || p.drained != nil {
+		p.mu.Unlock()
+		return false
+	}
+	for address, listener := range p.listeners {
+		if !wanted[address] {
+			listener.Close()
+			delete(p.listeners, address)
+			changed = true
+		}
+	}
+	var added []string
+	for _, address := range addresses {
+		if _, ok := p.listeners[address]; !ok {
+			added = append(added, address)
+		}
+	}
+	p.mu.Unlock()
+	for _, address := range added {
+		// A failed listen is tried again on the next check.
+		if p.rebindListen(address) == nil {
+			changed = true
+		}
+	}
+	for address := range p.failing {
+		if !wanted[address] {
+			delete(p.failing, address)
+		}
+	}
+	return changed
+}
+
+// rebindListen listens on address, reporting a failure to OnListenError once until it
+// succeeds when the addresses come from Rebind.
+func (p *Proxy) rebindListen(address string) error {
+	err := p.listen(address)
+	if p.opts.Rebind == nil {
+		return err
+	}
+	if err == nil {
+		delete(p.failing, address)
+	} else if !p.failing[address] {
+		p.failing[address] = true
+		if p.opts.OnListenError != nil {
+			p.opts.OnListenError(address, err)
+		}
+	}
+	return err
+}
+
+func (p *Proxy) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener is closed when the proxy stops.
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *Proxy) handle(conn net.Conn) {
+	if p.opts.OwnerOnly {
+		if err := p.checkOwner(conn); err != nil {
+			conn.Close()
+			if p.opts.OnRefused != nil {
+				p.opts.OnRefused(conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+	if !p.track(conn) {
+		conn.Close()
+		return
+	}
+	p.touch()
+	p.idle.Store(false)
+	started := time.Now()
+	p.total.Add(1)
+	p.active.Add(1)
+	defer func() {
+		p.active.Add(-1)
+		p.untrack(conn, time.Since(started))
+	}()
+	defer conn.Close()
+
+	if tcp, ok := tcpConn(conn); ok && p.opts.KeepAlive > 0 {
+		tcp.SetKeepAlive(true)
+		tcp.SetKeepAlivePeriod(p.opts.KeepAlive)
+	}
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		// Clients failing the handshake don't wake an idle forward.
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+	}
+
+	if p.opts.EnsureUpstream != nil {
+		if err := p.opts.EnsureUpstream(); err != nil {
+			return
+		}
+	}
+
+	if p.http != nil {
+		p.http.serve(&countingConn{Conn: conn, p: p})
+		return
+	}
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(p.upstreamPort)))
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		p.pipe(upstream, conn, &p.bytesIn, p.up)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.pipe(conn, upstream, &p.bytesOut, p.down)
+		closeWrite(conn)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// checkOwner returns why conn isn't from the user running the proxy, or nil if it is.
+func (p *Proxy) checkOwner(conn net.Conn) error {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	if _, ok := conn.(*net.UnixConn); ok {
+		// Only the owner can open the socket.
+		return nil
+	}
+	uid, err := peerUID(conn)
+	if err != nil {
+		return err
+	}
+	if uid != os.Getuid() {
+		return fmt.Errorf("connection from uid %d", uid)
+	}
+	return nil
+}
+
+// pipe copies src to dst at the rate limit allows, counting bytes and recording activity.
+func (p *Proxy) pipe(dst io.Writer, src io.Reader, counter *atomic.Int64, limit *rate.Limiter) {
+	buf := make([]byte, chunk(limit, maxChunk))
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			p.touch()
+			if p.wait(limit, n) != nil {
+				return
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+			counter.Add(int64(n))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *Proxy) touch() {
+	p.lastActivity.Store(time.Now().UnixNano())
+}
+
+// watchIdle closes all connections and calls OnIdle once no traffic flowed for IdleTimeout.
+func (p *Proxy) watchIdle() {
+	interval := p.opts.IdleTimeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		if p.idle.Load() || time.Since(time.Unix(0, p.lastActivity.Load())) < p.opts.IdleTimeout {
+			continue
+		}
+		p.idle.Store(true)
+		p.closeConns()
+		if p.opts.OnIdle != nil {
+			p.opts.OnIdle()
+		}
+	}
+}
+
+func (p *Proxy) closeConns() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	if p.http != nil {
+		// The forward is torn down, taking the upstream connections kept for reuse with it.
+		p.http.transport.CloseIdleConnections()
+	}
+}
+
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.drained != nil {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn, duration time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+	if p.drained != nil && len(p.conns) == 0 && !p.closed {
+		close(p.drained)
+	}
+	p.connSum += duration
+	if duration > p.connMax {
+		p.connMax = duration
+	}
+}
+
+// closeWrite half-closes a TCP or TLS connection so the peer sees EOF.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+}
+
+// tcpConn returns the TCP connection under conn, a TLS one included.
+func tcpConn(conn net.Conn) (*net.TCPConn, bool) {
+	if c, ok := conn.(*tls.Conn); ok {
+		conn = c.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	return tcp, ok
+}
+
+// freePort asks the OS for an unused local TCP port.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
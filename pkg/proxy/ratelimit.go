@@ -0,0 +1,34 @@
+package proxy
+
+import "golang.org/x/time/rate"
+
+// maxChunk is the most bytes a rate limited connection moves at once.
+const maxChunk = 32 * 1024
+
+// newLimiter returns a limiter of bytesPerSecond, or nil when it is 0.
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond
+	if burst > maxChunk {
+		burst = maxChunk
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// chunk returns how many of n bytes may be moved at once under l.
+func chunk(l *rate.Limiter, n int) int {
+	if l != nil && n > l.Burst() {
+		return l.Burst()
+	}
+	return n
+}
+
+// wait blocks until n bytes may be moved under l, or the proxy is closed.
+func (p *Proxy) wait(l *rate.Limiter, n int) error {
+	if l == nil {
+		return nil
+	}
+	return l.WaitN(p.ctx, n)
+}
@@ -0,0 +1,103 @@
+// Package router serves HTTP on a single local port and routes each request to a
+// forward based on its Host header, e.g. foo.localhost to foo's local port.
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+const (
+	DefaultListen = model.DefaultRouterListen
+	DefaultDomain = model.DefaultRouterDomain
+)
+
+// Lookup returns the local port of the forward called name.
+type Lookup func(name string) (port int, ok bool)
+
+// Router is an HTTP reverse proxy routing <name>.<domain> to the forward called name.
+type Router struct {
+	suffix string
+	lookup Lookup
+	proxy  *httputil.ReverseProxy
+}
+
+type routeKey struct{}
+
+// New returns a router for hosts under domain (DefaultDomain when empty).
+func New(domain string, lookup Lookup) *Router {
+	if domain == "" {
+		domain = DefaultDomain
+	}
+	r := &Router{suffix: "." + strings.ToLower(strings.Trim(domain, ".")), lookup: lookup}
+	r.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = req.Context().Value(routeKey{}).(string)
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			http.Error(w, fmt.Sprintf("kpfm: forward unavailable: %v", err), http.StatusBadGateway)
+		},
+	}
+	return r
+}
+
+// ServeHTTP proxies req to the forward named by its Host header.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name, ok := r.name(req.Host)
+	if !ok {
+		http.Error(w, fmt.Sprintf("kpfm: %s is not a <name>%s host", req.Host, r.suffix), http.StatusNotFound)
+		return
+	}
+	port, ok := r.lookup(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("kpfm: no forward called %s", name), http.StatusNotFound)
+		return
+	}
+	upstream := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	r.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), routeKey{}, upstream)))
+}
+
+// name extracts the forward name from a Host header.
+func (r *Router) name(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if !strings.HasSuffix(host, r.suffix) {
+		return "", false
+	}
+	name := strings.TrimSuffix(host, r.suffix)
+	if name == "" || strings.Contains(name, ".") {
+		return "", false
+	}
+	return name, true
+}
+
+// ListenAndServe serves the router on addr until ctx is cancelled.
+func (r *Router) ListenAndServe(ctx context.Context, addr string) error {
+	if addr == "" {
+		addr = DefaultListen
+	}
+	srv := &http.Server{Addr: addr, Handler: r}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/client-go/util/homedir"
+)
+
+var plistTemplate = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{xml .Executable}}</string>
+		<string>start</string>
+		<string>--config</string>
+		<string>{{xml .ConfigPath}}</string>
+	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+	{{- range .Env}}
+		<key>{{xml .Key}}</key>
+		<string>{{xml .Value}}</string>
+	{{- end}}
+	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>5</integer>
+	<key>StandardOutPath</key>
+	<string>{{xml .LogPath}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .LogPath}}</string>
+</dict>
+</plist>
+`))
+
+// launchd manages kpfm as a launchd user agent logging to ~/Library/Logs/kpfm.
+type launchd struct{}
+
+func plistPath() string {
+	return filepath.Join(homedir.HomeDir(), "Library", "LaunchAgents", Label+".plist")
+}
+
+func logPath() string {
+	return filepath.Join(homedir.HomeDir(), "Library", "Logs", "kpfm", "kpfm.log")
+}
+
+// domain is the launchd domain of the current user's GUI session.
+func domain() string {
+	return fmt.Sprintf("gui/%d", os.Getuid())
+}
+
+func (launchd) Install(spec Spec) (string, error) {
+	type envVar struct{ Key, Value string }
+	var env []envVar
+	for key, value := range spec.Env {
+		env = append(env, envVar{key, value})
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i].Key < env[j].Key })
+
+	var buf strings.Builder
+	err := plistTemplate.Execute(&buf, struct {
+		Label, Executable, ConfigPath, LogPath string
+		Env                                    []envVar
+	}{Label, spec.Executable, spec.ConfigPath, logPath(), env})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logPath()), 0755); err != nil {
+		return "", err
+	}
+	path := plistPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(buf.String()), 0644); err != nil {
+		return "", err
+	}
+	// Reload in case an older definition is already bootstrapped.
+	_ = run("launchctl", "bootout", domain()+"/"+Label)
+	if err := run("launchctl", "bootstrap", domain(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (launchd) Uninstall() error {
+	_ = run("launchctl", "bootout", domain()+"/"+Label)
+	if err := os.Remove(plistPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (launchd) Start() error {
+	return run("launchctl", "kickstart", domain()+"/"+Label)
+}
+
+func (launchd) Stop() error {
+	// kpfm exits cleanly on SIGTERM, so KeepAlive doesn't bring it back.
+	return run("launchctl", "kill", "SIGTERM", domain()+"/"+Label)
+}
+
+func (launchd) Status() error {
+	if err := run("launchctl", "print", domain()+"/"+Label); err != nil {
+		return err
+	}
+	fmt.Printf("\nLogs: %s\n", logPath())
+	return nil
+}
+
+func xmlEscape(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,71 @@
+// Package service installs kpfm as a per-user background service managed by systemd
+// on Linux or launchd on macOS.
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+const (
+	// Name is the systemd unit name.
+	Name = "kpfm"
+	// Label is the launchd job label.
+	Label = "io.github.rparaujo.kpfm"
+)
+
+// Spec describes what the installed service runs.
+type Spec struct {
+	Executable string
+	ConfigPath string
+	// Env is copied into the service so exec auth plugins and KUBECONFIG resolve like
+	// in the installing shell.
+	Env map[string]string
+}
+
+// Manager installs and controls the kpfm service on the current platform.
+type Manager interface {
+	// Install writes the service definition and enables it at login.
+	Install(spec Spec) (string, error)
+	Uninstall() error
+	Start() error
+	Stop() error
+	Status() error
+}
+
+// ForPlatform returns the service manager for the running OS.
+func ForPlatform() (Manager, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return systemd{}, nil
+	case "darwin":
+		return launchd{}, nil
+	default:
+		return nil, fmt.Errorf("services are not supported on %s", runtime.GOOS)
+	}
+}
+
+// DefaultSpec returns a Spec running the current executable with configPath.
+func DefaultSpec(configPath string) (Spec, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return Spec{}, err
+	}
+	spec := Spec{Executable: exe, ConfigPath: configPath, Env: map[string]string{}}
+	for _, key := range []string{"PATH", "KUBECONFIG", "SOPS_AGE_KEY_FILE"} {
+		if value := os.Getenv(key); value != "" {
+			spec.Env[key] = value
+		}
+	}
+	return spec, nil
+}
+
+// run executes a service manager command, passing its output through.
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
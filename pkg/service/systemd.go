@@ -0,0 +1,101 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/client-go/util/homedir"
+)
+
+var unitTemplate = template.Must(template.New("unit").Funcs(template.FuncMap{"quote": systemdQuote}).Parse(`[Unit]
+Description=Kubernetes Port-Forward Manager
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart={{quote .Executable}} start --config {{quote .ConfigPath}}
+{{- range .Env}}
+Environment={{quote .}}
+{{- end}}
+Restart=on-failure
+RestartSec=5
+StandardOutput=journal
+StandardError=journal
+
+[Install]
+WantedBy=default.target
+`))
+
+// systemd manages kpfm as a systemd user unit; logs go to the user journal.
+type systemd struct{}
+
+func unitPath() string {
+	return filepath.Join(homedir.HomeDir(), ".config", "systemd", "user", Name+".service")
+}
+
+func (systemd) Install(spec Spec) (string, error) {
+	var env []string
+	for key, value := range spec.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+
+	var buf strings.Builder
+	err := unitTemplate.Execute(&buf, struct {
+		Executable, ConfigPath string
+		Env                    []string
+	}{spec.Executable, spec.ConfigPath, env})
+	if err != nil {
+		return "", err
+	}
+
+	path := unitPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(buf.String()), 0644); err != nil {
+		return "", err
+	}
+	if err := run("systemctl", "--user", "daemon-reload"); err != nil {
+		return "", err
+	}
+	if err := run("systemctl", "--user", "enable", Name+".service"); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (systemd) Uninstall() error {
+	if err := run("systemctl", "--user", "disable", "--now", Name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(unitPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return run("systemctl", "--user", "daemon-reload")
+}
+
+func (systemd) Start() error {
+	return run("systemctl", "--user", "start", Name+".service")
+}
+
+func (systemd) Stop() error {
+	return run("systemctl", "--user", "stop", Name+".service")
+}
+
+func (systemd) Status() error {
+	if err := run("systemctl", "--user", "--no-pager", "status", Name+".service"); err != nil {
+		return err
+	}
+	fmt.Printf("\nLogs: journalctl --user -u %s.service -f\n", Name)
+	return nil
+}
+
+// systemdQuote quotes a value for use in a unit file.
+func systemdQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(s) + `"`
+}
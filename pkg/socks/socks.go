@@ -0,0 +1,180 @@
+// Package socks implements the CONNECT command of a SOCKS5 server (RFC 1928) without
+// authentication, handing each destination to a pluggable dialer.
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	version5 = 5
+
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xff
+
+	cmdConnect = 1
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+
+	replySucceeded           = 0
+	replyGeneralFailure      = 1
+	replyHostUnreachable     = 4
+	replyCommandNotSupported = 7
+	replyAddressNotSupported = 8
+
+	handshakeTimeout = 10 * time.Second
+)
+
+// Dialer connects to the destination host and port of a CONNECT request.
+type Dialer func(ctx context.Context, host string, port int) (net.Conn, error)
+
+// Server is a SOCKS5 server.
+type Server struct {
+	dial Dialer
+}
+
+// NewServer returns a SOCKS5 server that opens connections with dial.
+func NewServer(dial Dialer) *Server {
+	return &Server{dial: dial}
+}
+
+// ListenAndServe accepts SOCKS5 clients on addr until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	host, port, err := s.handshake(conn)
+	if err != nil {
+		return
+	}
+
+	upstream, err := s.dial(ctx, host, port)
+	if err != nil {
+		log.Printf("SOCKS5 connection to %s failed: %v", net.JoinHostPort(host, strconv.Itoa(port)), err)
+		reply(conn, replyHostUnreachable)
+		return
+	}
+	defer upstream.Close()
+	if err := reply(conn, replySucceeded); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// handshake negotiates the auth method and reads the CONNECT request.
+func (s *Server) handshake(conn net.Conn) (string, int, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", 0, err
+	}
+	if header[0] != version5 {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", 0, err
+	}
+	method := byte(methodNoAcceptable)
+	for _, m := range methods {
+		if m == methodNoAuth {
+			method = methodNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{version5, method}); err != nil {
+		return "", 0, err
+	}
+	if method == methodNoAcceptable {
+		return "", 0, errors.New("client requires authentication")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", 0, err
+	}
+	if request[1] != cmdConnect {
+		reply(conn, replyCommandNotSupported)
+		return "", 0, fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case atypIPv4, atypIPv6:
+		size := net.IPv4len
+		if request[3] == atypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(ip).String()
+	case atypDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		reply(conn, replyAddressNotSupported)
+		return "", 0, fmt.Errorf("unsupported address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", 0, err
+	}
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
+
+// reply sends a CONNECT reply; the bound address is not meaningful for a tunnel.
+func reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{version5, code, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,94 @@
+// Package tray shows the forwards of a running instance in the system tray, with a
+// status icon and a menu to restart, pause and resume them, for those who don't keep a
+// terminal open. It is a client of the control API like the CLI commands.
+package tray
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// level is the overall health shown by the icon.
+type level int
+
+const (
+	levelDown    level = iota // kpfm isn't running
+	levelOK                   // every forward is ready, idle or paused
+	levelPending              // some forwards are starting, waiting or retrying
+	levelBroken               // some forwards gave up
+)
+
+var levelColors = map[level]color.RGBA{
+	levelDown:    {0x9e, 0x9e, 0x9e, 0xff},
+	levelOK:      {0x2e, 0xa0, 0x43, 0xff},
+	levelPending: {0xe3, 0xa0, 0x08, 0xff},
+	levelBroken:  {0xd7, 0x3a, 0x49, 0xff},
+}
+
+// summarize returns the icon level of a status and the tooltip describing it.
+func summarize(status *control.StatusResponse) (level, string) {
+	if status == nil {
+		return levelDown, "kpfm is not running"
+	}
+	l, ready := levelOK, 0
+	for _, f := range status.Forwards {
+		switch f.State {
+		case manager.StateReady:
+			ready++
+		case manager.StateBroken:
+			l = levelBroken
+		case manager.StateStarting, manager.StateFailed, manager.StateWaiting:
+			if l != levelBroken {
+				l = levelPending
+			}
+		}
+	}
+	return l, fmt.Sprintf("kpfm: %d/%d forwards ready in %s", ready, len(status.Forwards), status.Context)
+}
+
+// title is the menu entry of a forward.
+func title(f manager.ForwardStatus) string {
+	port := ""
+	if f.Connection.LocalPort != 0 {
+		port = fmt.Sprintf(" :%d", f.Connection.LocalPort)
+	}
+	return fmt.Sprintf("%s%s  %s", f.Name, port, f.State)
+}
+
+// fingerprint changes whenever the menu needs to be rebuilt.
+func fingerprint(status *control.StatusResponse) string {
+	if status == nil {
+		return ""
+	}
+	var b bytes.Buffer
+	b.WriteString(status.Context)
+	for _, f := range status.Forwards {
+		fmt.Fprintf(&b, "\x00%s\x00%s", title(f), f.LastError)
+	}
+	return b.String()
+}
+
+// icon draws a filled circle of the level's color as a PNG.
+func icon(l level) []byte {
+	const size = 22
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	c := levelColors[l]
+	center, radius := float64(size-1)/2, float64(size)/2-3
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := float64(x)-center, float64(y)-center
+			if dx*dx+dy*dy <= radius*radius {
+				img.Set(x, y, c)
+			}
+		}
+	}
+	var b bytes.Buffer
+	_ = png.Encode(&b, img)
+	return b.Bytes()
+}
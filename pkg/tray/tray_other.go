@@ -0,0 +1,15 @@
+//go:build !linux && !(darwin && cgo)
+
+package tray
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/control"
+)
+
+// Run reports that the tray isn't available on this platform.
+func Run(client *control.Client, interval time.Duration) error {
+	return errors.New("the system tray is only available on Linux and on macOS builds with cgo")
+}
@@ -0,0 +1,126 @@
+//go:build linux || (darwin && cgo)
+
+package tray
+
+import (
+	"errors"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"fyne.io/systray"
+
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/manager"
+)
+
+// Run shows the tray icon, refreshing it from client every interval, until Quit is
+// picked from its menu. It must be called from the main goroutine.
+func Run(client *control.Client, interval time.Duration) error {
+	if runtime.GOOS == "linux" && os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return errors.New("no D-Bus session bus, the tray needs a desktop session")
+	}
+	t := &tray{client: client, interval: interval, level: -1}
+	systray.Run(t.ready, nil)
+	return nil
+}
+
+type tray struct {
+	client   *control.Client
+	interval time.Duration
+
+	mu    sync.Mutex
+	level level         // the level the icon shows
+	shown string        // fingerprint of the status the menu shows
+	stale chan struct{} // closed when the menu is rebuilt, ending its click handlers
+}
+
+func (t *tray) ready() {
+	systray.SetTitle("kpfm")
+	t.refresh()
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		t.refresh()
+	}
+}
+
+// refresh updates the icon and rebuilds the menu if the forwards changed.
+func (t *tray) refresh() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, err := t.client.Status()
+	if err != nil {
+		status = nil
+	}
+	l, tooltip := summarize(status)
+	if l != t.level {
+		t.level = l
+		systray.SetIcon(icon(l))
+	}
+	systray.SetTooltip(tooltip)
+
+	shown := fingerprint(status)
+	if t.stale != nil && shown == t.shown {
+		return
+	}
+	t.shown = shown
+	if t.stale != nil {
+		close(t.stale)
+	}
+	t.stale = make(chan struct{})
+	systray.ResetMenu()
+	t.build(status)
+}
+
+func (t *tray) build(status *control.StatusResponse) {
+	if status == nil {
+		systray.AddMenuItem("kpfm is not running", "start it with kpfm start").Disable()
+	} else {
+		systray.AddMenuItem("Context "+status.Context, "").Disable()
+		systray.AddSeparator()
+		for _, f := range status.Forwards {
+			item := systray.AddMenuItem(title(f), f.LastError)
+			t.action(item.AddSubMenuItem("Restart", "tear the forward down and bring it up again"), f.Name, t.client.Restart)
+			if f.State == manager.StatePaused {
+				t.action(item.AddSubMenuItem("Resume", "bring the forward up again"), f.Name, t.client.Resume)
+			} else {
+				t.action(item.AddSubMenuItem("Pause", "stop the forward and free its local port"), f.Name, t.client.Pause)
+			}
+			if f.State == manager.StateBroken {
+				t.action(item.AddSubMenuItem("Retry", "retry the broken forward"), f.Name, t.client.Retry)
+			}
+		}
+	}
+	systray.AddSeparator()
+	quit := systray.AddMenuItem("Quit", "close the tray, leaving the forwards running")
+	stale := t.stale
+	go func() {
+		select {
+		case <-quit.ClickedCh:
+			systray.Quit()
+		case <-stale:
+		}
+	}()
+}
+
+// action calls fn with the forward's name whenever item is clicked, until the menu is
+// rebuilt.
+func (t *tray) action(item *systray.MenuItem, name string, fn func(string) error) {
+	stale := t.stale
+	go func() {
+		for {
+			select {
+			case <-item.ClickedCh:
+				if err := fn(name); err != nil {
+					log.Printf("%s: %v", name, err)
+				}
+				t.refresh()
+			case <-stale:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,171 @@
+// Package update replaces the running kpfm binary with the latest GitHub release.
+package update
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ReleasesURL is the GitHub API endpoint describing the latest release.
+var ReleasesURL = "https://api.github.com/repos/rparaujo/kpfm/releases/latest"
+
+// checksumsAsset is the release asset listing the SHA-256 sum of every binary,
+// in sha256sum format.
+const checksumsAsset = "checksums.txt"
+
+// Release is a published kpfm release and the download URLs of its assets by name.
+type Release struct {
+	Tag    string
+	Assets map[string]string
+}
+
+// AssetName returns the name of the release binary for the running platform, e.g.
+// kpfm-linux-amd64 or kpfm-windows-amd64.exe.
+func AssetName() string {
+	name := fmt.Sprintf("kpfm-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// Latest fetches the latest release from GitHub.
+func Latest(ctx context.Context) (*Release, error) {
+	var body struct {
+		TagName string `json:"tag_name"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	resp, err := get(ctx, ReleasesURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid release metadata: %v", err)
+	}
+
+	release := &Release{Tag: body.TagName, Assets: make(map[string]string)}
+	for _, asset := range body.Assets {
+		release.Assets[asset.Name] = asset.URL
+	}
+	return release, nil
+}
+
+// Apply downloads the binary of release for the running platform, checks it against the
+// release checksums and atomically replaces executable with it.
+func Apply(ctx context.Context, release *Release, executable string) error {
+	name := AssetName()
+	binaryURL, ok := release.Assets[name]
+	if !ok {
+		return fmt.Errorf("release %s has no %s binary", release.Tag, name)
+	}
+	checksumsURL, ok := release.Assets[checksumsAsset]
+	if !ok {
+		return fmt.Errorf("release %s has no %s", release.Tag, checksumsAsset)
+	}
+
+	want, err := checksum(ctx, checksumsURL, name)
+	if err != nil {
+		return err
+	}
+
+	// Download next to the executable so the final rename stays on one filesystem.
+	tmp, err := ioutil.TempFile(filepath.Dir(executable), ".kpfm-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	resp, err := get(ctx, binaryURL)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	resp.Body.Close()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot download %s: %v", name, err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return replace(tmp.Name(), executable)
+}
+
+// checksum returns the SHA-256 sum of name listed in the checksums file at url.
+func checksum(ctx context.Context, url, name string) (string, error) {
+	resp, err := get(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// replace moves the new binary over executable. Windows refuses to overwrite a running
+// executable but allows renaming it out of the way first.
+func replace(newPath, executable string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(newPath, executable)
+	}
+	old := executable + ".old"
+	os.Remove(old)
+	if err := os.Rename(executable, old); err != nil {
+		return err
+	}
+	if err := os.Rename(newPath, executable); err != nil {
+		os.Rename(old, executable)
+		return err
+	}
+	return nil
+}
+
+func get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "kpfm-self-update")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
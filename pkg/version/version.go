@@ -0,0 +1,51 @@
+// Package version holds the build metadata set by the Makefile through -ldflags.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time with -ldflags "-X github.com/rparaujo/kpfm/pkg/version.Version=...".
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+func init() {
+	// `go install` builds carry no ldflags but know their module version and VCS revision.
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && Commit == "":
+			Commit = setting.Value
+		case setting.Key == "vcs.time" && Date == "":
+			Date = setting.Value
+		}
+	}
+}
+
+// String describes the build in one line.
+func String() string {
+	s := "kpfm " + Version
+	if Commit != "" {
+		commit := Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		s += " (" + commit
+		if Date != "" {
+			s += ", " + Date
+		}
+		s += ")"
+	}
+	return fmt.Sprintf("%s %s %s/%s", s, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
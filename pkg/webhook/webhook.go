@@ -0,0 +1,142 @@
+// Package webhook posts to HTTP endpoints when a forward is marked broken and when it
+// recovers, so an instance shared by a team can alert a chat channel.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/rparaujo/kpfm/pkg/control"
+	"github.com/rparaujo/kpfm/pkg/manager"
+	"github.com/rparaujo/kpfm/pkg/model"
+)
+
+const postTimeout = 10 * time.Second
+
+// DefaultTemplate is the message sent by webhooks that don't set a Template.
+const DefaultTemplate = `{{if .Recovered}}{{.Name}} recovered on pod {{.Pod}}{{else}}{{.Name}} is broken: {{.Error}}{{end}} (context {{.Context}}, kpfm on {{.Host}})`
+
+// Message is what message templates are executed with: the event, whether it is a
+// recovery, and the host kpfm runs on.
+type Message struct {
+	control.Event
+	Recovered bool
+	Host      string
+}
+
+// payload is the body posted by json webhooks.
+type payload struct {
+	Text      string
+	Recovered bool
+	Host      string
+	Event     control.Event
+}
+
+// Notifier posts the broken and recovered events of a manager to webhooks.
+type Notifier struct {
+	hooks  []hook
+	host   string
+	client *http.Client
+}
+
+type hook struct {
+	model.Webhook
+	tmpl *template.Template
+}
+
+// New parses the templates of the webhooks.
+func New(webhooks []model.Webhook) (*Notifier, error) {
+	host, _ := os.Hostname()
+	n := &Notifier{host: host, client: &http.Client{Timeout: postTimeout}}
+	for i, webhook := range webhooks {
+		if webhook.URL == "" {
+			return nil, fmt.Errorf("webhook %d has no URL", i+1)
+		}
+		text := webhook.Template
+		if text == "" {
+			text = DefaultTemplate
+		}
+		tmpl, err := template.New(webhook.URL).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %v", webhook.URL, err)
+		}
+		n.hooks = append(n.hooks, hook{Webhook: webhook, tmpl: tmpl})
+	}
+	return n, nil
+}
+
+// Run notifies the webhooks of the events received until events is closed. A forward
+// that recovers is one that was broken, restarted and then became ready again.
+func (n *Notifier) Run(ctx context.Context, events <-chan manager.Event) {
+	broken := make(map[string]bool)
+	for event := range events {
+		key := manager.ForwardID(event.Context, event.Name)
+		switch event.Type {
+		case manager.EventBroken:
+			broken[key] = true
+			n.notify(ctx, Message{Event: control.NewEvent(event), Host: n.host})
+		case manager.EventReady:
+			if broken[key] {
+				delete(broken, key)
+				n.notify(ctx, Message{Event: control.NewEvent(event), Recovered: true, Host: n.host})
+			}
+		case manager.EventStopped:
+			delete(broken, key)
+		}
+	}
+}
+
+// notify posts a message to every webhook without waiting for the responses.
+func (n *Notifier) notify(ctx context.Context, message Message) {
+	for _, h := range n.hooks {
+		h := h
+		go func() {
+			if err := n.post(ctx, h, message); err != nil {
+				log.Printf("Webhook %s failed: %v", h.URL, err)
+			}
+		}()
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, h hook, message Message) error {
+	var text bytes.Buffer
+	if err := h.tmpl.Execute(&text, message); err != nil {
+		return err
+	}
+	var body interface{}
+	switch h.Format {
+	case model.WebhookSlack:
+		body = map[string]string{"text": text.String()}
+	default:
+		body = payload{Text: text.String(), Recovered: message.Recovered, Host: message.Host, Event: message.Event}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}